@@ -0,0 +1,198 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+
+	"github.com/cockroachdb/apd/v2"
+	compact_float "github.com/kstenerud/go-compact-float"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Integers with more trailing zeros than this are kept as decimal floats
+// rather than being expanded.
+const maxCanonicalIntegerExponent = 64
+
+// Create an event source that produces the canonical form of the document
+// read from another source.
+func canonicalSource(source eventSource, in io.Reader) (eventSource, error) {
+	document, err := buildDocument(source, in)
+	if err != nil {
+		return nil, err
+	}
+	if document, err = canonicalize(document); err != nil {
+		return nil, err
+	}
+	return valueSource(document), nil
+}
+
+// Convert a document tree value to its canonical form, so that equal data
+// always encodes to the same bytes regardless of where it came from:
+//   - Map entries (and records, which become maps) are sorted by key.
+//   - Numbers are converted to the smallest type that represents their value:
+//     integers (of any original type) become integers, and other finite
+//     numbers become decimal floats in their shortest form. Binary floats are
+//     first converted to the shortest decimal that round-trips.
+//   - Strings (including map keys) are converted to Unicode normalization form
+//     C (NFC), so that different spellings of the same text are equal.
+func canonicalize(value interface{}) (result interface{}, err error) {
+	switch v := value.(type) {
+	case string:
+		return norm.NFC.String(v), nil
+	case int64, uint64, *big.Int, float64, *big.Float, compact_float.DFloat, *apd.Decimal:
+		return canonicalNumber(v), nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, elem := range v {
+			if list[i], err = canonicalize(elem); err != nil {
+				return
+			}
+		}
+		return list, nil
+	case *orderedMap:
+		return canonicalMap(v)
+	case *markedValue:
+		marked := &markedValue{id: v.id}
+		if marked.value, err = canonicalize(v.value); err != nil {
+			return
+		}
+		return marked, nil
+	case *nodeValue:
+		node := &nodeValue{children: make([]interface{}, len(v.children))}
+		if node.value, err = canonicalize(v.value); err != nil {
+			return
+		}
+		for i, child := range v.children {
+			if node.children[i], err = canonicalize(child); err != nil {
+				return
+			}
+		}
+		return node, nil
+	case *edgeValue:
+		edge := &edgeValue{}
+		if edge.source, err = canonicalize(v.source); err != nil {
+			return
+		}
+		if edge.description, err = canonicalize(v.description); err != nil {
+			return
+		}
+		if edge.destination, err = canonicalize(v.destination); err != nil {
+			return
+		}
+		return edge, nil
+	default:
+		return value, nil
+	}
+}
+
+// Canonicalize and sort the entries of a map. Keys that were different in the
+// original (such as two spellings of the same text, or 1 and 1.0) can become
+// equal, which is an error because a map can't contain duplicate keys.
+func canonicalMap(original *orderedMap) (result *orderedMap, err error) {
+	type canonicalEntry struct {
+		originalKey interface{}
+		entry       mapEntry
+	}
+	entries := make([]canonicalEntry, len(original.entries))
+	for i, entry := range original.entries {
+		entries[i].originalKey = entry.key
+		if entries[i].entry.key, err = canonicalize(entry.key); err != nil {
+			return
+		}
+		if entries[i].entry.value, err = canonicalize(entry.value); err != nil {
+			return
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return canonicalKeyLess(entries[i].entry.key, entries[j].entry.key)
+	})
+
+	result = &orderedMap{entries: make([]mapEntry, len(entries))}
+	for i, entry := range entries {
+		if i > 0 && valuesEqual(entries[i-1].entry.key, entry.entry.key) {
+			a, b := entries[i-1].originalKey, entry.originalKey
+			if _, isString := a.(string); isString {
+				return nil, fmt.Errorf("map keys %+q and %+q are equal after NFC normalization", a, b)
+			}
+			return nil, fmt.Errorf("map keys %v and %v are equal in canonical form", a, b)
+		}
+		result.entries[i] = entry.entry
+	}
+	return
+}
+
+func canonicalNumber(value interface{}) interface{} {
+	d, ok := toDecimal(value)
+	if !ok {
+		// NaN
+		return value
+	}
+
+	if d.Form == apd.Infinite {
+		if d.Negative {
+			return math.Inf(-1)
+		}
+		return math.Inf(1)
+	}
+	if d.IsZero() {
+		if d.Negative {
+			return math.Copysign(0, -1)
+		}
+		return uint64(0)
+	}
+
+	reduced := new(apd.Decimal)
+	reduced.Reduce(d)
+	if reduced.Exponent < 0 || reduced.Exponent > maxCanonicalIntegerExponent {
+		return reduced
+	}
+
+	integer := new(big.Int).Set(&reduced.Coeff)
+	integer.Mul(integer, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(reduced.Exponent)), nil))
+	if reduced.Negative {
+		integer.Neg(integer)
+	}
+	switch {
+	case integer.IsUint64():
+		return integer.Uint64()
+	case integer.IsInt64():
+		return integer.Int64()
+	default:
+		return integer
+	}
+}
+
+// Order map keys by type, then by value.
+func canonicalKeyLess(a, b interface{}) bool {
+	a, b = unmarked(a), unmarked(b)
+	if categoryA, categoryB := typeCategory(a), typeCategory(b); categoryA != categoryB {
+		return categoryA < categoryB
+	}
+	if result, ok := compareValues(a, b); ok {
+		return result < 0
+	}
+	return fmt.Sprintf("%v", a) < fmt.Sprintf("%v", b)
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func canonicalizeJSON(t *testing.T, text string) interface{} {
+	t.Helper()
+	canonical, err := canonicalize(parseJSON(t, text))
+	if err != nil {
+		t.Fatalf("%v: %v", text, err)
+	}
+	return canonical
+}
+
+// Each group of documents differs only in ways that canonicalization must
+// remove, and so must produce the same canonical form.
+func TestCanonicalizeEquivalentDocuments(t *testing.T) {
+	groups := [][]string{
+		{`{"a":1,"b":2}`, `{"b":2,"a":1}`},
+		{`{"z":{"y":1,"x":2},"a":[3,{"c":1,"b":2}]}`, `{"a":[3,{"b":2,"c":1}],"z":{"x":2,"y":1}}`},
+		{`1`, `1.0`, `1e0`, `10e-1`, `0.001e3`},
+		{`1.5`, `1.50`, `15e-1`, `0.15e1`},
+		{`-25`, `-25.00`, `-2.5e1`},
+		{`0`, `0.0`, `0e10`},
+		{`-0.0`, `-0`, `-0e5`},
+		{`1000000000000000000000`, `1e21`, `1.0e21`},
+		{`1e100`, `10e99`, `1.00e100`},
+		{`"\u00e9"`, `"e\u0301"`},
+		{`{"\u00e9":1}`, `{"e\u0301":1}`},
+		{`{"b":1,"\u00e9":2}`, `{"e\u0301":2,"b":1}`},
+	}
+
+	for _, group := range groups {
+		expected := formatDiffValue(canonicalizeJSON(t, group[0]))
+		for _, text := range group {
+			canonical := canonicalizeJSON(t, text)
+			if actual := formatDiffValue(canonical); actual != expected {
+				t.Errorf("%v: expected canonical form %v but got %v", text, expected, actual)
+			}
+			again, err := canonicalize(canonical)
+			if err != nil {
+				t.Errorf("%v: canonicalizing again: %v", text, err)
+			} else if again := formatDiffValue(again); again != expected {
+				t.Errorf("%v: canonicalizing again changed %v to %v", text, expected, again)
+			}
+		}
+	}
+}
+
+func TestCanonicalizeNumbers(t *testing.T) {
+	tests := []struct {
+		json     string
+		expected interface{}
+	}{
+		{`0`, uint64(0)},
+		{`0.0`, uint64(0)},
+		{`100`, uint64(100)},
+		{`1.0e2`, uint64(100)},
+		{`-100`, int64(-100)},
+		{`-1.0e2`, int64(-100)},
+		{`18446744073709551615`, uint64(math.MaxUint64)},
+		{`-9223372036854775808`, int64(math.MinInt64)},
+		{`-0.0`, math.Copysign(0, -1)},
+	}
+
+	for _, test := range tests {
+		actual := canonicalizeJSON(t, test.json)
+		if actual != test.expected {
+			t.Errorf("%v: expected %T %v but got %T %v", test.json, test.expected, test.expected, actual, actual)
+		} else if f, ok := actual.(float64); ok && math.Signbit(f) != math.Signbit(test.expected.(float64)) {
+			t.Errorf("%v: expected %T %v but got %T %v", test.json, test.expected, test.expected, actual, actual)
+		}
+	}
+}
+
+func TestCanonicalizeNFC(t *testing.T) {
+	canonical := canonicalizeJSON(t, `{"e\u0301":["A\u030a"]}`).(*orderedMap)
+	if key := canonical.entries[0].key; key != "\u00e9" {
+		t.Errorf("expected NFC key %q but got %q", "\u00e9", key)
+	}
+	if value := canonical.entries[0].value.([]interface{})[0]; value != "\u00c5" {
+		t.Errorf("expected NFC value %q but got %q", "\u00c5", value)
+	}
+}
+
+// Keys that are different in the original document but equal in canonical
+// form can't both be kept.
+func TestCanonicalizeEqualKeys(t *testing.T) {
+	tests := []struct {
+		json     string
+		expected string
+	}{
+		{`{"\u00e9":1,"e\u0301":2}`, `map keys "\u00e9" and "e\u0301" are equal after NFC normalization`},
+		{`{"a":{"e\u0301":1,"\u00e9":2}}`, `map keys "e\u0301" and "\u00e9" are equal after NFC normalization`},
+		{`[{"A\u030a":1,"b":2,"\u00c5":3}]`, `map keys "A\u030a" and "\u00c5" are equal after NFC normalization`},
+	}
+
+	for _, test := range tests {
+		_, err := canonicalize(parseJSON(t, test.json))
+		if err == nil {
+			t.Errorf("%v: expected an error", test.json)
+		} else if err.Error() != test.expected {
+			t.Errorf("%v: expected error %q but got %q", test.json, test.expected, err)
+		}
+	}
+}
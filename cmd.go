@@ -26,6 +26,19 @@ import (
 
 var ErrorUsage = errors.New("")
 
+// An error that should cause the process to exit with a specific status code.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (_this *exitCodeError) Error() string { return _this.err.Error() }
+func (_this *exitCodeError) Unwrap() error { return _this.err }
+
+func withExitCode(code int, err error) error {
+	return &exitCodeError{code: code, err: err}
+}
+
 type Command interface {
 	Name() string
 	Description() string
@@ -25,7 +25,9 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"runtime"
 	"strings"
+	"time"
 )
 
 type cmdConvert struct {
@@ -33,6 +35,22 @@ type cmdConvert struct {
 	dstWriter     io.Writer
 	converter     converter
 	encoderConfig encoderConfig
+
+	srcFormat    string
+	dstFormat    string
+	readAdapter  readAdapter
+	writeAdapter writeAdapter
+
+	// Batch mode
+	srcPaths []string
+	dstDir   string
+	dstExt   string
+	workers  int
+
+	// Watch mode
+	watch         bool
+	watchInterval time.Duration
+	watchFile     batchJob
 }
 
 func (_this *cmdConvert) Name() string { return "convert" }
@@ -41,10 +59,28 @@ func (_this *cmdConvert) Description() string { return "Convert between formats"
 
 func (_this *cmdConvert) Usage() string {
 	fs, _ := _this.newFlagSet()
-	return getFlagsUsage(fs)
+	return "Usage: convert [options] [files, globs or directories...]\n" + getFlagsUsage(fs) + `
+If files, globs or directories are given, they are all converted concurrently
+into the output directory (-od), continuing past failures. Directories are
+searched recursively for files with the source format's extension (or any
+known format's extension if -sf isn't given), and their structure is kept in
+the output directory. Each output file gets the destination format's extension
+(or -de).
+
+With -watch, the source files are polled for changes, and converted whenever
+they change until interrupted. Errors are reported without stopping. In batch
+mode, new files matching the globs or directories are converted as they appear.
+Otherwise, -s and -d must both be files.
+`
 }
 
 func (_this *cmdConvert) Run() (err error) {
+	if _this.watch {
+		return _this.runWatch()
+	}
+	if len(_this.srcPaths) > 0 {
+		return _this.runBatch()
+	}
 	err = _this.converter(_this.srcReader, _this.dstWriter, &_this.encoderConfig)
 	return
 }
@@ -59,56 +95,53 @@ func (_this *cmdConvert) Init(args []string) (err error) {
 	if err != nil {
 		return
 	}
-	if srcFile == "" {
-		srcFile = "-"
-	}
 
 	dstFile, err := fields.getString("d", "Destination file")
 	if err != nil {
 		return
 	}
-	if dstFile == "" {
-		dstFile = "-"
-	}
 
-	srcFormat, err := fields.getString("sf", "Source format")
+	_this.srcFormat, err = fields.getString("sf", "Source format")
 	if err != nil {
 		return
 	}
-	dstFormat, err := fields.getRequiredString("df", "Destination format")
+	_this.srcFormat = strings.ToLower(_this.srcFormat)
+	_this.dstFormat, err = fields.getRequiredString("df", "Destination format")
 	if err != nil {
 		return
 	}
+	_this.dstFormat = strings.ToLower(_this.dstFormat)
 
-	readAdapter := readAdapterNone
+	_this.readAdapter = readAdapterNone
 	if fields.getBool("x") {
-		readAdapter = readAdapterHex
+		_this.readAdapter = readAdapterHex
 	}
 	if fields.getBool("t") {
-		if readAdapter != readAdapterNone {
+		if _this.readAdapter != readAdapterNone {
 			return fmt.Errorf("cannot choose modes -x and -t simultaneously")
 		}
-		readAdapter = readAdapterText
+		_this.readAdapter = readAdapterText
 	}
 
-	writeAdapter := writeAdapterNone
+	_this.writeAdapter = writeAdapterNone
 	if fields.getBool("X") {
-		writeAdapter = writeAdapterHex
+		_this.writeAdapter = writeAdapterHex
 	}
 	if fields.getBool("C") {
-		if writeAdapter != writeAdapterNone {
+		if _this.writeAdapter != writeAdapterNone {
 			return fmt.Errorf("cannot choose more than one of -X -C -S simultaneously")
 		}
-		writeAdapter = writeAdapterC
+		_this.writeAdapter = writeAdapterC
 	}
 	if fields.getBool("S") {
-		if writeAdapter != writeAdapterNone {
+		if _this.writeAdapter != writeAdapterNone {
 			return fmt.Errorf("cannot choose more than one of -X -C -S simultaneously")
 		}
-		writeAdapter = writeAdapterStringify
+		_this.writeAdapter = writeAdapterStringify
 	}
 
 	_this.encoderConfig.indentSpaces = int(fields.getUint("i"))
+	_this.encoderConfig.canonical = fields.getBool("canonical")
 	_this.encoderConfig.invertText = fields.getBool("I")
 	_this.encoderConfig.imageSize = fields.getUint("is")
 	_this.encoderConfig.errorCorrection = fields.getUint("e")
@@ -116,45 +149,96 @@ func (_this *cmdConvert) Init(args []string) (err error) {
 		return fmt.Errorf("error correction max is 3")
 	}
 	_this.encoderConfig.borderSize = fields.getUint("b")
+	_this.encoderConfig.qrPartSize = fields.getUint("qs")
+	_this.encoderConfig.qrPartPath, err = fields.getString("qp", "QR part file pattern")
+	if err != nil {
+		return
+	}
+	_this.encoderConfig.qrStyle, err = fields.getString("qf", "QR style")
+	if err != nil {
+		return
+	}
+	_this.encoderConfig.qrStyle = strings.ToLower(_this.encoderConfig.qrStyle)
+	if _this.encoderConfig.qrStyle != "" && !isKnownQRStyle(_this.encoderConfig.qrStyle) {
+		return fmt.Errorf("%v: Unknown QR style", _this.encoderConfig.qrStyle)
+	}
+	if _this.encoderConfig.qrPartPath != "" && !strings.Contains(_this.encoderConfig.qrPartPath, "*") {
+		return fmt.Errorf("QR part file pattern must contain a * to be replaced by the part number")
+	}
+
+	_this.watch = fields.getBool("watch")
+	_this.watchInterval = time.Duration(fields.getUint("wi")) * time.Millisecond
+	if _this.watch && _this.watchInterval == 0 {
+		return usageError("Watch interval must be greater than 0")
+	}
+
+	if fs.NArg() > 0 {
+		return _this.initBatch(fs.Args(), srcFile, dstFile, fields)
+	}
+	if _this.watch {
+		return _this.initWatchFile(srcFile, dstFile)
+	}
+
+	if srcFile == "" {
+		srcFile = "-"
+	}
+	if dstFile == "" {
+		dstFile = "-"
+	}
 
 	_this.srcReader, err = openFileRead(srcFile)
 	if err != nil {
-		return fmt.Errorf("error opening %v: %v", srcFile, err)
+		return
+	}
+	_this.srcReader, _this.converter, err = _this.prepareSource(srcFile, _this.srcReader)
+	if err != nil {
+		return
+	}
+
+	_this.dstWriter, err = openFileWrite(dstFile)
+	if err != nil {
+		return
 	}
-	switch readAdapter {
+	_this.dstWriter = _this.adaptWriter(_this.dstWriter)
+
+	return
+}
+
+// Prepare a source file's reader by applying the read adapter and detecting
+// its format if needed, and get a converter from its format to the destination
+// format.
+func (_this *cmdConvert) prepareSource(srcFile string, reader io.Reader) (io.Reader, converter, error) {
+	switch _this.readAdapter {
 	case readAdapterHex:
-		_this.srcReader = newHexReader(_this.srcReader)
+		reader = newHexReader(reader)
 	case readAdapterText:
-		_this.srcReader = newTextByteReader(_this.srcReader)
+		reader = newTextByteReader(reader)
 	}
 
+	srcFormat := _this.srcFormat
 	if len(srcFormat) == 0 {
-		bufReader := bufio.NewReader(_this.srcReader)
-		_this.srcReader = bufReader
-		srcFormat, err = detectSrcFormat(bufReader)
-		if err != nil {
-			return fmt.Errorf("error detecting source format of %v: %v", srcFile, err)
+		bufReader := bufio.NewReader(reader)
+		reader = bufReader
+		var err error
+		if srcFormat, err = detectSrcFormat(bufReader); err != nil {
+			return nil, nil, fmt.Errorf("error detecting source format of %v: %v", srcFile, err)
 		}
 	}
 
-	converterID := strings.ToLower(srcFormat) + "-" + strings.ToLower(dstFormat)
-
-	_this.converter, err = getConverter(converterID)
-	if err != nil {
-		return err
-	}
+	converter, err := getConverter(strings.ToLower(srcFormat), _this.dstFormat)
+	return reader, converter, err
+}
 
-	_this.dstWriter, err = openFileWrite(dstFile)
-	switch writeAdapter {
+func (_this *cmdConvert) adaptWriter(writer io.Writer) io.Writer {
+	switch _this.writeAdapter {
 	case writeAdapterC:
-		_this.dstWriter = newCWriter(_this.dstWriter)
+		return newCWriter(writer)
 	case writeAdapterHex:
-		_this.dstWriter = newHexWriter(_this.dstWriter)
+		return newHexWriter(writer)
 	case writeAdapterStringify:
-		_this.dstWriter = newStringifyWriter(_this.dstWriter)
+		return newStringifyWriter(writer)
 	}
-
-	return
+	return writer
 }
 
 func (_this *cmdConvert) newFlagSet() (fs *flag.FlagSet, fields fieldValues) {
@@ -165,15 +249,24 @@ func (_this *cmdConvert) newFlagSet() (fs *flag.FlagSet, fields fieldValues) {
 	fields["s"] = fs.String("s", "", "The source file to read from (- for stdin) (defaults to stdin)")
 	fields["d"] = fs.String("d", "", "The destination file to write to (- for stdout) (defaults to stdout)")
 	fields["i"] = fs.Uint("i", 0, "Indentation to use")
+	fields["canonical"] = fs.Bool("canonical", false, "Write the canonical form of the document (sorted map keys, minimal numbers, NFC normalized strings)")
 	fields["t"] = fs.Bool("t", false, "Interpret source as text-encoded byte values (can be decimal numbers or 0xff style hex, separated by non-numeric chars)")
 	fields["x"] = fs.Bool("x", false, "Interpret source as text hex-encoded byte values (2 digits per byte), separated by non-numeric chars")
 	fields["X"] = fs.Bool("X", false, "write destination as text hex-encoded byte values (2 digits per byte), separated a space")
 	fields["C"] = fs.Bool("C", false, "write destination as C-style byte values (in the format '0xab, 0xcd, ...')")
 	fields["S"] = fs.Bool("S", false, "write destination as stringified (escapes \" and \\ characters)")
-	fields["I"] = fs.Bool("I", false, "Invert text color (for text-mode QR codes only)")
-	fields["is"] = fs.Uint("is", 256, "Target image size in pixels (for image QR codes only)")
+	fields["I"] = fs.Bool("I", false, "Invert colors (for text, ANSI, SVG and EPS QR codes only)")
+	fields["is"] = fs.Uint("is", defaultQRImageSize, "Target image size in pixels (for image QR codes only)")
 	fields["e"] = fs.Uint("e", 0, "Error correction level 0=lowest, 3=highest (for image QR codes only)")
-	fields["b"] = fs.Uint("b", 4, "Border size (for image QR codes only)")
+	fields["b"] = fs.Uint("b", defaultQRBorderSize, "Border size (for image QR codes only)")
+	fields["qs"] = fs.Uint("qs", 0, "Maximum bytes per QR code; bigger documents are split across multiple codes (0 = as many as fit)")
+	fields["qf"] = fs.String("qf", "", "QR style: png (default), svg or eps for qr; text (default) or ansi for qrt")
+	fields["qp"] = fs.String("qp", "", "Also write each code of a multi-part QR document to its own file, replacing * in this pattern with the part number")
+	fields["od"] = fs.String("od", "", "The directory to write to when converting multiple files (required for batch conversion)")
+	fields["de"] = fs.String("de", "", "The extension to give files converted in batch mode (defaults to the destination format)")
+	fields["j"] = fs.Uint("j", uint(runtime.NumCPU()), "The number of files to convert concurrently in batch mode")
+	fields["watch"] = fs.Bool("watch", false, "Keep running, and convert the source files again whenever they change")
+	fields["wi"] = fs.Uint("wi", 500, "How often to check for changes in watch mode (milliseconds)")
 
 	return
 }
@@ -0,0 +1,210 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type cmdDetect struct {
+	srcFiles       []string
+	dstFormat      string
+	showCandidates bool
+	encoderConfig  encoderConfig
+}
+
+func (_this *cmdDetect) Name() string { return "detect" }
+
+func (_this *cmdDetect) Description() string { return "Detect the format of documents" }
+
+func (_this *cmdDetect) Usage() string {
+	fs, _ := _this.newFlagSet()
+	return "Usage: detect [options] [files...]\n" + getFlagsUsage(fs)
+}
+
+func (_this *cmdDetect) Run() (err error) {
+	var results []interface{}
+	failures := 0
+	for _, srcFile := range _this.srcFiles {
+		result := _this.detect(srcFile)
+		if _, ok := result.Get("error"); ok {
+			failures++
+		}
+		results = append(results, result)
+	}
+
+	if _this.dstFormat == "text" {
+		for _, result := range results {
+			_this.printResult(result.(*orderedMap))
+		}
+	} else {
+		if err = writeValue(results, _this.dstFormat, os.Stdout, &_this.encoderConfig); err != nil {
+			return
+		}
+		fmt.Println()
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("could not detect the format of %v file(s)", failures)
+	}
+	return
+}
+
+func (_this *cmdDetect) detect(srcFile string) *orderedMap {
+	reader, err := openFileRead(srcFile)
+	if err != nil {
+		return newOrderedMap().Set("file", srcFile).Set("error", err.Error())
+	}
+	if f, ok := reader.(*os.File); ok && f != os.Stdin {
+		defer f.Close()
+	}
+	return detectReader(srcFile, reader)
+}
+
+// Detect the format of a document read from reader, returning a report.
+func detectReader(srcFile string, reader io.Reader) *orderedMap {
+	result := newOrderedMap().Set("file", srcFile)
+
+	bufReader, layers, err := unwrapLayers(bufio.NewReader(reader))
+	if err != nil {
+		return result.Set("error", err.Error())
+	}
+	detections, err := detectFormats(bufReader)
+	if err != nil {
+		return result.Set("error", err.Error())
+	}
+	if len(detections) == 0 {
+		return result.Set("error", "unrecognized format")
+	}
+
+	best := detections[0]
+	result.Set("format", best.format)
+	if best.version != "" {
+		result.Set("version", best.version)
+	}
+	result.Set("confidence", best.confidence)
+	if layers == nil {
+		layers = []string{}
+	}
+	result.Set("layers", layers)
+	// Only detect looks inside wrapping layers; other commands need the
+	// document decompressed first.
+	result.Set("convertible", len(layers) == 0)
+
+	var candidates []interface{}
+	for _, d := range detections[1:] {
+		candidates = append(candidates, newOrderedMap().
+			Set("format", d.format).
+			Set("confidence", d.confidence))
+	}
+	if candidates == nil {
+		candidates = []interface{}{}
+	}
+	result.Set("candidates", candidates)
+	return result
+}
+
+func (_this *cmdDetect) printResult(result *orderedMap) {
+	file, _ := result.Get("file")
+	if errorMessage, ok := result.Get("error"); ok {
+		fmt.Printf("%v: %v\n", file, errorMessage)
+		return
+	}
+
+	format, _ := result.Get("format")
+	description := fmt.Sprintf("%v", format)
+	if version, ok := result.Get("version"); ok {
+		description += fmt.Sprintf(" version %v", version)
+	}
+	layers, _ := result.Get("layers")
+	if len(layers.([]string)) > 0 {
+		description = strings.Join(layers.([]string), " > ") + " > " + description
+	}
+	confidence, _ := result.Get("confidence")
+	description += fmt.Sprintf(" (confidence %v%%)", confidence)
+	if convertible, _ := result.Get("convertible"); convertible == false {
+		description += " (decompress before converting)"
+	}
+	fmt.Printf("%v: %v\n", file, description)
+
+	if _this.showCandidates {
+		candidates, _ := result.Get("candidates")
+		for _, c := range candidates.([]interface{}) {
+			candidate := c.(*orderedMap)
+			format, _ := candidate.Get("format")
+			confidence, _ := candidate.Get("confidence")
+			fmt.Printf("    or %v (confidence %v%%)\n", format, confidence)
+		}
+	}
+}
+
+func (_this *cmdDetect) Init(args []string) (err error) {
+	fs, fields := _this.newFlagSet()
+	if err = parseFlagsQuietly(fs, args); err != nil {
+		return usageError("%v", err)
+	}
+
+	srcFile, err := fields.getString("f", "File")
+	if err != nil {
+		return
+	}
+	if srcFile != "" {
+		_this.srcFiles = append(_this.srcFiles, srcFile)
+	}
+	_this.srcFiles = append(_this.srcFiles, fs.Args()...)
+	if len(_this.srcFiles) == 0 {
+		_this.srcFiles = []string{"-"}
+	}
+
+	_this.dstFormat, err = fields.getString("o", "Output format")
+	if err != nil {
+		return
+	}
+	_this.dstFormat = strings.ToLower(_this.dstFormat)
+	if _this.dstFormat != "text" && knownSinks[_this.dstFormat] == nil {
+		return usageError("%v: Unknown output format", _this.dstFormat)
+	}
+
+	_this.showCandidates = fields.getBool("a")
+	_this.encoderConfig = newEncoderConfig()
+	_this.encoderConfig.indentSpaces = int(fields.getUint("i"))
+	return
+}
+
+func (_this *cmdDetect) newFlagSet() (fs *flag.FlagSet, fields fieldValues) {
+	fields = make(fieldValues)
+	fs = flag.NewFlagSet("detect", flag.ContinueOnError)
+	fields["f"] = fs.String("f", "", "File to read from (- for stdin) (defaults to stdin if no files are given)")
+	fields["o"] = fs.String("o", "text", "Output format (text, or any destination format such as cte or json)")
+	fields["a"] = fs.Bool("a", false, "Also list the less likely candidate formats (text output only)")
+	fields["i"] = fs.Uint("i", 0, "Indentation to use (for non-text output)")
+
+	return
+}
+
+func init() {
+	addCommand(new(cmdDetect))
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Exit codes returned by diff
+const (
+	diffExitDifferent = 1 // The documents differ
+	diffExitError     = 2 // A document couldn't be read
+)
+
+type cmdDiff struct {
+	oldFile       string
+	newFile       string
+	oldFormat     string
+	newFormat     string
+	dstFormat     string
+	encoderConfig encoderConfig
+}
+
+func (_this *cmdDiff) Name() string { return "diff" }
+
+func (_this *cmdDiff) Description() string {
+	return "Show the structural differences between documents"
+}
+
+func (_this *cmdDiff) Usage() string {
+	fs, _ := _this.newFlagSet()
+	return "Usage: diff [options] <old file> <new file>\n" + getFlagsUsage(fs) + `
+Changes are reported by JSON Pointer style path. Within a node, index 0 is the
+node's value and its children begin at index 1. Within an edge, indexes 0, 1
+and 2 are the source, description and destination.
+` +
+		fmt.Sprintf("\nExit status is 0 if the documents are equivalent, %v if they differ, and %v if a document could not be read.\n",
+			diffExitDifferent, diffExitError)
+}
+
+func (_this *cmdDiff) Run() (err error) {
+	oldDoc, _, err := readDocument(_this.oldFile, _this.oldFormat)
+	if err != nil {
+		return withExitCode(diffExitError, err)
+	}
+	newDoc, _, err := readDocument(_this.newFile, _this.newFormat)
+	if err != nil {
+		return withExitCode(diffExitError, err)
+	}
+
+	changes := diffDocuments(oldDoc, newDoc)
+	if _this.dstFormat == "text" {
+		_this.printChanges(changes)
+	} else {
+		results := []interface{}{}
+		for _, change := range changes {
+			results = append(results, diffChangeReport(change))
+		}
+		if err = writeValue(results, _this.dstFormat, os.Stdout, &_this.encoderConfig); err != nil {
+			return withExitCode(diffExitError, err)
+		}
+		if textFormats[_this.dstFormat] {
+			fmt.Println()
+		}
+	}
+
+	if len(changes) > 0 {
+		return withExitCode(diffExitDifferent, fmt.Errorf("documents differ in %v place(s)", len(changes)))
+	}
+	return
+}
+
+func diffChangeReport(change diffChange) *orderedMap {
+	report := newOrderedMap().Set("op", string(change.op))
+	switch change.op {
+	case diffMoved:
+		report.Set("from", change.from).Set("path", change.path)
+	case diffAdded:
+		report.Set("path", change.path).Set("value", change.newValue)
+	case diffRemoved:
+		report.Set("path", change.path).Set("value", change.oldValue)
+	case diffTypeChanged:
+		report.Set("path", change.path).
+			Set("oldType", typeCategory(change.oldValue)).
+			Set("newType", typeCategory(change.newValue)).
+			Set("old", change.oldValue).
+			Set("new", change.newValue)
+	default:
+		report.Set("path", change.path).Set("old", change.oldValue).Set("new", change.newValue)
+	}
+	return report
+}
+
+func (_this *cmdDiff) printChanges(changes []diffChange) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Printf("--- %v\n+++ %v\n", _this.oldFile, _this.newFile)
+	for _, change := range changes {
+		path := change.path
+		if path == "" {
+			path = "/"
+		}
+		switch change.op {
+		case diffAdded:
+			fmt.Printf("+ %v: %v\n", path, formatDiffValue(change.newValue))
+		case diffRemoved:
+			fmt.Printf("- %v: %v\n", path, formatDiffValue(change.oldValue))
+		case diffMoved:
+			fmt.Printf("> %v -> %v\n", change.from, path)
+		case diffTypeChanged:
+			fmt.Printf("! %v: %v %v -> %v %v\n", path,
+				typeCategory(change.oldValue), formatDiffValue(change.oldValue),
+				typeCategory(change.newValue), formatDiffValue(change.newValue))
+		default:
+			fmt.Printf("~ %v: %v -> %v\n", path, formatDiffValue(change.oldValue), formatDiffValue(change.newValue))
+		}
+	}
+}
+
+// Format a value on a single line (as JSON, which most readers are familiar with).
+func formatDiffValue(value interface{}) string {
+	buffer := &bytes.Buffer{}
+	receiver, _ := jsonSink(buffer, &encoderConfig{})
+	if err := runSource(valueSource(value), nil, receiver); err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return buffer.String()
+}
+
+func (_this *cmdDiff) Init(args []string) (err error) {
+	fs, fields := _this.newFlagSet()
+	if err = parseFlagsQuietly(fs, args); err != nil {
+		return usageError("%v", err)
+	}
+
+	if fs.NArg() != 2 {
+		return usageError("Expected exactly two files to compare")
+	}
+	_this.oldFile = fs.Arg(0)
+	_this.newFile = fs.Arg(1)
+
+	if _this.oldFormat, err = fields.getString("of", "Old file format"); err != nil {
+		return
+	}
+	if _this.newFormat, err = fields.getString("nf", "New file format"); err != nil {
+		return
+	}
+
+	_this.dstFormat, err = fields.getString("o", "Output format")
+	if err != nil {
+		return
+	}
+	_this.dstFormat = strings.ToLower(_this.dstFormat)
+	if _this.dstFormat != "text" && knownSinks[_this.dstFormat] == nil {
+		return usageError("%v: Unknown output format", _this.dstFormat)
+	}
+
+	_this.encoderConfig = newEncoderConfig()
+	_this.encoderConfig.indentSpaces = int(fields.getUint("i"))
+	return
+}
+
+func (_this *cmdDiff) newFlagSet() (fs *flag.FlagSet, fields fieldValues) {
+	fields = make(fieldValues)
+	fs = flag.NewFlagSet("diff", flag.ContinueOnError)
+	fields["of"] = fs.String("of", "", "Old file format (auto-detected if not specified)")
+	fields["nf"] = fs.String("nf", "", "New file format (auto-detected if not specified)")
+	fields["o"] = fs.String("o", "text", "Output format (text, or any destination format such as cte or json)")
+	fields["i"] = fs.Uint("i", 0, "Indentation to use (for non-text output)")
+
+	return
+}
+
+func init() {
+	addCommand(new(cmdDiff))
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kstenerud/go-concise-encoding/configuration"
+	"github.com/kstenerud/go-concise-encoding/rules"
+)
+
+type cmdDump struct {
+	srcReader    io.Reader
+	indent       int
+	bytesPerLine int
+}
+
+func (_this *cmdDump) Name() string { return "dump" }
+
+func (_this *cmdDump) Description() string { return "Print an annotated byte dump of a CBE document" }
+
+func (_this *cmdDump) Usage() string {
+	fs, _ := _this.newFlagSet()
+	return "Usage: dump [options]\n" + getFlagsUsage(fs) + `
+Each line shows the offset, the bytes in hex, the type code that the bytes
+begin with, and the decoded value, indented by nesting level. Array chunk
+headers and data are shown on their own lines, without a type code.
+
+If the document is invalid, the bytes up to the point of failure are shown,
+followed by the error.
+`
+}
+
+func (_this *cmdDump) Run() (err error) {
+	reader := &byteCountingReader{reader: _this.srcReader}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	dumper := newCBEDumper(reader, out, _this.indent, _this.bytesPerLine)
+	if err = runSource(knownSources["cbe"], reader, rules.NewRules(dumper, configuration.New())); err != nil {
+		dumper.Flush("<error>")
+		err = fmt.Errorf("at offset %v: %v", reader.offset, err)
+	}
+	return
+}
+
+func (_this *cmdDump) Init(args []string) (err error) {
+	fs, fields := _this.newFlagSet()
+	if err = parseFlagsQuietly(fs, args); err != nil {
+		return usageError("%v", err)
+	}
+
+	if fs.NArg() != 0 {
+		return usageError("Unexpected arguments: %v", fs.Args())
+	}
+
+	srcFile, err := fields.getString("f", "File")
+	if err != nil {
+		return
+	}
+	if srcFile == "" {
+		srcFile = "-"
+	}
+
+	_this.indent = int(fields.getUint("i"))
+	_this.bytesPerLine = int(fields.getUint("w"))
+	if _this.bytesPerLine == 0 {
+		return usageError("Bytes per line must be greater than 0")
+	}
+
+	_this.srcReader, err = openFileRead(srcFile)
+	if err != nil {
+		return err
+	}
+	_this.srcReader = bufio.NewReader(_this.srcReader)
+
+	return
+}
+
+func (_this *cmdDump) newFlagSet() (fs *flag.FlagSet, fields fieldValues) {
+	fields = make(fieldValues)
+	fs = flag.NewFlagSet("dump", flag.ContinueOnError)
+	fields["f"] = fs.String("f", "", "CBE file to read from (- for stdin) (defaults to stdin)")
+	fields["i"] = fs.Uint("i", 2, "Indentation per nesting level (spaces)")
+	fields["w"] = fs.Uint("w", 8, "Maximum number of bytes to show per line")
+
+	return
+}
+
+func init() {
+	addCommand(new(cmdDump))
+}
@@ -0,0 +1,301 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/kstenerud/go-concise-encoding/configuration"
+	"github.com/kstenerud/go-concise-encoding/rules"
+)
+
+// Prefix of the comment lines used to show errors in the document being
+// edited. These lines are removed before the document is decoded.
+const editErrorPrefix = "// enctool: "
+
+// Formats that can't be written back the way they were, because their encoder
+// options (such as QR image size and style) can't be recovered from the file.
+var uneditableFormats = map[string]bool{
+	"qr":  true,
+	"qrt": true,
+}
+
+type cmdEdit struct {
+	srcFile       string
+	srcFormat     string
+	encoderConfig encoderConfig
+}
+
+func (_this *cmdEdit) Name() string { return "edit" }
+
+func (_this *cmdEdit) Description() string { return "Edit a document of any format as CTE" }
+
+func (_this *cmdEdit) Usage() string {
+	fs, _ := _this.newFlagSet()
+	return "Usage: edit [options] [file]\n" + getFlagsUsage(fs) + `
+Decodes a document to CTE in a temporary file and opens it in $VISUAL or
+$EDITOR (or vi if neither is set). Once the editor exits, the edited document
+is validated and written back to the file in its original format.
+
+If the edited document is invalid, the editor is opened again with the error
+added as a comment at the top. Save an empty document to abort without
+changing the file. CTE files are opened and written back exactly as they are;
+other formats are converted to CTE for editing, and text formats are written
+back using the indentation given by -i. QR codes can't be edited in place.
+`
+}
+
+func (_this *cmdEdit) Run() (err error) {
+	original, err := os.ReadFile(_this.srcFile)
+	if err != nil {
+		return
+	}
+	info, err := os.Stat(_this.srcFile)
+	if err != nil {
+		return
+	}
+
+	srcFormat := _this.srcFormat
+	if srcFormat == "" {
+		if srcFormat, err = detectSrcFormat(bufio.NewReader(bytes.NewReader(original))); err != nil {
+			return fmt.Errorf("%v: %v", _this.srcFile, err)
+		}
+	}
+	source := knownSources[srcFormat]
+	sink := knownSinks[srcFormat]
+	if source == nil || sink == nil {
+		return fmt.Errorf("%v: Documents in this format can't be edited", srcFormat)
+	}
+	if uneditableFormats[srcFormat] {
+		return fmt.Errorf("%v: Documents in this format can't be edited in place, since their encoding options would be lost (use convert to get a copy in another format)", srcFormat)
+	}
+
+	// CTE documents are edited as they are, so that they keep their formatting
+	// and comments.
+	initial := original
+	if srcFormat != "cte" {
+		buffer := bytes.Buffer{}
+		if err = pipe(source, bytes.NewReader(original), cteSink, &buffer, &_this.encoderConfig); err != nil {
+			return fmt.Errorf("%v: %v", _this.srcFile, err)
+		}
+		buffer.WriteByte('\n')
+		initial = buffer.Bytes()
+	}
+
+	tempFile, err := os.CreateTemp("", "enctool-"+filepath.Base(_this.srcFile)+"-*.cte")
+	if err != nil {
+		return
+	}
+	tempPath := tempFile.Name()
+	defer os.Remove(tempPath)
+	_, err = tempFile.Write(initial)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+
+	for {
+		if err = runEditor(tempPath); err != nil {
+			return
+		}
+		var edited []byte
+		if edited, err = os.ReadFile(tempPath); err != nil {
+			return
+		}
+		edited = stripEditErrors(edited)
+
+		if len(bytes.TrimSpace(edited)) == 0 {
+			fmt.Fprintln(os.Stderr, "Document is empty; edit aborted")
+			return
+		}
+		if bytes.Equal(edited, initial) {
+			fmt.Fprintln(os.Stderr, "No changes made")
+			return
+		}
+
+		encoded, encodeErr := _this.encode(edited, srcFormat, sink)
+		if encodeErr == nil {
+			return os.WriteFile(_this.srcFile, encoded, info.Mode().Perm())
+		}
+		if err = os.WriteFile(tempPath, addEditError(edited, encodeErr.(*editError)), 0600); err != nil {
+			return
+		}
+	}
+}
+
+// An error in an edited document, and where it occurred (-1 if unknown).
+type editError struct {
+	line   int64
+	column int64
+	err    error
+}
+
+func (_this *editError) Error() string { return _this.err.Error() }
+
+// Encode an edited CTE document in the destination format. CTE documents
+// are only validated, so that they keep their formatting and comments.
+func (_this *cmdEdit) encode(document []byte, dstFormat string, sink eventSink) (encoded []byte, err error) {
+	posReader := &positionReader{reader: bytes.NewReader(document)}
+	buffer := bytes.Buffer{}
+	if dstFormat == "cte" {
+		err = runSource(cteSource, posReader, rules.NewRules(new(nullEventReceiver), configuration.New()))
+		encoded = document
+	} else {
+		err = pipe(cteSource, posReader, sink, &buffer, &_this.encoderConfig)
+		if textFormats[dstFormat] {
+			buffer.WriteByte('\n')
+		}
+		encoded = buffer.Bytes()
+	}
+
+	if err != nil {
+		offset, line, column, _ := locateError(err, posReader)
+		if line < 0 && offset >= 0 {
+			line, column = posReader.LineColumn(offset)
+		}
+		return nil, &editError{line: line, column: column, err: err}
+	}
+	return
+}
+
+// Open a file in the user's editor, and wait for the editor to exit.
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	args := strings.Fields(editor)
+
+	cmd := exec.Command(args[0], append(args[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %v failed: %v", editor, err)
+	}
+	return nil
+}
+
+// Remove the error comments added by addEditError().
+func stripEditErrors(document []byte) []byte {
+	lines := bytes.SplitAfter(document, []byte("\n"))
+	kept := lines[:0]
+	for _, line := range lines {
+		if !bytes.HasPrefix(line, []byte(editErrorPrefix)) {
+			kept = append(kept, line)
+		}
+	}
+	return bytes.Join(kept, nil)
+}
+
+// Add an error as comment lines following the document's version header (or
+// at the top if the header can't be found).
+func addEditError(document []byte, err *editError) []byte {
+	insertAt := 0
+	insertLine := int64(0)
+	if trimmed := bytes.TrimLeft(document, " \t\r\n"); len(trimmed) > 0 && (trimmed[0] == 'c' || trimmed[0] == 'C') {
+		headerStart := len(document) - len(trimmed)
+		if newline := bytes.IndexByte(trimmed, '\n'); newline >= 0 {
+			insertAt = headerStart + newline + 1
+			insertLine = int64(bytes.Count(document[:insertAt], []byte("\n")))
+		}
+	}
+
+	messageLines := strings.Split(err.Error(), "\n")
+	messageLines = append(messageLines, "Fix the document and save it again, or save an empty document to abort.")
+	line := err.line
+	if line > insertLine {
+		// Account for the lines being inserted.
+		line += int64(len(messageLines) + 1)
+	}
+	switch {
+	case line >= 0 && err.column >= 0:
+		messageLines = append([]string{fmt.Sprintf("Error at line %v, column %v:", line, err.column)}, messageLines...)
+	case line >= 0:
+		messageLines = append([]string{fmt.Sprintf("Error at line %v:", line)}, messageLines...)
+	default:
+		messageLines = append([]string{"Error:"}, messageLines...)
+	}
+
+	message := strings.Builder{}
+	for _, messageLine := range messageLines {
+		message.WriteString(editErrorPrefix + messageLine + "\n")
+	}
+
+	result := make([]byte, 0, len(document)+message.Len())
+	result = append(result, document[:insertAt]...)
+	result = append(result, message.String()...)
+	return append(result, document[insertAt:]...)
+}
+
+func (_this *cmdEdit) Init(args []string) (err error) {
+	fs, fields := _this.newFlagSet()
+	if err = parseFlagsQuietly(fs, args); err != nil {
+		return usageError("%v", err)
+	}
+
+	if _this.srcFile, err = fields.getString("f", "File"); err != nil {
+		return
+	}
+	switch {
+	case fs.NArg() == 1 && _this.srcFile == "":
+		_this.srcFile = fs.Arg(0)
+	case fs.NArg() > 0:
+		return usageError("Unexpected arguments: %v", fs.Args())
+	}
+	if _this.srcFile == "" || _this.srcFile == "-" {
+		return usageError("A file is required, since it is edited in place")
+	}
+
+	if _this.srcFormat, err = fields.getString("fmt", "Format"); err != nil {
+		return
+	}
+	_this.srcFormat = strings.ToLower(_this.srcFormat)
+	_this.encoderConfig = newEncoderConfig()
+	_this.encoderConfig.indentSpaces = int(fields.getUint("i"))
+	return
+}
+
+func (_this *cmdEdit) newFlagSet() (fs *flag.FlagSet, fields fieldValues) {
+	fields = make(fieldValues)
+	fs = flag.NewFlagSet("edit", flag.ContinueOnError)
+	fields["fmt"] = fs.String("fmt", "", "File format (auto-detected if not specified)")
+	fields["f"] = fs.String("f", "", "File to edit")
+	fields["i"] = fs.Uint("i", 4, "Indentation to use when converting to CTE for editing (and for text formats when writing back)")
+
+	return
+}
+
+func init() {
+	addCommand(new(cmdEdit))
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	fmtExitUnformatted = 1
+	fmtExitError       = 2
+)
+
+type cmdFmt struct {
+	srcFiles      []string
+	write         bool
+	list          bool
+	encoderConfig encoderConfig
+}
+
+func (_this *cmdFmt) Name() string { return "fmt" }
+
+func (_this *cmdFmt) Description() string { return "Format CTE documents" }
+
+func (_this *cmdFmt) Usage() string {
+	fs, _ := _this.newFlagSet()
+	return "Usage: fmt [options] [files...]\n" + getFlagsUsage(fs) + `
+Reformats CTE documents to a canonical style, preserving comments. With no
+files, reads from stdin. Without -w or -l, the formatted documents are written
+to stdout.
+
+With -l, the exit status is 1 if any file was not already formatted (even if
+it was rewritten with -w), and 2 if any file couldn't be formatted.
+`
+}
+
+func (_this *cmdFmt) Run() (err error) {
+	errorCount := 0
+	unformattedCount := 0
+	for _, srcFile := range _this.srcFiles {
+		changed, fileErr := _this.processFile(srcFile)
+		if fileErr != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", srcFile, fileErr)
+			errorCount++
+		} else if changed {
+			unformattedCount++
+		}
+	}
+
+	switch {
+	case errorCount > 0:
+		return withExitCode(fmtExitError, fmt.Errorf("could not format %v file(s)", errorCount))
+	case _this.list && unformattedCount > 0:
+		return withExitCode(fmtExitUnformatted, fmt.Errorf("%v file(s) not formatted", unformattedCount))
+	}
+	return
+}
+
+// Format a file according to the options, reporting whether its formatted
+// form differs from the original.
+func (_this *cmdFmt) processFile(srcFile string) (changed bool, err error) {
+	formatted, original, err := _this.format(srcFile)
+	if err != nil {
+		return
+	}
+	changed = !bytes.Equal(formatted, original)
+
+	if _this.list && changed {
+		fmt.Println(srcFile)
+	}
+	if _this.write {
+		if changed {
+			var info os.FileInfo
+			if info, err = os.Stat(srcFile); err != nil {
+				return
+			}
+			err = os.WriteFile(srcFile, formatted, info.Mode().Perm())
+		}
+		return
+	}
+	if !_this.list {
+		_, err = os.Stdout.Write(formatted)
+	}
+	return
+}
+
+// Read a CTE document and return both its formatted and original forms.
+func (_this *cmdFmt) format(srcFile string) (formatted []byte, original []byte, err error) {
+	reader, err := openFileRead(srcFile)
+	if err != nil {
+		return
+	}
+	if f, ok := reader.(*os.File); ok && f != os.Stdin {
+		defer f.Close()
+	}
+	if original, err = io.ReadAll(reader); err != nil {
+		return
+	}
+
+	formatted, err = formatCTE(original, &_this.encoderConfig)
+	return
+}
+
+// Reformat a CTE document, ending it with a newline.
+func formatCTE(document []byte, config *encoderConfig) (formatted []byte, err error) {
+	buffer := bytes.Buffer{}
+	if err = pipe(cteSource, bytes.NewReader(document), cteSink, &buffer, config); err != nil {
+		return
+	}
+	buffer.WriteByte('\n')
+	formatted = buffer.Bytes()
+	return
+}
+
+func (_this *cmdFmt) Init(args []string) (err error) {
+	fs, fields := _this.newFlagSet()
+	if err = parseFlagsQuietly(fs, args); err != nil {
+		return usageError("%v", err)
+	}
+
+	_this.srcFiles = fs.Args()
+	_this.write = fields.getBool("w")
+	_this.list = fields.getBool("l")
+	_this.encoderConfig.indentSpaces = int(fields.getUint("i"))
+
+	if len(_this.srcFiles) == 0 {
+		if _this.write {
+			return usageError("-w requires at least one file")
+		}
+		_this.srcFiles = []string{"-"}
+	}
+
+	return
+}
+
+func (_this *cmdFmt) newFlagSet() (fs *flag.FlagSet, fields fieldValues) {
+	fields = make(fieldValues)
+	fs = flag.NewFlagSet("fmt", flag.ContinueOnError)
+	fields["w"] = fs.Bool("w", false, "Write the formatted document back to its file")
+	fields["l"] = fs.Bool("l", false, "List the files that aren't formatted")
+	fields["i"] = fs.Uint("i", 4, "Indentation to use (0 for a single line)")
+
+	return
+}
+
+func init() {
+	addCommand(new(cmdFmt))
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const unformattedCTE = `c0
+// A line comment
+{"b"=[1   2 3] /* A block comment */ "a"={"x"=1}}
+`
+
+func TestFormatCTE(t *testing.T) {
+	config := &encoderConfig{indentSpaces: 4}
+	formatted, err := formatCTE([]byte(unformattedCTE), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(formatted) == unformattedCTE {
+		t.Fatalf("document was not reformatted:\n%s", formatted)
+	}
+	for _, expected := range []string{"//", "A line comment", "/*", "A block comment", "*/"} {
+		if !strings.Contains(string(formatted), expected) {
+			t.Errorf("formatted document is missing %q:\n%s", expected, formatted)
+		}
+	}
+
+	again, err := formatCTE(formatted, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(formatted) {
+		t.Errorf("formatting is not stable:\n%s\nbecame:\n%s", formatted, again)
+	}
+
+	if _, err := formatCTE([]byte("c0\n{\"a\"=}"), config); err == nil {
+		t.Error("expected an error for an invalid document")
+	}
+}
+
+func TestFmtListAndWrite(t *testing.T) {
+	dir := t.TempDir()
+	formattedPath := filepath.Join(dir, "formatted.cte")
+	unformattedPath := filepath.Join(dir, "unformatted.cte")
+	config := encoderConfig{indentSpaces: 4}
+	formatted, err := formatCTE([]byte(unformattedCTE), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(formattedPath, formatted, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(unformattedPath, []byte(unformattedCTE), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	exitCode := func(err error) int {
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			return exitErr.code
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return 0
+	}
+
+	cmd := &cmdFmt{srcFiles: []string{formattedPath}, list: true, encoderConfig: config}
+	if code := exitCode(cmd.Run()); code != 0 {
+		t.Errorf("-l on a formatted file: expected exit status 0 but got %v", code)
+	}
+	cmd = &cmdFmt{srcFiles: []string{formattedPath, unformattedPath}, list: true, encoderConfig: config}
+	if code := exitCode(cmd.Run()); code != fmtExitUnformatted {
+		t.Errorf("-l on an unformatted file: expected exit status %v but got %v", fmtExitUnformatted, code)
+	}
+	if contents, _ := os.ReadFile(unformattedPath); string(contents) != unformattedCTE {
+		t.Error("-l without -w changed the file")
+	}
+
+	cmd = &cmdFmt{srcFiles: []string{unformattedPath}, list: true, write: true, encoderConfig: config}
+	if code := exitCode(cmd.Run()); code != fmtExitUnformatted {
+		t.Errorf("-l -w on an unformatted file: expected exit status %v but got %v", fmtExitUnformatted, code)
+	}
+	if contents, _ := os.ReadFile(unformattedPath); string(contents) != string(formatted) {
+		t.Errorf("-w wrote:\n%s\nexpected:\n%s", contents, formatted)
+	}
+	cmd = &cmdFmt{srcFiles: []string{unformattedPath}, list: true, encoderConfig: config}
+	if code := exitCode(cmd.Run()); code != 0 {
+		t.Errorf("-l after -w: expected exit status 0 but got %v", code)
+	}
+}
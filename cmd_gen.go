@@ -0,0 +1,160 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type cmdGen struct {
+	dstFormat     string
+	dstFile       string
+	count         int
+	seed          int64
+	generator     *documentGenerator
+	encoderConfig encoderConfig
+}
+
+func (_this *cmdGen) Name() string { return "gen" }
+
+func (_this *cmdGen) Description() string { return "Generate random documents" }
+
+func (_this *cmdGen) Usage() string {
+	fs, _ := _this.newFlagSet()
+	return "Usage: gen [options]\n" + getFlagsUsage(fs) + `
+Generates random but valid documents. The same seed and options always produce
+the same documents. The seed is printed to stderr if not specified.
+
+The type mix is a comma separated list of types, each with an optional weight
+(for example string=5,integer=2,map,list). The available types are:
+    ` + strings.Join(generatorTypes, ", ") + `
+`
+}
+
+func (_this *cmdGen) Run() (err error) {
+	for i := 1; i <= _this.count; i++ {
+		dstFile := strings.Replace(_this.dstFile, "*", strconv.Itoa(i), -1)
+		if err = _this.generate(dstFile); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (_this *cmdGen) generate(dstFile string) (err error) {
+	writer, err := openFileWrite(dstFile)
+	if err != nil {
+		return
+	}
+	if f, ok := writer.(*os.File); ok && f != os.Stdout {
+		defer f.Close()
+	}
+
+	if err = pipe(_this.generator.Source(), nil, knownSinks[_this.dstFormat], writer, &_this.encoderConfig); err != nil {
+		return
+	}
+	if textFormats[_this.dstFormat] {
+		_, err = fmt.Fprintln(writer)
+	}
+	return
+}
+
+func (_this *cmdGen) Init(args []string) (err error) {
+	fs, fields := _this.newFlagSet()
+	if err = parseFlagsQuietly(fs, args); err != nil {
+		return usageError("%v", err)
+	}
+	if fs.NArg() != 0 {
+		return usageError("Unexpected arguments: %v", fs.Args())
+	}
+
+	if _this.dstFormat, err = fields.getString("o", "Output format"); err != nil {
+		return
+	}
+	_this.dstFormat = strings.ToLower(_this.dstFormat)
+	if knownSinks[_this.dstFormat] == nil {
+		return usageError("%v: Unknown output format", _this.dstFormat)
+	}
+
+	if _this.dstFile, err = fields.getString("d", "Destination file"); err != nil {
+		return
+	}
+	if _this.dstFile == "" {
+		_this.dstFile = "-"
+	}
+	_this.count = int(fields.getUint("n"))
+	if _this.count > 1 && !strings.Contains(_this.dstFile, "*") {
+		return usageError("-d must contain a * to be replaced by the document number when generating multiple documents")
+	}
+
+	seedText, err := fields.getString("seed", "Seed")
+	if err != nil {
+		return
+	}
+	if seedText == "" {
+		_this.seed = time.Now().UnixNano()
+		fmt.Fprintf(os.Stderr, "Seed: %v\n", _this.seed)
+	} else if _this.seed, err = strconv.ParseInt(seedText, 10, 64); err != nil {
+		return usageError("%v: Invalid seed", seedText)
+	}
+
+	typeMix, err := fields.getString("types", "Type mix")
+	if err != nil {
+		return
+	}
+	types, err := parseTypeMix(typeMix)
+	if err != nil {
+		return usageError("%v", err)
+	}
+
+	breadth := int(fields.getUint("breadth"))
+	if breadth == 0 {
+		return usageError("Breadth must be at least 1")
+	}
+	_this.generator = newDocumentGenerator(_this.seed, int(fields.getUint("depth")), breadth, types)
+	_this.encoderConfig = newEncoderConfig()
+	_this.encoderConfig.indentSpaces = int(fields.getUint("i"))
+	return
+}
+
+func (_this *cmdGen) newFlagSet() (fs *flag.FlagSet, fields fieldValues) {
+	fields = make(fieldValues)
+	fs = flag.NewFlagSet("gen", flag.ContinueOnError)
+	fields["o"] = fs.String("o", "cte", "Output format")
+	fields["d"] = fs.String("d", "", "File to write to (- for stdout) (defaults to stdout); * is replaced by the document number")
+	fields["n"] = fs.Uint("n", 1, "Number of documents to generate")
+	fields["seed"] = fs.String("seed", "", "Random seed (defaults to the current time)")
+	fields["depth"] = fs.Uint("depth", 4, "Maximum container nesting depth")
+	fields["breadth"] = fs.Uint("breadth", 5, "Maximum number of entries in each container")
+	fields["types"] = fs.String("types", "", "Type mix (defaults to all types)")
+	fields["i"] = fs.Uint("i", 0, "Indentation to use (for text output formats)")
+
+	return
+}
+
+func init() {
+	addCommand(new(cmdGen))
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"hash"
+	"os"
+	"sort"
+	"strings"
+)
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+func getHashAlgorithmNames() []string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type cmdHash struct {
+	srcFiles  []string
+	srcFormat string
+	newHash   func() hash.Hash
+}
+
+func (_this *cmdHash) Name() string { return "hash" }
+
+func (_this *cmdHash) Description() string {
+	return "Print a digest of the canonical form of documents"
+}
+
+func (_this *cmdHash) Usage() string {
+	fs, _ := _this.newFlagSet()
+	return "Usage: hash [options] [files...]\n" + getFlagsUsage(fs) + `
+Prints a digest of the canonical CBE form of each document (see convert
+-canonical), so that documents containing the same data have the same digest
+regardless of their format, map ordering, number representations or Unicode
+normalization. With no files, reads from stdin.
+`
+}
+
+func (_this *cmdHash) Run() (err error) {
+	errorCount := 0
+	for _, srcFile := range _this.srcFiles {
+		digest, fileErr := _this.hash(srcFile)
+		if fileErr != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", srcFile, fileErr)
+			errorCount++
+			continue
+		}
+		fmt.Printf("%v  %v\n", digest, srcFile)
+	}
+
+	if errorCount > 0 {
+		err = fmt.Errorf("could not hash %v file(s)", errorCount)
+	}
+	return
+}
+
+func (_this *cmdHash) hash(srcFile string) (digest string, err error) {
+	reader, err := openFileRead(srcFile)
+	if err != nil {
+		return
+	}
+	if f, ok := reader.(*os.File); ok && f != os.Stdin {
+		defer f.Close()
+	}
+
+	bufReader := bufio.NewReader(reader)
+	srcFormat := _this.srcFormat
+	if srcFormat == "" {
+		if srcFormat, err = detectSrcFormat(bufReader); err != nil {
+			return
+		}
+	}
+	source := knownSources[srcFormat]
+	if source == nil {
+		err = fmt.Errorf("%v: Unknown source format", srcFormat)
+		return
+	}
+
+	hasher := _this.newHash()
+	if err = pipe(source, bufReader, cbeSink, hasher, &encoderConfig{canonical: true}); err != nil {
+		return
+	}
+	digest = hex.EncodeToString(hasher.Sum(nil))
+	return
+}
+
+func (_this *cmdHash) Init(args []string) (err error) {
+	fs, fields := _this.newFlagSet()
+	if err = parseFlagsQuietly(fs, args); err != nil {
+		return usageError("%v", err)
+	}
+
+	_this.srcFiles = fs.Args()
+	if len(_this.srcFiles) == 0 {
+		_this.srcFiles = []string{"-"}
+	}
+
+	_this.srcFormat, err = fields.getString("fmt", "Format")
+	if err != nil {
+		return
+	}
+	_this.srcFormat = strings.ToLower(_this.srcFormat)
+
+	algorithm, err := fields.getString("a", "Algorithm")
+	if err != nil {
+		return
+	}
+	if _this.newHash = hashAlgorithms[strings.ToLower(algorithm)]; _this.newHash == nil {
+		return usageError("%v: Unknown hash algorithm (must be one of %v)", algorithm, strings.Join(getHashAlgorithmNames(), ", "))
+	}
+
+	return
+}
+
+func (_this *cmdHash) newFlagSet() (fs *flag.FlagSet, fields fieldValues) {
+	fields = make(fieldValues)
+	fs = flag.NewFlagSet("hash", flag.ContinueOnError)
+	fields["fmt"] = fs.String("fmt", "", "File format (auto-detected if not specified)")
+	fields["a"] = fs.String("a", "sha256", "Hash algorithm ("+strings.Join(getHashAlgorithmNames(), ", ")+")")
+
+	return
+}
+
+func init() {
+	addCommand(new(cmdHash))
+}
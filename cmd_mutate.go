@@ -0,0 +1,253 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const mutateManifestFile = "mutations.json"
+
+// How many times to try mutating the seed document before giving up on
+// producing an invalid variant.
+const mutateMaxAttempts = 100
+
+type cmdMutate struct {
+	dstDir    string
+	srcFormat string
+	count     int
+	keepValid bool
+	mutator   *documentMutator
+}
+
+func (_this *cmdMutate) Name() string { return "mutate" }
+
+func (_this *cmdMutate) Description() string {
+	return "Generate malformed variants of a CBE or CTE document"
+}
+
+func (_this *cmdMutate) Usage() string {
+	fs, _ := _this.newFlagSet()
+	return "Usage: mutate [options]\n" + getFlagsUsage(fs) + `
+Writes corrupted copies of a valid seed document to the output directory, for
+use as a negative test corpus for decoders. Each copy has one mutation:
+    truncate:   cut off inside a container
+    remove-end: remove a container's end marker
+    type-code:  replace the type code of a value
+    length:     replace an array length with an oversized one (CBE only)
+    utf8:       write invalid UTF-8 into a string
+    version:    change the version or format identifier in the header
+
+Some mutations can happen to produce a valid document (for example by
+replacing a type code with another valid one). These are discarded and the
+mutation is retried, unless -keep-valid is given.
+
+What was done to each file is recorded in ` + mutateManifestFile + `, along with
+whether the file still decodes successfully. The same seed and options always
+produce the same files. The seed is printed to stderr if not specified.
+`
+}
+
+func (_this *cmdMutate) Run() (err error) {
+	if err = os.MkdirAll(_this.dstDir, 0755); err != nil {
+		return
+	}
+
+	digits := len(strconv.Itoa(_this.count))
+	manifest := make([]interface{}, 0, _this.count)
+	stillValid := 0
+	var mutateErr error
+	for i := 1; i <= _this.count; i++ {
+		result, isValid := _this.mutate()
+		if isValid {
+			if !_this.keepValid {
+				mutateErr = fmt.Errorf("could not produce an invalid variant after %v attempts (try -keep-valid or other mutations)", mutateMaxAttempts)
+				break
+			}
+			stillValid++
+		}
+
+		fileName := fmt.Sprintf("mutant-%0*d.%v", digits, i, _this.srcFormat)
+		if err = os.WriteFile(filepath.Join(_this.dstDir, fileName), result.data, 0644); err != nil {
+			return
+		}
+		manifest = append(manifest, newOrderedMap().
+			Set("file", fileName).
+			Set("mutation", result.mutation).
+			Set("offset", result.offset).
+			Set("description", result.description).
+			Set("valid", isValid))
+	}
+
+	file, err := os.Create(filepath.Join(_this.dstDir, mutateManifestFile))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if err = writeValue(manifest, "json", file, &encoderConfig{indentSpaces: 4}); err != nil {
+		return
+	}
+	if _, err = fmt.Fprintln(file); err != nil {
+		return
+	}
+
+	if _this.keepValid {
+		fmt.Fprintf(os.Stderr, "Wrote %v files to %v (%v still valid)\n", len(manifest), _this.dstDir, stillValid)
+	} else {
+		fmt.Fprintf(os.Stderr, "Wrote %v files to %v\n", len(manifest), _this.dstDir)
+	}
+	return mutateErr
+}
+
+// Mutate the seed document, retrying until the result is invalid (unless
+// valid results are being kept).
+func (_this *cmdMutate) mutate() (result mutant, isValid bool) {
+	for attempt := 0; attempt < mutateMaxAttempts; attempt++ {
+		result = _this.mutator.Mutate()
+		if isValid = _this.mutator.IsValid(result.data); !isValid || _this.keepValid {
+			return
+		}
+	}
+	return
+}
+
+func (_this *cmdMutate) Init(args []string) (err error) {
+	fs, fields := _this.newFlagSet()
+	if err = parseFlagsQuietly(fs, args); err != nil {
+		return usageError("%v", err)
+	}
+	if fs.NArg() != 0 {
+		return usageError("Unexpected arguments: %v", fs.Args())
+	}
+
+	srcFile, err := fields.getString("f", "Seed file")
+	if err != nil {
+		return
+	}
+	if srcFile == "" {
+		srcFile = "-"
+	}
+
+	if _this.dstDir, err = fields.getString("o", "Output directory"); err != nil {
+		return
+	}
+	if _this.dstDir == "" {
+		return usageError("Output directory must be specified")
+	}
+
+	_this.keepValid = fields.getBool("keep-valid")
+	_this.count = int(fields.getUint("n"))
+	if _this.count == 0 {
+		return usageError("Count must be at least 1")
+	}
+
+	mutationList, err := fields.getString("m", "Mutations")
+	if err != nil {
+		return
+	}
+	mutations := mutationTypes
+	if mutationList != "" {
+		mutations = nil
+		for _, mutation := range strings.Split(mutationList, ",") {
+			mutation = strings.ToLower(strings.TrimSpace(mutation))
+			if !isMutationType(mutation) {
+				return usageError("%v: Unknown mutation", mutation)
+			}
+			mutations = append(mutations, mutation)
+		}
+	}
+
+	if _this.srcFormat, err = fields.getString("fmt", "Format"); err != nil {
+		return
+	}
+
+	reader, err := openFileRead(srcFile)
+	if err != nil {
+		return
+	}
+	if f, ok := reader.(*os.File); ok && f != os.Stdin {
+		defer f.Close()
+	}
+	bufReader := bufio.NewReader(reader)
+	if len(_this.srcFormat) == 0 {
+		if _this.srcFormat, err = detectSrcFormat(bufReader); err != nil {
+			return
+		}
+	}
+	_this.srcFormat = strings.ToLower(_this.srcFormat)
+	if _this.srcFormat != "cbe" && _this.srcFormat != "cte" {
+		return fmt.Errorf("%v: Only CBE and CTE documents can be mutated", _this.srcFormat)
+	}
+
+	seedText, err := fields.getString("seed", "Seed")
+	if err != nil {
+		return
+	}
+	var seed int64
+	if seedText == "" {
+		seed = time.Now().UnixNano()
+		fmt.Fprintf(os.Stderr, "Seed: %v\n", seed)
+	} else if seed, err = strconv.ParseInt(seedText, 10, 64); err != nil {
+		return usageError("%v: Invalid seed", seedText)
+	}
+
+	document, err := io.ReadAll(bufReader)
+	if err != nil {
+		return
+	}
+	_this.mutator, err = newDocumentMutator(_this.srcFormat, document, seed, mutations)
+	return
+}
+
+func isMutationType(name string) bool {
+	for _, mutation := range mutationTypes {
+		if mutation == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (_this *cmdMutate) newFlagSet() (fs *flag.FlagSet, fields fieldValues) {
+	fields = make(fieldValues)
+	fs = flag.NewFlagSet("mutate", flag.ContinueOnError)
+	fields["f"] = fs.String("f", "", "Seed document to read from (- for stdin) (defaults to stdin)")
+	fields["fmt"] = fs.String("fmt", "", "Seed document format (cbe or cte) (auto-detected if not specified)")
+	fields["o"] = fs.String("o", "", "Directory to write the mutated documents to")
+	fields["n"] = fs.Uint("n", 100, "Number of mutated documents to generate")
+	fields["seed"] = fs.String("seed", "", "Random seed (defaults to the current time)")
+	fields["keep-valid"] = fs.Bool("keep-valid", false, "Keep variants that are still valid documents")
+	fields["m"] = fs.String("m", "", "Comma separated list of mutations to apply (defaults to all)")
+
+	return
+}
+
+func init() {
+	addCommand(new(cmdMutate))
+}
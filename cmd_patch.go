@@ -0,0 +1,215 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type cmdPatch struct {
+	srcFile       string
+	srcFormat     string
+	patchFile     string
+	patchFormat   string
+	patchType     string
+	generate      bool
+	newFile       string
+	dstFile       string
+	dstFormat     string
+	encoderConfig encoderConfig
+}
+
+func (_this *cmdPatch) Name() string { return "patch" }
+
+func (_this *cmdPatch) Description() string { return "Apply or generate a JSON Patch or Merge Patch" }
+
+func (_this *cmdPatch) Usage() string {
+	fs, _ := _this.newFlagSet()
+	return "Usage: patch [options] -p <patch file>\n" +
+		"       patch [options] -g <old file> <new file>\n" + getFlagsUsage(fs) + `
+Patches are RFC 6902 JSON Patch documents (a list of operations) or RFC 7396
+JSON Merge Patch documents, and can be in any supported format.
+`
+}
+
+func (_this *cmdPatch) Run() (err error) {
+	if _this.generate {
+		return _this.runGenerate()
+	}
+
+	document, srcFormat, err := readDocument(_this.srcFile, _this.srcFormat)
+	if err != nil {
+		return
+	}
+	patch, _, err := readDocument(_this.patchFile, _this.patchFormat)
+	if err != nil {
+		return
+	}
+
+	patchType := _this.patchType
+	if patchType == "auto" {
+		if _, isList := unmarked(patch).([]interface{}); isList {
+			patchType = "json"
+		} else {
+			patchType = "merge"
+		}
+	}
+	if patchType == "json" {
+		if document, err = applyJSONPatch(document, patch); err != nil {
+			return
+		}
+	} else {
+		document = applyMergePatch(document, patch)
+	}
+
+	dstFormat := _this.dstFormat
+	if dstFormat == "" {
+		dstFormat = srcFormat
+	}
+	return _this.write(document, dstFormat)
+}
+
+func (_this *cmdPatch) runGenerate() (err error) {
+	oldDoc, _, err := readDocument(_this.srcFile, _this.srcFormat)
+	if err != nil {
+		return
+	}
+	newDoc, _, err := readDocument(_this.newFile, _this.srcFormat)
+	if err != nil {
+		return
+	}
+
+	var patch interface{}
+	if _this.patchType == "merge" {
+		patch = generateMergePatch(oldDoc, newDoc)
+	} else {
+		patch = generateJSONPatch(oldDoc, newDoc)
+	}
+
+	dstFormat := _this.dstFormat
+	if dstFormat == "" {
+		dstFormat = "json"
+	}
+	return _this.write(patch, dstFormat)
+}
+
+func (_this *cmdPatch) write(value interface{}, format string) (err error) {
+	writer, err := openFileWrite(_this.dstFile)
+	if err != nil {
+		return
+	}
+	if f, ok := writer.(*os.File); ok && f != os.Stdout {
+		defer f.Close()
+	}
+	if err = writeValue(value, format, writer, &_this.encoderConfig); err != nil {
+		return
+	}
+	if writer == os.Stdout && textFormats[format] {
+		fmt.Println()
+	}
+	return
+}
+
+func (_this *cmdPatch) Init(args []string) (err error) {
+	fs, fields := _this.newFlagSet()
+	if err = parseFlagsQuietly(fs, args); err != nil {
+		return usageError("%v", err)
+	}
+
+	if _this.srcFormat, err = fields.getString("fmt", "Format"); err != nil {
+		return
+	}
+	if _this.patchFormat, err = fields.getString("pf", "Patch format"); err != nil {
+		return
+	}
+	if _this.patchType, err = fields.getString("t", "Patch type"); err != nil {
+		return
+	}
+	_this.patchType = strings.ToLower(_this.patchType)
+	switch _this.patchType {
+	case "auto", "json", "merge":
+	default:
+		return usageError("%v: Unknown patch type", _this.patchType)
+	}
+
+	_this.generate = fields.getBool("g")
+	if _this.generate {
+		if fs.NArg() != 2 {
+			return usageError("Expected exactly two files to generate a patch from")
+		}
+		_this.srcFile = fs.Arg(0)
+		_this.newFile = fs.Arg(1)
+	} else {
+		if fs.NArg() != 0 {
+			return usageError("Unexpected arguments: %v", fs.Args())
+		}
+		if _this.patchFile, err = fields.getRequiredString("p", "Patch file"); err != nil {
+			return
+		}
+		if _this.srcFile, err = fields.getString("f", "File"); err != nil {
+			return
+		}
+		if _this.srcFile == "" {
+			_this.srcFile = "-"
+		}
+	}
+
+	if _this.dstFile, err = fields.getString("d", "Destination file"); err != nil {
+		return
+	}
+	if _this.dstFile == "" {
+		_this.dstFile = "-"
+	}
+	if _this.dstFormat, err = fields.getString("o", "Output format"); err != nil {
+		return
+	}
+	_this.dstFormat = strings.ToLower(_this.dstFormat)
+	if _this.dstFormat != "" && knownSinks[_this.dstFormat] == nil {
+		return usageError("%v: Unknown output format", _this.dstFormat)
+	}
+
+	_this.encoderConfig = newEncoderConfig()
+	_this.encoderConfig.indentSpaces = int(fields.getUint("i"))
+	return
+}
+
+func (_this *cmdPatch) newFlagSet() (fs *flag.FlagSet, fields fieldValues) {
+	fields = make(fieldValues)
+	fs = flag.NewFlagSet("patch", flag.ContinueOnError)
+	fields["f"] = fs.String("f", "", "File to patch (- for stdin) (defaults to stdin)")
+	fields["fmt"] = fs.String("fmt", "", "Format of the file(s) to patch or compare (auto-detected if not specified)")
+	fields["p"] = fs.String("p", "", "Patch file to apply")
+	fields["pf"] = fs.String("pf", "", "Patch file format (auto-detected if not specified)")
+	fields["t"] = fs.String("t", "auto", "Patch type: json (RFC 6902), merge (RFC 7396), or auto (json if the patch is a list) when applying")
+	fields["g"] = fs.Bool("g", false, "Generate a patch that transforms the first file into the second")
+	fields["d"] = fs.String("d", "", "The destination file to write to (- for stdout) (defaults to stdout)")
+	fields["o"] = fs.String("o", "", "Output format (defaults to the format of the patched file, or json for generated patches)")
+	fields["i"] = fs.Uint("i", 0, "Indentation to use (for text output formats)")
+
+	return
+}
+
+func init() {
+	addCommand(new(cmdPatch))
+}
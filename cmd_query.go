@@ -0,0 +1,157 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kstenerud/go-concise-encoding/configuration"
+	"github.com/kstenerud/go-concise-encoding/rules"
+)
+
+type cmdQuery struct {
+	srcReader     io.Reader
+	source        eventSource
+	query         *query
+	dstFormat     string
+	asList        bool
+	encoderConfig encoderConfig
+}
+
+func (_this *cmdQuery) Name() string { return "query" }
+
+func (_this *cmdQuery) Description() string { return "Extract values from a document" }
+
+func (_this *cmdQuery) Usage() string {
+	fs, _ := _this.newFlagSet()
+	return "Usage: query [options] <query>\n" + getFlagsUsage(fs) + `
+The query can be a JSON Pointer, where * matches any child and ** matches any
+number of levels:
+    /servers/3/address
+    /servers/*/address
+    /**/address
+
+Or a JSONPath style expression, optionally with filters:
+    $.servers[3].address
+    $.servers[*].address
+    $..address
+    $.servers[?(@.port >= 8000 && @.name != 'test')].address
+
+A query that can only match one value (a JSON Pointer without wildcards)
+outputs that value. Other queries output a list of all matching values.
+`
+}
+
+func (_this *cmdQuery) Run() (err error) {
+	matcher := newQueryMatcher(_this.query)
+	if err = runSource(_this.source, _this.srcReader, rules.NewRules(matcher, configuration.New())); err != nil {
+		return
+	}
+
+	if err = pipe(matcher.ResultSource(_this.asList), nil, knownSinks[_this.dstFormat], os.Stdout, &_this.encoderConfig); err != nil {
+		return
+	}
+	if textFormats[_this.dstFormat] {
+		fmt.Println()
+	}
+	return
+}
+
+func (_this *cmdQuery) Init(args []string) (err error) {
+	fs, fields := _this.newFlagSet()
+	if err = parseFlagsQuietly(fs, args); err != nil {
+		return usageError("%v", err)
+	}
+
+	if fs.NArg() != 1 {
+		return usageError("Expected exactly one query")
+	}
+	if _this.query, err = parseQuery(fs.Arg(0)); err != nil {
+		return
+	}
+	_this.asList = fields.getBool("l") || !_this.query.IsSingular()
+
+	srcFile, err := fields.getString("f", "File")
+	if err != nil {
+		return
+	}
+	if srcFile == "" {
+		srcFile = "-"
+	}
+
+	srcFormat, err := fields.getString("fmt", "Format")
+	if err != nil {
+		return
+	}
+
+	_this.dstFormat, err = fields.getString("o", "Output format")
+	if err != nil {
+		return
+	}
+	_this.dstFormat = strings.ToLower(_this.dstFormat)
+	if knownSinks[_this.dstFormat] == nil {
+		return usageError("%v: Unknown output format", _this.dstFormat)
+	}
+	_this.encoderConfig = newEncoderConfig()
+	_this.encoderConfig.indentSpaces = int(fields.getUint("i"))
+
+	_this.srcReader, err = openFileRead(srcFile)
+	if err != nil {
+		return err
+	}
+
+	if len(srcFormat) == 0 {
+		bufReader := bufio.NewReader(_this.srcReader)
+		_this.srcReader = bufReader
+		srcFormat, err = detectSrcFormat(bufReader)
+		if err != nil {
+			return err
+		}
+	}
+
+	_this.source = knownSources[strings.ToLower(srcFormat)]
+	if _this.source == nil {
+		return fmt.Errorf("%v: Unknown source format", srcFormat)
+	}
+
+	return
+}
+
+func (_this *cmdQuery) newFlagSet() (fs *flag.FlagSet, fields fieldValues) {
+	fields = make(fieldValues)
+	fs = flag.NewFlagSet("query", flag.ContinueOnError)
+	fields["fmt"] = fs.String("fmt", "", "File format (auto-detected if not specified)")
+	fields["f"] = fs.String("f", "", "File to read from (- for stdin) (defaults to stdin)")
+	fields["o"] = fs.String("o", "cte", "Output format")
+	fields["i"] = fs.Uint("i", 0, "Indentation to use (for text output formats)")
+	fields["l"] = fs.Bool("l", false, "Always output a list of matching values")
+
+	return
+}
+
+func init() {
+	addCommand(new(cmdQuery))
+}
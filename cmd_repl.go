@@ -0,0 +1,126 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type cmdREPL struct {
+	srcFile   string
+	srcFormat string
+}
+
+func (_this *cmdREPL) Name() string { return "repl" }
+
+func (_this *cmdREPL) Description() string { return "Interactively explore and edit a document" }
+
+func (_this *cmdREPL) Usage() string {
+	fs, _ := _this.newFlagSet()
+	return "Usage: repl [options] [file]\n" + getFlagsUsage(fs) + `
+Loads a document and reads commands from stdin. Paths are JSON Pointer style,
+and can be relative to the current location. Press tab to complete commands
+and paths. Commands:
+` + "    " + strings.Join(sortedREPLCommandHelp(), "\n    ") + "\n"
+}
+
+func sortedREPLCommandHelp() []string {
+	names := []string{"cd", "ls", "cat", "type", "pwd", "set", "delete", "save", "help", "exit"}
+	help := make([]string, 0, len(names))
+	for _, name := range names {
+		help = append(help, replCommands[name])
+	}
+	return help
+}
+
+func (_this *cmdREPL) Run() (err error) {
+	document, srcFormat, err := readDocument(_this.srcFile, _this.srcFormat)
+	if err != nil {
+		return
+	}
+	session := newREPLSession(document, _this.srcFile, srcFormat)
+
+	reader := newStdinLineReader(session.Complete)
+	defer reader.Close()
+	for {
+		line, readErr := reader.ReadLine(session.Prompt())
+		if readErr == io.EOF {
+			if session.modified {
+				fmt.Fprintln(os.Stderr, "Warning: exiting with unsaved changes")
+			}
+			return
+		}
+		if readErr != nil {
+			return readErr
+		}
+
+		done, cmdErr := session.Execute(line)
+		if cmdErr != nil {
+			fmt.Fprintln(os.Stderr, cmdErr)
+		}
+		if done {
+			return
+		}
+	}
+}
+
+func (_this *cmdREPL) Init(args []string) (err error) {
+	fs, fields := _this.newFlagSet()
+	if err = parseFlagsQuietly(fs, args); err != nil {
+		return usageError("%v", err)
+	}
+
+	if _this.srcFile, err = fields.getString("f", "File"); err != nil {
+		return
+	}
+	switch {
+	case fs.NArg() == 1 && _this.srcFile == "":
+		_this.srcFile = fs.Arg(0)
+	case fs.NArg() > 0:
+		return usageError("Unexpected arguments: %v", fs.Args())
+	}
+	if _this.srcFile == "" || _this.srcFile == "-" {
+		return usageError("A file is required, since commands are read from stdin")
+	}
+
+	if _this.srcFormat, err = fields.getString("fmt", "Format"); err != nil {
+		return
+	}
+	_this.srcFormat = strings.ToLower(_this.srcFormat)
+	return
+}
+
+func (_this *cmdREPL) newFlagSet() (fs *flag.FlagSet, fields fieldValues) {
+	fields = make(fieldValues)
+	fs = flag.NewFlagSet("repl", flag.ContinueOnError)
+	fields["fmt"] = fs.String("fmt", "", "File format (auto-detected if not specified)")
+	fields["f"] = fs.String("f", "", "File to load")
+
+	return
+}
+
+func init() {
+	addCommand(new(cmdREPL))
+}
@@ -0,0 +1,164 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	serveReadTimeout  = 60 * time.Second
+	serveWriteTimeout = 120 * time.Second
+)
+
+type cmdServe struct {
+	network       string
+	address       string
+	limits        serverLimits
+	maxConcurrent int
+}
+
+func (_this *cmdServe) Name() string { return "serve" }
+
+func (_this *cmdServe) Description() string { return "Serve conversions over a local HTTP API" }
+
+func (_this *cmdServe) Usage() string {
+	fs, _ := _this.newFlagSet()
+	return "Usage: serve [options]\n" + getFlagsUsage(fs) + `
+Endpoints (all POST, with the document as the request body):
+    /convert?from=<format>&to=<format>  Convert a document
+    /validate?from=<format>             Validate a document (422 if invalid)
+    /detect                             Detect a document's format
+    /print?from=<format>                Print a document's structure
+
+The source format is detected if "from" isn't given. Reports from /validate
+and /detect are JSON unless another format is given with "o". Encoder options
+can be given as parameters with the same names as convert's options (i,
+canonical, I, is, e, b, qs, qf). Failures are reported as text with status
+400 (bad request) or 422 (bad document).
+
+Requests are rejected with status 400 if their options exceed the limits set
+by -max-image-size, -max-border, -max-indent or -max-qr-parts, and with status
+413 if their body exceeds -max-body. QR images wider or taller than
+-max-image-size are also rejected with status 400 before being decoded.
+`
+}
+
+func (_this *cmdServe) Run() (err error) {
+	if _this.network == "unix" {
+		// Remove a stale socket left behind by a previous run
+		if info, statErr := os.Stat(_this.address); statErr == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(_this.address)
+		}
+	}
+	listener, err := net.Listen(_this.network, _this.address)
+	if err != nil {
+		return
+	}
+
+	server := &http.Server{
+		Handler:           newConversionServer(_this.limits, _this.maxConcurrent).Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       serveReadTimeout,
+		WriteTimeout:      serveWriteTimeout,
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	fmt.Fprintf(os.Stderr, "Listening on %v:%v\n", _this.network, listener.Addr())
+	if err = server.Serve(listener); err == http.ErrServerClosed {
+		err = nil
+	}
+	return
+}
+
+func (_this *cmdServe) Init(args []string) (err error) {
+	fs, fields := _this.newFlagSet()
+	if err = parseFlagsQuietly(fs, args); err != nil {
+		return usageError("%v", err)
+	}
+	if fs.NArg() != 0 {
+		return usageError("Unexpected arguments: %v", fs.Args())
+	}
+
+	listen, err := fields.getRequiredString("listen", "Listen address")
+	if err != nil {
+		return
+	}
+	_this.network = "tcp"
+	_this.address = listen
+	if strings.HasPrefix(listen, "unix:") {
+		_this.network = "unix"
+		_this.address = strings.TrimPrefix(listen, "unix:")
+	}
+
+	_this.limits.maxBodySize = int64(fields.getUint("max-body"))
+	if _this.limits.maxBodySize == 0 {
+		return usageError("Maximum body size must be greater than 0")
+	}
+	_this.limits.maxImageSize = fields.getUint("max-image-size")
+	_this.limits.maxBorder = fields.getUint("max-border")
+	_this.limits.maxIndent = fields.getUint("max-indent")
+	_this.limits.maxQRParts = fields.getUint("max-qr-parts")
+	if _this.limits.maxImageSize == 0 || _this.limits.maxQRParts == 0 {
+		return usageError("Maximum image size and QR parts must be greater than 0")
+	}
+	_this.maxConcurrent = int(fields.getUint("max-concurrent"))
+	if _this.maxConcurrent == 0 {
+		return usageError("Maximum concurrent requests must be greater than 0")
+	}
+	return
+}
+
+func (_this *cmdServe) newFlagSet() (fs *flag.FlagSet, fields fieldValues) {
+	fields = make(fieldValues)
+	fs = flag.NewFlagSet("serve", flag.ContinueOnError)
+	fields["listen"] = fs.String("listen", "127.0.0.1:8080", "Address to listen on (host:port, or unix:/path/to/socket)")
+	fields["max-body"] = fs.Uint("max-body", 16*1024*1024, "Maximum request body size in bytes")
+	fields["max-image-size"] = fs.Uint("max-image-size", 4096, "Maximum QR image size in pixels (is), and of uploaded QR images")
+	fields["max-border"] = fs.Uint("max-border", 64, "Maximum QR border size (b)")
+	fields["max-indent"] = fs.Uint("max-indent", 32, "Maximum indentation (i)")
+	fields["max-qr-parts"] = fs.Uint("max-qr-parts", 16, "Maximum number of QR codes that a document can be split across")
+	fields["max-concurrent"] = fs.Uint("max-concurrent", uint(runtime.NumCPU()), "Maximum number of requests to process at once")
+
+	return
+}
+
+func init() {
+	addCommand(new(cmdServe))
+}
@@ -0,0 +1,207 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kstenerud/go-concise-encoding/configuration"
+	"github.com/kstenerud/go-concise-encoding/rules"
+)
+
+type cmdStats struct {
+	srcReader     io.Reader
+	srcFormat     string
+	source        eventSource
+	dstFormat     string
+	encoderConfig encoderConfig
+}
+
+func (_this *cmdStats) Name() string { return "stats" }
+
+func (_this *cmdStats) Description() string {
+	return "Report the composition and size breakdown of a document"
+}
+
+func (_this *cmdStats) Usage() string {
+	fs, _ := _this.newFlagSet()
+	return "Usage: stats [options]\n" + getFlagsUsage(fs) + `
+Reports the maximum container depth, the number of values of each type, the
+largest containers, and a histogram of string lengths. For CBE documents, it
+also reports how many bytes each type consumes (including container end
+markers and array chunk headers).
+`
+}
+
+func (_this *cmdStats) Run() (err error) {
+	var byteCounter *byteCountingReader
+	if _this.srcFormat == "cbe" {
+		byteCounter = &byteCountingReader{reader: _this.srcReader}
+		_this.srcReader = byteCounter
+	}
+
+	collector := newStatsCollector(byteCounter)
+	if err = runSource(_this.source, _this.srcReader, rules.NewRules(collector.Receiver(), configuration.New())); err != nil {
+		return
+	}
+	report := collector.Report()
+
+	if _this.dstFormat == "text" {
+		printStatsReport(report)
+		return
+	}
+	if err = writeValue(report, _this.dstFormat, os.Stdout, &_this.encoderConfig); err != nil {
+		return
+	}
+	if textFormats[_this.dstFormat] {
+		fmt.Println()
+	}
+	return
+}
+
+func printStatsReport(report *orderedMap) {
+	printSection := func(name string) {
+		section, _ := report.Get(name)
+		m := section.(*orderedMap)
+		if m.Len() == 0 {
+			return
+		}
+		fmt.Printf("%v:\n", name)
+		for _, entry := range m.entries {
+			fmt.Printf("    %-12v %v\n", entry.key, entry.value)
+		}
+	}
+
+	maxDepth, _ := report.Get("maxDepth")
+	values, _ := report.Get("values")
+	fmt.Printf("max depth: %v\n", maxDepth)
+	fmt.Printf("values:    %v\n", values)
+	if totalBytes, ok := report.Get("totalBytes"); ok {
+		fmt.Printf("size:      %v bytes\n", totalBytes)
+	}
+
+	printSection("counts")
+
+	largest, _ := report.Get("largestContainers")
+	if containers := largest.([]interface{}); len(containers) > 0 {
+		fmt.Println("largest containers:")
+		for _, entry := range containers {
+			container := entry.(*orderedMap)
+			path, _ := container.Get("path")
+			containerType, _ := container.Get("type")
+			entries, _ := container.Get("entries")
+			fmt.Printf("    %8v %-6v %v\n", entries, containerType, path)
+		}
+	}
+
+	histogram, _ := report.Get("stringLengths")
+	if buckets := histogram.([]interface{}); len(buckets) > 0 {
+		fmt.Println("string lengths (bytes):")
+		for _, entry := range buckets {
+			bucket := entry.(*orderedMap)
+			min, _ := bucket.Get("min")
+			max, _ := bucket.Get("max")
+			count, _ := bucket.Get("count")
+			fmt.Printf("    %12v %v\n", fmt.Sprintf("%v-%v", min, max), count)
+		}
+	}
+
+	if _, ok := report.Get("bytes"); ok {
+		printSection("bytes")
+	}
+}
+
+func (_this *cmdStats) Init(args []string) (err error) {
+	fs, fields := _this.newFlagSet()
+	if err = parseFlagsQuietly(fs, args); err != nil {
+		return usageError("%v", err)
+	}
+
+	if fs.NArg() != 0 {
+		return usageError("Unexpected arguments: %v", fs.Args())
+	}
+
+	srcFile, err := fields.getString("f", "File")
+	if err != nil {
+		return
+	}
+	if srcFile == "" {
+		srcFile = "-"
+	}
+
+	_this.srcFormat, err = fields.getString("fmt", "Format")
+	if err != nil {
+		return
+	}
+
+	_this.dstFormat, err = fields.getString("o", "Output format")
+	if err != nil {
+		return
+	}
+	_this.dstFormat = strings.ToLower(_this.dstFormat)
+	if _this.dstFormat != "text" && knownSinks[_this.dstFormat] == nil {
+		return usageError("%v: Unknown output format", _this.dstFormat)
+	}
+	_this.encoderConfig = newEncoderConfig()
+	_this.encoderConfig.indentSpaces = int(fields.getUint("i"))
+
+	_this.srcReader, err = openFileRead(srcFile)
+	if err != nil {
+		return err
+	}
+
+	bufReader := bufio.NewReader(_this.srcReader)
+	_this.srcReader = bufReader
+	if len(_this.srcFormat) == 0 {
+		_this.srcFormat, err = detectSrcFormat(bufReader)
+		if err != nil {
+			return err
+		}
+	}
+	_this.srcFormat = strings.ToLower(_this.srcFormat)
+
+	_this.source = knownSources[_this.srcFormat]
+	if _this.source == nil {
+		return fmt.Errorf("%v: Unknown source format", _this.srcFormat)
+	}
+
+	return
+}
+
+func (_this *cmdStats) newFlagSet() (fs *flag.FlagSet, fields fieldValues) {
+	fields = make(fieldValues)
+	fs = flag.NewFlagSet("stats", flag.ContinueOnError)
+	fields["fmt"] = fs.String("fmt", "", "File format (auto-detected if not specified)")
+	fields["f"] = fs.String("f", "", "File to read from (- for stdin) (defaults to stdin)")
+	fields["o"] = fs.String("o", "text", "Report format (text, or any destination format such as cte or json)")
+	fields["i"] = fs.Uint("i", 0, "Indentation to use (for text output formats)")
+
+	return
+}
+
+func init() {
+	addCommand(new(cmdStats))
+}
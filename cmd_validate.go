@@ -22,13 +22,46 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kstenerud/go-concise-encoding/ce/events"
+	"github.com/kstenerud/go-concise-encoding/configuration"
+	"github.com/kstenerud/go-concise-encoding/rules"
+)
+
+// Exit codes returned by validate
+const (
+	validateExitInvalid = 2 // At least one document is invalid
+	validateExitError   = 1 // At least one document couldn't be read
 )
 
+// Formats whose errors can be reported as a line and column
+var textFormats = map[string]bool{
+	"cte":  true,
+	"json": true,
+	"xml":  true,
+	"qrt":  true,
+}
+
 type cmdValidate struct {
-	srcReader io.Reader
-	decode    decoder
+	srcFiles      []string
+	srcFormat     string
+	dstFormat     string
+	schema        *jsonSchema
+	encoderConfig encoderConfig
+
+	// Looks up the source for a format (defaults to knownSources).
+	sourceFor func(format string) eventSource
 }
 
 func (_this *cmdValidate) Name() string { return "validate" }
@@ -37,14 +70,260 @@ func (_this *cmdValidate) Description() string { return "Validate a document." }
 
 func (_this *cmdValidate) Usage() string {
 	fs, _ := _this.newFlagSet()
-	return getFlagsUsage(fs)
+	return "Usage: validate [options] [files...]\n" + getFlagsUsage(fs) +
+		"\nExit status is 0 if all documents are valid, " +
+		fmt.Sprintf("%v if any document is invalid, and %v if any document could not be read.\n",
+			validateExitInvalid, validateExitError)
 }
 
 func (_this *cmdValidate) Run() (err error) {
-	_, err = _this.decode(_this.srcReader)
+	var results []interface{}
+	invalidCount := 0
+	errorCount := 0
+	for _, srcFile := range _this.srcFiles {
+		result := _this.validate(srcFile)
+		if _, ok := result.Get("error"); ok {
+			errorCount++
+		} else if valid, _ := result.Get("valid"); !valid.(bool) {
+			invalidCount++
+		}
+		results = append(results, result)
+	}
+
+	if _this.dstFormat == "text" {
+		for _, result := range results {
+			printValidationResult(result.(*orderedMap))
+		}
+	} else {
+		if err = writeValue(results, _this.dstFormat, os.Stdout, &_this.encoderConfig); err != nil {
+			return
+		}
+		fmt.Println()
+	}
+
+	switch {
+	case errorCount > 0:
+		return withExitCode(validateExitError, fmt.Errorf("could not read %v file(s)", errorCount))
+	case invalidCount > 0:
+		return withExitCode(validateExitInvalid, fmt.Errorf("%v of %v file(s) invalid", invalidCount, len(results)))
+	}
 	return
 }
 
+// Validate a single file, returning a report. The report contains "error" if
+// the file couldn't be read at all, "violation" if it couldn't be decoded, or
+// "schemaViolations" if it doesn't match the schema.
+func (_this *cmdValidate) validate(srcFile string) *orderedMap {
+	reader, err := openFileRead(srcFile)
+	if err != nil {
+		return newOrderedMap().Set("file", srcFile).Set("error", err.Error())
+	}
+	if f, ok := reader.(*os.File); ok && f != os.Stdin {
+		defer f.Close()
+	}
+	return _this.validateReader(srcFile, reader)
+}
+
+// Validate a document read from reader, returning a report as described in
+// validate().
+func (_this *cmdValidate) validateReader(srcFile string, reader io.Reader) *orderedMap {
+	var err error
+	result := newOrderedMap().Set("file", srcFile)
+	bufReader := bufio.NewReader(reader)
+	srcFormat := _this.srcFormat
+	if srcFormat == "" {
+		if srcFormat, err = detectSrcFormat(bufReader); err != nil {
+			return result.Set("error", err.Error())
+		}
+	}
+	result.Set("format", srcFormat)
+	source := knownSources[srcFormat]
+	if _this.sourceFor != nil {
+		source = _this.sourceFor(srcFormat)
+	}
+	if source == nil {
+		return result.Set("error", fmt.Sprintf("%v: Unknown source format", srcFormat))
+	}
+
+	posReader := &positionReader{reader: bufReader}
+	var receiver events.DataEventReceiver = new(nullEventReceiver)
+	builder := newDocumentBuilder()
+	if _this.schema != nil {
+		receiver = builder
+	}
+	tracker := newPathTracker(rules.NewRules(receiver, configuration.New()))
+	err = runSource(source, posReader, tracker)
+	if err == nil {
+		if _this.schema != nil {
+			if violations := _this.schema.Validate(builder.Document()); len(violations) > 0 {
+				var reports []interface{}
+				for _, v := range violations {
+					reports = append(reports, newOrderedMap().
+						Set("path", v.path).
+						Set("schemaPath", v.schemaPath).
+						Set("message", v.message))
+				}
+				return result.Set("valid", false).Set("schemaViolations", reports)
+			}
+		}
+		return result.Set("valid", true)
+	}
+
+	result.Set("valid", false)
+	violation := newOrderedMap().Set("message", err.Error())
+	offset, line, column, exact := locateError(err, posReader)
+	if offset >= 0 {
+		violation.Set("offset", offset)
+		if !exact {
+			violation.Set("approximate", true)
+		}
+	}
+	if textFormats[srcFormat] {
+		if line < 0 && offset >= 0 {
+			line, column = posReader.LineColumn(offset)
+		}
+		if line >= 0 {
+			violation.Set("line", line)
+		}
+		if column >= 0 {
+			violation.Set("column", column)
+		}
+	}
+	violation.Set("path", tracker.Path())
+	return result.Set("violation", violation)
+}
+
+var (
+	errorOffsetPattern = regexp.MustCompile(`\boffset:? *(\d+)`)
+	errorLinePattern   = regexp.MustCompile(`\bline:? *(\d+)`)
+	errorColumnPattern = regexp.MustCompile(`\bcol(?:umn)?:? *(\d+)`)
+)
+
+// Find where in the document an error occurred. Values that can't be determined
+// are -1. If the error itself doesn't say where it occurred, the offset is how
+// far the decoder had read, and exact is false.
+func locateError(err error, posReader *positionReader) (offset, line, column int64, exact bool) {
+	offset, line, column, exact = -1, -1, -1, true
+
+	var jsonErr *json.SyntaxError
+	var xmlErr *xml.SyntaxError
+	var srcErr *sourceError
+	switch {
+	case errors.As(err, &jsonErr):
+		// The JSON offset is just past the offending byte.
+		offset = jsonErr.Offset
+		if offset > 0 {
+			offset--
+		}
+	case errors.As(err, &xmlErr):
+		line = int64(xmlErr.Line)
+		if errors.As(err, &srcErr) {
+			offset = srcErr.offset
+		}
+	case errors.As(err, &srcErr):
+		offset = srcErr.offset
+	default:
+		message := err.Error()
+		offset = findErrorNumber(errorOffsetPattern, message)
+		line = findErrorNumber(errorLinePattern, message)
+		column = findErrorNumber(errorColumnPattern, message)
+		if offset < 0 && line < 0 {
+			offset = posReader.offset
+			exact = false
+		}
+	}
+	return
+}
+
+func findErrorNumber(pattern *regexp.Regexp, message string) int64 {
+	match := pattern.FindStringSubmatch(message)
+	if match == nil {
+		return -1
+	}
+	v, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return v
+}
+
+func printValidationResult(result *orderedMap) {
+	file, _ := result.Get("file")
+	if errorMessage, ok := result.Get("error"); ok {
+		fmt.Printf("%v: error: %v\n", file, errorMessage)
+		return
+	}
+	if schemaViolations, ok := result.Get("schemaViolations"); ok {
+		for _, v := range schemaViolations.([]interface{}) {
+			violation := v.(*orderedMap)
+			path, _ := violation.Get("path")
+			if path == "" {
+				path = "/"
+			}
+			message, _ := violation.Get("message")
+			schemaPath, _ := violation.Get("schemaPath")
+			fmt.Printf("%v: at %v: %v (schema %v)\n", file, path, message, schemaPath)
+		}
+		return
+	}
+	v, ok := result.Get("violation")
+	if !ok {
+		fmt.Printf("%v: valid\n", file)
+		return
+	}
+
+	violation := v.(*orderedMap)
+	location := fmt.Sprintf("%v", file)
+	if line, ok := violation.Get("line"); ok {
+		location += fmt.Sprintf(":%v", line)
+		if column, ok := violation.Get("column"); ok {
+			location += fmt.Sprintf(":%v", column)
+		}
+	} else if offset, ok := violation.Get("offset"); ok {
+		location += fmt.Sprintf(": offset %v", offset)
+	}
+	if _, ok := violation.Get("approximate"); ok {
+		location += " (approximately)"
+	}
+	path, _ := violation.Get("path")
+	if path == "" {
+		path = "/"
+	}
+	message, _ := violation.Get("message")
+	fmt.Printf("%v: at %v: %v\n", location, path, message)
+}
+
+// positionReader keeps track of how many bytes have been read through it, and
+// where each line begins.
+type positionReader struct {
+	reader     io.Reader
+	offset     int64
+	lineStarts []int64
+}
+
+func (_this *positionReader) Read(p []byte) (n int, err error) {
+	n, err = _this.reader.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			_this.lineStarts = append(_this.lineStarts, _this.offset+int64(i)+1)
+		}
+	}
+	_this.offset += int64(n)
+	return
+}
+
+// Get the 1-based line and column of an offset that has already been read.
+func (_this *positionReader) LineColumn(offset int64) (line, column int64) {
+	index := sort.Search(len(_this.lineStarts), func(i int) bool {
+		return _this.lineStarts[i] > offset
+	})
+	lineStart := int64(0)
+	if index > 0 {
+		lineStart = _this.lineStarts[index-1]
+	}
+	return int64(index) + 1, offset - lineStart + 1
+}
+
 func (_this *cmdValidate) Init(args []string) (err error) {
 	fs, fields := _this.newFlagSet()
 	if err = parseFlagsQuietly(fs, args); err != nil {
@@ -55,34 +334,46 @@ func (_this *cmdValidate) Init(args []string) (err error) {
 	if err != nil {
 		return
 	}
-	if srcFile == "" {
-		srcFile = "-"
+	if srcFile != "" {
+		_this.srcFiles = append(_this.srcFiles, srcFile)
+	}
+	_this.srcFiles = append(_this.srcFiles, fs.Args()...)
+	if len(_this.srcFiles) == 0 {
+		_this.srcFiles = []string{"-"}
 	}
 
 	srcFormat, err := fields.getString("fmt", "Format")
 	if err != nil {
 		return
 	}
+	_this.srcFormat = strings.ToLower(srcFormat)
+	if _this.srcFormat != "" && knownSources[_this.srcFormat] == nil {
+		return usageError("%v: Unknown format", _this.srcFormat)
+	}
 
-	_this.srcReader, err = openFileRead(srcFile)
+	_this.dstFormat, err = fields.getString("o", "Output format")
 	if err != nil {
-		return err
+		return
 	}
-
-	if len(srcFormat) == 0 {
-		bufReader := bufio.NewReader(_this.srcReader)
-		_this.srcReader = bufReader
-		srcFormat, err = detectSrcFormat(bufReader)
-		if err != nil {
-			return err
-		}
+	_this.dstFormat = strings.ToLower(_this.dstFormat)
+	if _this.dstFormat != "text" && knownSinks[_this.dstFormat] == nil {
+		return usageError("%v: Unknown output format", _this.dstFormat)
 	}
 
-	_this.decode, err = getDecoder(srcFormat)
+	schemaFile, err := fields.getString("schema", "Schema file")
 	if err != nil {
-		return err
+		return
+	}
+	if schemaFile != "" {
+		schema, _, err := readDocument(schemaFile, "")
+		if err != nil {
+			return fmt.Errorf("error reading schema: %v", err)
+		}
+		_this.schema = newJSONSchema(schema)
 	}
 
+	_this.encoderConfig = newEncoderConfig()
+	_this.encoderConfig.indentSpaces = int(fields.getUint("i"))
 	return
 }
 
@@ -90,7 +381,10 @@ func (_this *cmdValidate) newFlagSet() (fs *flag.FlagSet, fields fieldValues) {
 	fields = make(fieldValues)
 	fs = flag.NewFlagSet("validate", flag.ContinueOnError)
 	fields["fmt"] = fs.String("fmt", "", "File format (auto-detected if not specified)")
-	fields["f"] = fs.String("f", "", "File to read from (- for stdin) (defaults to stdin)")
+	fields["f"] = fs.String("f", "", "File to read from (- for stdin) (defaults to stdin if no files are given)")
+	fields["o"] = fs.String("o", "text", "Report format (text, or any destination format such as cte or json)")
+	fields["i"] = fs.Uint("i", 0, "Indentation to use (for non-text reports)")
+	fields["schema"] = fs.String("schema", "", "JSON Schema (in any supported format) that documents must match")
 
 	return
 }
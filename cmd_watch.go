@@ -0,0 +1,65 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"flag"
+)
+
+// cmdWatch is convert in watch mode.
+type cmdWatch struct {
+	cmdConvert
+}
+
+func (_this *cmdWatch) Name() string { return "watch" }
+
+func (_this *cmdWatch) Description() string { return "Convert files again whenever they change" }
+
+func (_this *cmdWatch) Usage() string {
+	return "Usage: watch [options] [files, globs or directories...]\n" + getFlagsUsage(_this.newFlagSet()) + `
+Same as convert -watch. The source files are polled for changes, and converted
+whenever they change until interrupted. Errors are reported without stopping.
+
+If files, globs or directories are given, they are converted into the output
+directory (-od), and new files are converted as they appear. Otherwise, -s and
+-d must both be files.
+`
+}
+
+func (_this *cmdWatch) Init(args []string) error {
+	return _this.cmdConvert.Init(append([]string{"-watch"}, args...))
+}
+
+// Get convert's flags, minus -watch.
+func (_this *cmdWatch) newFlagSet() *flag.FlagSet {
+	convertFlags, _ := _this.cmdConvert.newFlagSet()
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	convertFlags.VisitAll(func(f *flag.Flag) {
+		if f.Name != "watch" {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	return fs
+}
+
+func init() {
+	addCommand(new(cmdWatch))
+}
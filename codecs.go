@@ -21,11 +21,8 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"encoding/xml"
 	"fmt"
-	"image"
 	"io"
 	"reflect"
 	"sort"
@@ -33,8 +30,6 @@ import (
 
 	"github.com/kstenerud/go-concise-encoding/ce"
 	"github.com/kstenerud/go-concise-encoding/configuration"
-	qrcode "github.com/kstenerud/go-qrcode"
-	"github.com/liyue201/goqr"
 )
 
 type encoderConfig struct {
@@ -43,6 +38,24 @@ type encoderConfig struct {
 	imageSize       uint
 	errorCorrection uint
 	borderSize      uint
+	qrPartSize      uint
+	qrMaxParts      uint // 0 means maxQRParts
+	qrPartPath      string
+	qrStyle         string
+	canonical       bool
+}
+
+const (
+	defaultQRImageSize  = 256
+	defaultQRBorderSize = 4
+)
+
+// Get an encoder config with the same defaults as the convert command.
+func newEncoderConfig() encoderConfig {
+	return encoderConfig{
+		imageSize:  defaultQRImageSize,
+		borderSize: defaultQRBorderSize,
+	}
 }
 
 func getKnownEncoders() []string {
@@ -124,127 +137,35 @@ func encodeCTE(value interface{}, writer io.Writer, config *encoderConfig) (err
 }
 
 func decodeQR(reader io.Reader) (result interface{}, err error) {
-	img, _, err := image.Decode(reader)
-	if err != nil {
-		return
-	}
-	qrCodes, err := goqr.Recognize(img)
-	if err != nil {
-		return
-	}
-	buff := &bytes.Buffer{}
-	for _, qrCode := range qrCodes {
-		buff.Write(qrCode.Payload)
-	}
-
-	result, err = ce.UnmarshalCBE(reader, result, configuration.New())
+	result, err = sourceDecoder(qrSource)(reader)
 	return
 }
 
 func encodeQR(value interface{}, writer io.Writer, config *encoderConfig) (err error) {
-	buff := &bytes.Buffer{}
-	if err = ce.MarshalCBE(value, buff, configuration.New()); err != nil {
-		return
-	}
-	q, err := qrcode.New(buff.Bytes(), qrcode.RecoveryLevel(config.errorCorrection))
-	if err != nil {
-		return
-	}
-	q.BorderSize = int(config.borderSize)
-	png, err := q.PNG(int(config.imageSize))
-	if err != nil {
-		return
-	}
-
-	_, err = writer.Write(png)
+	err = sinkEncoder(qrSink)(value, writer, config)
 	return
 }
 
 func decodeQRT(reader io.Reader) (result interface{}, err error) {
-	img, _, err := image.Decode(reader)
-	if err != nil {
-		return
-	}
-	qrCodes, err := goqr.Recognize(img)
-	if err != nil {
-		return
-	}
-	buff := &bytes.Buffer{}
-	for _, qrCode := range qrCodes {
-		buff.Write(qrCode.Payload)
-	}
-
-	result, err = ce.UnmarshalCBE(reader, result, configuration.New())
+	result, err = sourceDecoder(qrtSource)(reader)
 	return
 }
 
 func encodeQRT(value interface{}, writer io.Writer, config *encoderConfig) (err error) {
-	buff := &bytes.Buffer{}
-	if err = ce.MarshalCBE(value, buff, configuration.New()); err != nil {
-		return
-	}
-	q, err := qrcode.New(buff.Bytes(), qrcode.RecoveryLevel(config.errorCorrection))
-	if err != nil {
-		return
-	}
-	if config.invertText {
-		q.BorderSize = 0
-	}
-	art := q.ToString(config.invertText)
-	_, err = writer.Write([]byte(art))
+	err = sinkEncoder(qrtSink)(value, writer, config)
 	return
 }
 
 func decodeJSON(reader io.Reader) (result interface{}, err error) {
-	document, err := io.ReadAll(reader)
-	if err != nil {
-		return
-	}
-
-	v := make(map[string]interface{})
-
-	err = json.Unmarshal(document, &v)
-	result = v
+	result, err = sourceDecoder(JSONToCE)(reader)
 	return
 }
 
 func encodeJSON(value interface{}, writer io.Writer, config *encoderConfig) (err error) {
-	value = coerceToJSONable(value)
-	var document []byte
-
-	if config.indentSpaces == 0 {
-		document, err = json.Marshal(value)
-		if err != nil {
-			return
-		}
-	} else {
-		indent := strings.Repeat(" ", config.indentSpaces)
-		document, err = json.MarshalIndent(value, "", indent)
-		if err != nil {
-			return
-		}
-	}
-
-	_, err = writer.Write(document)
+	err = sinkEncoder(jsonSink)(value, writer, config)
 	return
 }
 
-// TODO: This needs tests
-func coerceToJSONable(value interface{}) interface{} {
-	rv := reflect.ValueOf(value)
-	if rv.Kind() == reflect.Map && rv.Type().Key().Kind() != reflect.String {
-		newMap := make(map[string]interface{})
-		iter := rv.MapRange()
-		for iter.Next() {
-			k := fmt.Sprintf("%v", iter.Key())
-			v := coerceToJSONable(iter.Value().Interface())
-			newMap[k] = v
-		}
-		value = newMap
-	}
-	return value
-}
-
 func decodeXML(reader io.Reader) (result interface{}, err error) {
 	document, err := io.ReadAll(reader)
 	if err != nil {
@@ -326,14 +247,20 @@ type XMLStringMap map[string]string
 func (_this XMLStringMap) MarshalXML(e *xml.Encoder, start xml.StartElement) (err error) {
 	tokens := []xml.Token{start}
 
-	for k, v := range _this {
+	keys := make([]string, 0, len(_this))
+	for k := range _this {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
 		t := xml.StartElement{
 			Name: xml.Name{
 				Space: "",
 				Local: k,
 			},
 		}
-		tokens = append(tokens, t, xml.CharData(v), xml.EndElement{Name: t.Name})
+		tokens = append(tokens, t, xml.CharData(_this[k]), xml.EndElement{Name: t.Name})
 	}
 
 	tokens = append(tokens, xml.EndElement{Name: start.Name})
@@ -21,7 +21,6 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"flag"
 	"fmt"
@@ -122,18 +121,3 @@ func (_this fieldValues) getBool(id string) bool {
 	}
 	return *v
 }
-
-func detectSrcFormat(reader *bufio.Reader) (string, error) {
-	begin, err := reader.Peek(1)
-	if err != nil {
-		return "", err
-	}
-	switch begin[0] {
-	case 'c':
-		return "cte", nil
-	case 0x83:
-		return "cbe", nil
-	default:
-		return "json", nil
-	}
-}
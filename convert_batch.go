@@ -0,0 +1,265 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type batchJob struct {
+	srcPath string
+	dstPath string
+}
+
+type batchResult struct {
+	job batchJob
+	err error
+}
+
+func (_this *cmdConvert) initBatch(srcPaths []string, srcFile string, dstFile string, fields fieldValues) (err error) {
+	if srcFile != "" || dstFile != "" {
+		return usageError("-s and -d can't be used when converting multiple files")
+	}
+	if _this.encoderConfig.qrPartPath != "" {
+		return usageError("-qp can't be used when converting multiple files")
+	}
+
+	_this.srcPaths = srcPaths
+	if _this.dstDir, err = fields.getRequiredString("od", "Output directory"); err != nil {
+		return
+	}
+	if _this.dstExt, err = fields.getString("de", "Destination extension"); err != nil {
+		return
+	}
+	if _this.dstExt == "" {
+		_this.dstExt = _this.dstFormat
+	}
+	_this.dstExt = strings.TrimPrefix(_this.dstExt, ".")
+
+	if _this.workers = int(fields.getUint("j")); _this.workers < 1 {
+		return usageError("Number of workers must be at least 1")
+	}
+	if knownSinks[_this.dstFormat] == nil {
+		return fmt.Errorf("%v: Unknown destination format", _this.dstFormat)
+	}
+	return
+}
+
+// Convert all files in the batch using a pool of workers, reporting failures
+// as they happen and a summary at the end.
+func (_this *cmdConvert) runBatch() (err error) {
+	jobs, err := _this.collectBatchJobs()
+	if err != nil {
+		return
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("no files to convert")
+	}
+
+	startTime := time.Now()
+	failedCount := 0
+	_this.convertJobs(jobs, func(result batchResult) {
+		if result.err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", result.job.srcPath, result.err)
+			failedCount++
+		}
+	})
+
+	fmt.Fprintf(os.Stderr, "Converted %v of %v file(s) in %v\n",
+		len(jobs)-failedCount, len(jobs), time.Since(startTime).Round(time.Millisecond))
+	if failedCount > 0 {
+		err = fmt.Errorf("%v file(s) failed to convert", failedCount)
+	}
+	return
+}
+
+// Convert files using a pool of workers, passing each result to onResult
+// (from a single goroutine) as it completes.
+func (_this *cmdConvert) convertJobs(jobs []batchJob, onResult func(batchResult)) {
+	jobQueue := make(chan batchJob)
+	results := make(chan batchResult)
+	var inProgress sync.WaitGroup
+	for i := 0; i < _this.workers; i++ {
+		inProgress.Add(1)
+		go func() {
+			defer inProgress.Done()
+			for job := range jobQueue {
+				results <- batchResult{job: job, err: _this.convertFile(job.srcPath, job.dstPath)}
+			}
+		}()
+	}
+	go func() {
+		for _, job := range jobs {
+			jobQueue <- job
+		}
+		close(jobQueue)
+	}()
+	go func() {
+		inProgress.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		onResult(result)
+	}
+}
+
+// Convert a single file in the batch. The destination is written to a
+// temporary file that replaces it only once the conversion succeeds, so that
+// a failed conversion leaves the previous output intact.
+func (_this *cmdConvert) convertFile(srcPath string, dstPath string) (err error) {
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return
+	}
+	defer srcFile.Close()
+	reader, converter, err := _this.prepareSource(srcPath, srcFile)
+	if err != nil {
+		return
+	}
+
+	dstDir := filepath.Dir(dstPath)
+	if err = os.MkdirAll(dstDir, 0755); err != nil {
+		return
+	}
+	mode := os.FileMode(0644)
+	if info, statErr := os.Stat(dstPath); statErr == nil {
+		mode = info.Mode().Perm()
+	}
+	tempFile, err := os.CreateTemp(dstDir, "."+filepath.Base(dstPath)+".*.tmp")
+	if err != nil {
+		return
+	}
+	tempPath := tempFile.Name()
+	err = converter(reader, _this.adaptWriter(tempFile), &_this.encoderConfig)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempPath, mode)
+	}
+	if err == nil {
+		err = os.Rename(tempPath, dstPath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+	}
+	return
+}
+
+// Expand the batch's source paths (files, globs and directories) into a list
+// of conversion jobs.
+func (_this *cmdConvert) collectBatchJobs() (jobs []batchJob, err error) {
+	dstPaths := make(map[string]string)
+	addJob := func(srcPath string, relPath string) error {
+		srcPath = filepath.Clean(srcPath)
+		dstPath := filepath.Join(_this.dstDir, strings.TrimSuffix(relPath, filepath.Ext(relPath))+"."+_this.dstExt)
+		if existing, ok := dstPaths[dstPath]; ok {
+			if existing == srcPath {
+				return nil
+			}
+			return fmt.Errorf("%v and %v would both be converted to %v", existing, srcPath, dstPath)
+		}
+		if sameFile(srcPath, dstPath) {
+			return fmt.Errorf("%v would be overwritten by its own conversion", srcPath)
+		}
+		dstPaths[dstPath] = srcPath
+		jobs = append(jobs, batchJob{srcPath: srcPath, dstPath: dstPath})
+		return nil
+	}
+
+	for _, pattern := range _this.srcPaths {
+		paths := []string{pattern}
+		if _, statErr := os.Stat(pattern); statErr != nil {
+			if paths, err = filepath.Glob(pattern); err != nil {
+				return nil, fmt.Errorf("%v: %v", pattern, err)
+			}
+			if len(paths) == 0 {
+				return nil, fmt.Errorf("%v: no such file", pattern)
+			}
+		}
+
+		for _, path := range paths {
+			var info os.FileInfo
+			if info, err = os.Stat(path); err != nil {
+				return
+			}
+			if !info.IsDir() {
+				if err = addJob(path, filepath.Base(path)); err != nil {
+					return
+				}
+				continue
+			}
+
+			err = filepath.WalkDir(path, func(filePath string, entry fs.DirEntry, walkErr error) error {
+				if walkErr != nil {
+					return walkErr
+				}
+				if entry.IsDir() {
+					// Don't convert files that were output by a previous conversion.
+					if sameFile(filePath, _this.dstDir) {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if !_this.isBatchSourceFile(filePath) {
+					return nil
+				}
+				relPath, relErr := filepath.Rel(path, filePath)
+				if relErr != nil {
+					return relErr
+				}
+				return addJob(filePath, relPath)
+			})
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Check if a file found in a directory should be converted.
+func (_this *cmdConvert) isBatchSourceFile(path string) bool {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	if _this.srcFormat != "" {
+		return ext == _this.srcFormat
+	}
+	return knownSources[ext] != nil
+}
+
+func sameFile(a string, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// The state of a watched source file when it was last converted.
+type watchedFile struct {
+	modTime time.Time
+	size    int64
+	failed  bool
+}
+
+func (_this *cmdConvert) initWatchFile(srcFile string, dstFile string) (err error) {
+	if srcFile == "" || srcFile == "-" || dstFile == "" || dstFile == "-" {
+		return usageError("Watch mode requires a source file (-s) and a destination file (-d), or files to convert in batch mode")
+	}
+	if sameFile(srcFile, dstFile) {
+		return fmt.Errorf("%v would be overwritten by its own conversion", srcFile)
+	}
+	_this.watchFile = batchJob{srcPath: srcFile, dstPath: dstFile}
+	_this.workers = 1
+	return
+}
+
+// Poll the source files, converting any that are new or have changed, until
+// interrupted. Errors are reported without stopping.
+func (_this *cmdConvert) runWatch() (err error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(_this.watchInterval)
+	defer ticker.Stop()
+
+	fmt.Fprintln(os.Stderr, "Watching for changes (press Ctrl-C to stop)")
+	watched := make(map[string]*watchedFile)
+	lastError := ""
+	for {
+		jobs, jobsErr := _this.watchJobs()
+		if jobsErr != nil {
+			// Only report the error once, rather than on every poll.
+			if jobsErr.Error() != lastError {
+				fmt.Fprintf(os.Stderr, "%v %v\n", time.Now().Format("15:04:05"), jobsErr)
+				lastError = jobsErr.Error()
+			}
+		} else {
+			lastError = ""
+			_this.convertChanged(jobs, watched)
+		}
+
+		select {
+		case <-signals:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (_this *cmdConvert) watchJobs() (jobs []batchJob, err error) {
+	if len(_this.srcPaths) > 0 {
+		return _this.collectBatchJobs()
+	}
+	if _, err = os.Stat(_this.watchFile.srcPath); err != nil {
+		return
+	}
+	jobs = []batchJob{_this.watchFile}
+	return
+}
+
+// Convert the source files that have changed since they were last converted,
+// or whose destination file has gone missing.
+func (_this *cmdConvert) convertChanged(jobs []batchJob, watched map[string]*watchedFile) {
+	var changed []batchJob
+	present := make(map[string]bool)
+	for _, job := range jobs {
+		info, err := os.Stat(job.srcPath)
+		if err != nil {
+			continue
+		}
+		present[job.srcPath] = true
+
+		previous := watched[job.srcPath]
+		if previous != nil && previous.modTime.Equal(info.ModTime()) && previous.size == info.Size() {
+			if _, err = os.Stat(job.dstPath); previous.failed || err == nil {
+				continue
+			}
+		}
+		watched[job.srcPath] = &watchedFile{modTime: info.ModTime(), size: info.Size()}
+		changed = append(changed, job)
+	}
+
+	for srcPath := range watched {
+		if !present[srcPath] {
+			delete(watched, srcPath)
+		}
+	}
+
+	if len(changed) == 0 {
+		return
+	}
+	_this.convertJobs(changed, func(result batchResult) {
+		timestamp := time.Now().Format("15:04:05")
+		if result.err != nil {
+			watched[result.job.srcPath].failed = true
+			fmt.Fprintf(os.Stderr, "%v %v: %v\n", timestamp, result.job.srcPath, result.err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%v Converted %v to %v\n", timestamp, result.job.srcPath, result.job.dstPath)
+	})
+}
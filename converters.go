@@ -3,252 +3,181 @@ package main
 import (
 	"bytes"
 	"fmt"
-	"image"
+	_ "image/gif"
+	_ "image/jpeg"
 	"io"
 	"strings"
 
 	"github.com/kstenerud/go-concise-encoding/cbe"
+	"github.com/kstenerud/go-concise-encoding/ce"
+	"github.com/kstenerud/go-concise-encoding/ce/events"
 	"github.com/kstenerud/go-concise-encoding/configuration"
 	"github.com/kstenerud/go-concise-encoding/cte"
 	"github.com/kstenerud/go-concise-encoding/rules"
-	qrcode "github.com/kstenerud/go-qrcode"
-	"github.com/liyue201/goqr"
 )
 
 type converter func(io.Reader, io.Writer, *encoderConfig) error
 
-var knownConverters = map[string]converter{
-	"cbe-cbe":   CBEToCBE,
-	"cbe-cte":   CBEToCTE,
-	"cbe-json":  CBEToJSON,
-	"cte-cte":   CTEToCTE,
-	"cte-cbe":   CTEToCBE,
-	"cbe-qr":    CBEToQR,
-	"cte-qr":    CTEToQR,
-	"cte-qrt":   CTEToQRT,
-	"qr-cte":    QRToCTE,
-	"qr-cbe":    QRToCBE,
-	"cte-json":  CTEToJSON,
-	"json-json": JSONToJSON,
-	"json-cbe":  JSONToCBE,
-	"json-cte":  JSONToCTE,
-	"xml-cbe":   XMLToCBE,
-	"xml-cte":   XMLToCTE,
-	"cbe-xml":   CBEToXML,
-	"cte-xml":   CTEToXML,
-}
-
-func getConverter(id string) (converter, error) {
-	converter := knownConverters[id]
-	if converter == nil {
-		return nil, fmt.Errorf("%v: Unknown converter", id)
-	}
-	return converter, nil
-}
-
-func CBEToCBE(in io.Reader, out io.Writer, config *encoderConfig) error {
-	opts := configuration.New()
-	encoder := cbe.NewEncoder(opts)
-	rules := rules.NewRules(encoder, opts)
-	decoder := cbe.NewDecoder(opts)
-	encoder.PrepareToEncode(out)
-	return decoder.Decode(in, rules)
-}
-
-func CBEToCTE(in io.Reader, out io.Writer, config *encoderConfig) error {
-	opts := configuration.New()
-	opts.Encoder.CTE.Indent = generateSpaces(config.indentSpaces)
-	encoder := cte.NewEncoder(opts)
-	rules := rules.NewRules(encoder, opts)
-	decoder := cbe.NewDecoder(opts)
-	encoder.PrepareToEncode(out)
-	return decoder.Decode(in, rules)
-}
-
-func CTEToCTE(in io.Reader, out io.Writer, config *encoderConfig) error {
-	opts := configuration.New()
-	encoder := cte.NewEncoder(opts)
-	rules := rules.NewRules(encoder, opts)
-	decoder := cte.NewDecoder(opts)
-	encoder.PrepareToEncode(out)
-	return decoder.Decode(in, rules)
-}
-
-func CTEToCBE(in io.Reader, out io.Writer, config *encoderConfig) error {
-	opts := configuration.New()
-	encoder := cbe.NewEncoder(opts)
-	rules := rules.NewRules(encoder, opts)
-	decoder := cte.NewDecoder(opts)
-	encoder.PrepareToEncode(out)
-	return decoder.Decode(in, rules)
-}
-
-func CBEToQR(in io.Reader, out io.Writer, config *encoderConfig) error {
-	opts := configuration.New()
-	encoder := cbe.NewEncoder(opts)
-	rules := rules.NewRules(encoder, opts)
-	decoder := cbe.NewDecoder(opts)
-
-	buff := &bytes.Buffer{}
-	encoder.PrepareToEncode(buff)
-	err := decoder.Decode(in, rules)
-	if err != nil {
-		return err
-	}
-
-	q, err := qrcode.New(buff.Bytes(), qrcode.RecoveryLevel(config.errorCorrection))
-	if err != nil {
-		return err
-	}
-	q.BorderSize = int(config.borderSize)
-	png, err := q.PNG(int(config.imageSize))
-	if err != nil {
+// An eventSource reads a document and drives its contents into an event receiver.
+type eventSource func(in io.Reader, receiver events.DataEventReceiver) error
+
+// An eventSink creates an event receiver that writes a document to out.
+// If end is not nil, it must be called once the document has been received.
+type eventSink func(out io.Writer, config *encoderConfig) (receiver events.DataEventReceiver, end func() error)
+
+var knownSources = make(map[string]eventSource)
+var knownSinks = make(map[string]eventSink)
+
+func init() {
+	knownSources["cbe"] = cbeSource
+	knownSinks["cbe"] = cbeSink
+	knownSources["cte"] = cteSource
+	knownSinks["cte"] = cteSink
+	knownSources["json"] = JSONToCE
+	knownSinks["json"] = jsonSink
+	knownSources["xml"] = XMLToCE
+	knownSinks["xml"] = xmlSink
+	knownSources["qr"] = qrSource
+	knownSinks["qr"] = qrSink
+	knownSources["qrt"] = qrtSource
+	knownSinks["qrt"] = qrtSink
+}
+
+// Get a converter that pipes the events from the source format into the
+// destination format.
+func getConverter(srcFormat string, dstFormat string) (converter, error) {
+	source := knownSources[srcFormat]
+	if source == nil {
+		return nil, fmt.Errorf("%v: Unknown source format", srcFormat)
+	}
+	sink := knownSinks[dstFormat]
+	if sink == nil {
+		return nil, fmt.Errorf("%v: Unknown destination format", dstFormat)
+	}
+
+	return func(in io.Reader, out io.Writer, config *encoderConfig) error {
+		return pipe(source, in, sink, out, config)
+	}, nil
+}
+
+// Pipe all events from a source into a sink, validating them along the way.
+// In canonical mode, the whole document is read and canonicalized first.
+func pipe(source eventSource, in io.Reader, sink eventSink, out io.Writer, config *encoderConfig) error {
+	if config != nil && config.canonical {
+		var err error
+		if source, err = canonicalSource(source, in); err != nil {
+			return err
+		}
+		in = nil
+	}
+	receiver, end := sink(out, config)
+	if err := runSource(source, in, rules.NewRules(receiver, configuration.New())); err != nil {
 		return err
 	}
-
-	_, err = out.Write(png)
-	return err
+	if end != nil {
+		return end()
+	}
+	return nil
+}
+
+// Run a source, converting any panics from the receiver chain into errors.
+func runSource(source eventSource, in io.Reader, receiver events.DataEventReceiver) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch v := r.(type) {
+			case error:
+				err = v
+			default:
+				err = fmt.Errorf("%v", v)
+			}
+		}
+	}()
+	err = source(in, receiver)
+	return
 }
 
-func CTEToQR(in io.Reader, out io.Writer, config *encoderConfig) error {
-	opts := configuration.New()
-	encoder := cbe.NewEncoder(opts)
-	rules := rules.NewRules(encoder, opts)
-	decoder := cte.NewDecoder(opts)
-
-	buff := &bytes.Buffer{}
-	encoder.PrepareToEncode(buff)
-	err := decoder.Decode(in, rules)
-	if err != nil {
-		return err
-	}
-
-	q, err := qrcode.New(buff.Bytes(), qrcode.RecoveryLevel(config.errorCorrection))
-	if err != nil {
-		return err
-	}
-	q.BorderSize = int(config.borderSize)
-	png, err := q.PNG(int(config.imageSize))
-	if err != nil {
-		return err
-	}
-
-	_, err = out.Write(png)
-	return err
+// An error that occurred at a known byte offset in a source's input.
+type sourceError struct {
+	offset int64
+	err    error
 }
 
-func CTEToQRT(in io.Reader, out io.Writer, config *encoderConfig) error {
-	opts := configuration.New()
-	encoder := cbe.NewEncoder(opts)
-	rules := rules.NewRules(encoder, opts)
-	decoder := cte.NewDecoder(opts)
+func (_this *sourceError) Error() string { return _this.err.Error() }
+func (_this *sourceError) Unwrap() error { return _this.err }
 
-	buff := &bytes.Buffer{}
-	encoder.PrepareToEncode(buff)
-	err := decoder.Decode(in, rules)
-	if err != nil {
-		return err
+// Attach the source's current input offset to any error it returns or panic
+// it raises. Must be deferred directly by the source.
+func annotateSourceError(getOffset func() int64, err *error) {
+	if r := recover(); r != nil {
+		switch v := r.(type) {
+		case error:
+			*err = v
+		default:
+			*err = fmt.Errorf("%v", v)
+		}
 	}
-
-	q, err := qrcode.New(buff.Bytes(), qrcode.RecoveryLevel(config.errorCorrection))
-	if err != nil {
-		return err
+	if *err != nil {
+		if _, ok := (*err).(*sourceError); !ok {
+			*err = &sourceError{offset: getOffset(), err: *err}
+		}
 	}
-	if config.invertText {
-		q.BorderSize = 0
-	}
-	art := q.ToString(config.invertText)
-	_, err = out.Write([]byte(art))
-	return err
 }
 
-func QRToCTE(in io.Reader, out io.Writer, config *encoderConfig) error {
-	img, _, err := image.Decode(in)
-	if err != nil {
-		return err
-	}
-	qrCodes, err := goqr.Recognize(img)
-	if err != nil {
-		return err
-	}
-	buff := &bytes.Buffer{}
-	for _, qrCode := range qrCodes {
-		buff.Write(qrCode.Payload)
-	}
+func cbeSource(in io.Reader, receiver events.DataEventReceiver) error {
+	decoder := cbe.NewDecoder(configuration.New())
+	return decoder.Decode(in, receiver)
+}
 
-	opts := configuration.New()
-	encoder := cte.NewEncoder(opts)
-	rules := rules.NewRules(encoder, opts)
-	decoder := cbe.NewDecoder(opts)
+func cbeSink(out io.Writer, config *encoderConfig) (events.DataEventReceiver, func() error) {
+	encoder := cbe.NewEncoder(configuration.New())
 	encoder.PrepareToEncode(out)
-	return decoder.Decode(buff, rules)
+	return encoder, nil
 }
 
-func QRToCBE(in io.Reader, out io.Writer, config *encoderConfig) error {
-	img, _, err := image.Decode(in)
-	if err != nil {
-		return err
-	}
-	qrCodes, err := goqr.Recognize(img)
-	if err != nil {
-		return err
-	}
-	buff := &bytes.Buffer{}
-	for _, qrCode := range qrCodes {
-		buff.Write(qrCode.Payload)
-	}
+func cteSource(in io.Reader, receiver events.DataEventReceiver) error {
+	decoder := cte.NewDecoder(configuration.New())
+	return decoder.Decode(in, receiver)
+}
 
+func cteSink(out io.Writer, config *encoderConfig) (events.DataEventReceiver, func() error) {
 	opts := configuration.New()
-	encoder := cbe.NewEncoder(opts)
-	rules := rules.NewRules(encoder, opts)
-	decoder := cbe.NewDecoder(opts)
+	opts.Encoder.CTE.Indent = generateSpaces(config.indentSpaces)
+	encoder := cte.NewEncoder(opts)
 	encoder.PrepareToEncode(out)
-	return decoder.Decode(buff, rules)
-}
-
-func JSONToCBE(in io.Reader, out io.Writer, config *encoderConfig) (err error) {
-	object, err := decodeJSON(in)
-	if err != nil {
-		return
-	}
-	err = encodeCBE(object, out, config)
-	return
+	return encoder, nil
 }
 
-func JSONToJSON(in io.Reader, out io.Writer, config *encoderConfig) (err error) {
-	object, err := decodeJSON(in)
-	if err != nil {
-		return
-	}
-	err = encodeJSON(object, out, config)
-	return
+func jsonSink(out io.Writer, config *encoderConfig) (events.DataEventReceiver, func() error) {
+	return NewJSONEventReceiver(out, config.indentSpaces), nil
 }
 
-func JSONToCTE(in io.Reader, out io.Writer, config *encoderConfig) (err error) {
-	object, err := decodeJSON(in)
-	if err != nil {
-		return
-	}
-	err = encodeCTE(object, out, config)
-	return
+func xmlSink(out io.Writer, config *encoderConfig) (events.DataEventReceiver, func() error) {
+	return NewXMLEventReceiver(out, config.indentSpaces), nil
 }
 
-func CBEToJSON(in io.Reader, out io.Writer, config *encoderConfig) (err error) {
-	object, err := decodeCBE(in)
-	if err != nil {
-		return
+// Adapt an event source into an object decoder by passing the events through an
+// in-memory CBE document.
+func sourceDecoder(source eventSource) decoder {
+	return func(in io.Reader) (interface{}, error) {
+		buff := &bytes.Buffer{}
+		receiver, _ := cbeSink(buff, nil)
+		if err := runSource(source, in, rules.NewRules(receiver, configuration.New())); err != nil {
+			return nil, err
+		}
+		return decodeCBE(buff)
 	}
-	err = encodeJSON(object, out, config)
-	return
 }
 
-func CTEToJSON(in io.Reader, out io.Writer, config *encoderConfig) (err error) {
-	object, err := decodeCTE(in)
-	if err != nil {
-		return
+// Adapt an event sink into an object encoder by passing the object through an
+// in-memory CBE document.
+func sinkEncoder(sink eventSink) encoder {
+	return func(value interface{}, out io.Writer, config *encoderConfig) error {
+		buff := &bytes.Buffer{}
+		if err := ce.MarshalCBE(value, buff, configuration.New()); err != nil {
+			return err
+		}
+		return pipe(cbeSource, buff, sink, out, config)
 	}
-	err = encodeJSON(object, out, config)
-	return
 }
 
 func generateSpaces(count int) string {
@@ -0,0 +1,820 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/kstenerud/go-concise-encoding/ce/events"
+	"github.com/kstenerud/go-concise-encoding/version"
+
+	"github.com/cockroachdb/apd/v2"
+	compact_float "github.com/kstenerud/go-compact-float"
+	compact_time "github.com/kstenerud/go-compact-time"
+)
+
+// Read a JSON document token by token, driving the equivalent events into the
+// receiver. Any JSON value is accepted at the top level.
+func JSONToCE(in io.Reader, receiver events.DataEventReceiver) (err error) {
+	decoder := json.NewDecoder(skipBOM(in))
+	decoder.UseNumber()
+	defer annotateSourceError(decoder.InputOffset, &err)
+
+	receiver.OnBeginDocument()
+	receiver.OnVersion(version.ConciseEncodingVersion)
+
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("unexpected end of JSON document")
+			}
+			return err
+		}
+
+		switch v := token.(type) {
+		case json.Delim:
+			switch v {
+			case '{':
+				receiver.OnMap()
+				depth++
+			case '[':
+				receiver.OnList()
+				depth++
+			default:
+				receiver.OnEndContainer()
+				depth--
+			}
+		case string:
+			receiver.OnStringlikeArray(events.ArrayTypeString, v)
+		case json.Number:
+			if err = jsonNumberToCE(v, receiver); err != nil {
+				return err
+			}
+		case bool:
+			receiver.OnBoolean(v)
+		case nil:
+			receiver.OnNull()
+		default:
+			panic(fmt.Errorf("BUG: Unhandled JSON token type %T", token))
+		}
+
+		if depth == 0 {
+			break
+		}
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		if err == nil {
+			return fmt.Errorf("unexpected data after top-level JSON value")
+		}
+		return err
+	}
+
+	receiver.OnEndDocument()
+	return nil
+}
+
+// Convert a JSON number without loss of precision. Integers of any size become
+// CE integers, and numbers with a fractional part or exponent become decimal
+// floats.
+func jsonNumberToCE(number json.Number, receiver events.DataEventReceiver) error {
+	str := string(number)
+	if !strings.ContainsAny(str, ".eE") {
+		v, ok := new(big.Int).SetString(str, 10)
+		if !ok {
+			return fmt.Errorf("%v: cannot convert JSON number", str)
+		}
+		switch {
+		case v.IsUint64():
+			if strings.HasPrefix(str, "-") {
+				// -0 can only be represented as a float.
+				receiver.OnFloat(math.Copysign(0, -1))
+			} else {
+				receiver.OnPositiveInt(v.Uint64())
+			}
+		case v.Sign() < 0 && new(big.Int).Neg(v).IsUint64():
+			receiver.OnNegativeInt(new(big.Int).Neg(v).Uint64())
+		default:
+			receiver.OnBigInt(v)
+		}
+		return nil
+	}
+
+	v, _, err := apd.NewFromString(str)
+	if err != nil {
+		return fmt.Errorf("%v: cannot convert JSON number: %v", str, err)
+	}
+	switch {
+	case v.Coeff.Sign() == 0 && v.Negative:
+		receiver.OnFloat(math.Copysign(0, -1))
+	case v.Coeff.IsInt64():
+		significand := v.Coeff.Int64()
+		if v.Negative {
+			significand = -significand
+		}
+		receiver.OnDecimalFloat(compact_float.DFloatValue(v.Exponent, significand))
+	default:
+		receiver.OnBigDecimalFloat(v)
+	}
+	return nil
+}
+
+// JSONEventReceiver writes JSON as events arrive. Types that JSON has no
+// equivalent for are mapped as follows:
+//
+//   - Integers, floats and decimal floats of any size are written as numbers.
+//   - NaN and infinities are written as the strings "nan", "snan", "inf", "-inf".
+//   - UIDs are written as hyphenated hex strings, and times in their CTE form.
+//   - Byte arrays are written as base64 strings, bit arrays as lists of
+//     booleans, and other typed arrays as lists of numbers or UID strings.
+//   - Media is written as a data URL string.
+//   - Custom binary is written as a base64 string, and custom text as a string.
+//   - Non-string map keys are written as their string representation.
+//   - Records are written as maps using the keys from their record type.
+//   - Nodes are written as {"value": v, "children": [...]}.
+//   - Edges are written as {"source": s, "description": d, "destination": d}.
+//   - References are written as {"$ref": "#id"} (local) or {"$ref": "url"}
+//     (remote). Markers, comments and padding are dropped.
+type JSONEventReceiver struct {
+	writer            jsonWriter
+	frames            []jsonFrame
+	recordTypes       map[string][]string
+	array             jsonArrayState
+	isRemoteReference bool
+}
+
+func NewJSONEventReceiver(out io.Writer, indentSpaces int) *JSONEventReceiver {
+	return &JSONEventReceiver{
+		writer: jsonWriter{
+			out:    bufio.NewWriter(out),
+			indent: generateSpaces(indentSpaces),
+		},
+		recordTypes: make(map[string][]string),
+	}
+}
+
+func (_this *JSONEventReceiver) OnBeginDocument() {}
+
+func (_this *JSONEventReceiver) OnVersion(uint64) {}
+
+func (_this *JSONEventReceiver) OnPadding() {}
+
+func (_this *JSONEventReceiver) OnComment(isMultiline bool, contents []byte) {}
+
+func (_this *JSONEventReceiver) OnNull() {
+	_this.onScalar("null", "null")
+}
+
+func (_this *JSONEventReceiver) OnBoolean(v bool) {
+	if v {
+		_this.OnTrue()
+	} else {
+		_this.OnFalse()
+	}
+}
+
+func (_this *JSONEventReceiver) OnTrue() {
+	_this.onScalar("true", "true")
+}
+
+func (_this *JSONEventReceiver) OnFalse() {
+	_this.onScalar("false", "false")
+}
+
+func (_this *JSONEventReceiver) OnPositiveInt(v uint64) {
+	_this.onNumber(strconv.FormatUint(v, 10))
+}
+
+func (_this *JSONEventReceiver) OnNegativeInt(v uint64) {
+	_this.onNumber("-" + strconv.FormatUint(v, 10))
+}
+
+func (_this *JSONEventReceiver) OnInt(v int64) {
+	_this.onNumber(strconv.FormatInt(v, 10))
+}
+
+func (_this *JSONEventReceiver) OnBigInt(v *big.Int) {
+	_this.onNumber(v.String())
+}
+
+func (_this *JSONEventReceiver) OnFloat(v float64) {
+	switch {
+	case math.IsNaN(v):
+		_this.OnNan(false)
+	case math.IsInf(v, 1):
+		_this.onString("inf")
+	case math.IsInf(v, -1):
+		_this.onString("-inf")
+	default:
+		_this.onNumber(strconv.FormatFloat(v, 'g', -1, 64))
+	}
+}
+
+func (_this *JSONEventReceiver) OnBigFloat(v *big.Float) {
+	if v.IsInf() {
+		if v.Signbit() {
+			_this.onString("-inf")
+		} else {
+			_this.onString("inf")
+		}
+		return
+	}
+	_this.onNumber(v.Text('g', -1))
+}
+
+func (_this *JSONEventReceiver) OnDecimalFloat(v compact_float.DFloat) {
+	_this.onNumber(v.String())
+}
+
+func (_this *JSONEventReceiver) OnBigDecimalFloat(v *apd.Decimal) {
+	switch v.Form {
+	case apd.Infinite:
+		if v.Negative {
+			_this.onString("-inf")
+		} else {
+			_this.onString("inf")
+		}
+	case apd.NaN:
+		_this.OnNan(false)
+	case apd.NaNSignaling:
+		_this.OnNan(true)
+	default:
+		_this.onNumber(v.String())
+	}
+}
+
+func (_this *JSONEventReceiver) OnNan(isSignaling bool) {
+	if isSignaling {
+		_this.onString("snan")
+	} else {
+		_this.onString("nan")
+	}
+}
+
+func (_this *JSONEventReceiver) OnUID(v []byte) {
+	_this.onString(formatUID(v))
+}
+
+func (_this *JSONEventReceiver) OnTime(v compact_time.Time) {
+	_this.onString(v.String())
+}
+
+func (_this *JSONEventReceiver) OnStringlikeArray(arrayType events.ArrayType, str string) {
+	if _this.isRemoteReference {
+		_this.isRemoteReference = false
+		_this.onReference(str)
+		return
+	}
+	_this.onString(str)
+}
+
+func (_this *JSONEventReceiver) OnArray(arrayType events.ArrayType, elemCount uint64, elems []byte) {
+	switch arrayType {
+	case events.ArrayTypeString, events.ArrayTypeResourceID:
+		_this.OnStringlikeArray(arrayType, string(elems))
+	case events.ArrayTypeUint8:
+		_this.onString(base64.StdEncoding.EncodeToString(elems))
+	case events.ArrayTypeBit:
+		_this.onTypedArray(elemCount, func(i uint64) string {
+			if elems[i/8]&(1<<(i%8)) != 0 {
+				return "true"
+			}
+			return "false"
+		})
+	case events.ArrayTypeUint16:
+		_this.onTypedArray(elemCount, func(i uint64) string {
+			return strconv.FormatUint(uint64(binary.LittleEndian.Uint16(elems[i*2:])), 10)
+		})
+	case events.ArrayTypeUint32:
+		_this.onTypedArray(elemCount, func(i uint64) string {
+			return strconv.FormatUint(uint64(binary.LittleEndian.Uint32(elems[i*4:])), 10)
+		})
+	case events.ArrayTypeUint64:
+		_this.onTypedArray(elemCount, func(i uint64) string {
+			return strconv.FormatUint(binary.LittleEndian.Uint64(elems[i*8:]), 10)
+		})
+	case events.ArrayTypeInt8:
+		_this.onTypedArray(elemCount, func(i uint64) string {
+			return strconv.FormatInt(int64(int8(elems[i])), 10)
+		})
+	case events.ArrayTypeInt16:
+		_this.onTypedArray(elemCount, func(i uint64) string {
+			return strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(elems[i*2:]))), 10)
+		})
+	case events.ArrayTypeInt32:
+		_this.onTypedArray(elemCount, func(i uint64) string {
+			return strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(elems[i*4:]))), 10)
+		})
+	case events.ArrayTypeInt64:
+		_this.onTypedArray(elemCount, func(i uint64) string {
+			return strconv.FormatInt(int64(binary.LittleEndian.Uint64(elems[i*8:])), 10)
+		})
+	case events.ArrayTypeFloat16:
+		_this.onTypedArray(elemCount, func(i uint64) string {
+			bits := uint32(binary.LittleEndian.Uint16(elems[i*2:])) << 16
+			return jsonFloatToken(float64(math.Float32frombits(bits)), 32)
+		})
+	case events.ArrayTypeFloat32:
+		_this.onTypedArray(elemCount, func(i uint64) string {
+			return jsonFloatToken(float64(math.Float32frombits(binary.LittleEndian.Uint32(elems[i*4:]))), 32)
+		})
+	case events.ArrayTypeFloat64:
+		_this.onTypedArray(elemCount, func(i uint64) string {
+			return jsonFloatToken(math.Float64frombits(binary.LittleEndian.Uint64(elems[i*8:])), 64)
+		})
+	case events.ArrayTypeUID:
+		_this.onTypedArray(elemCount, func(i uint64) string {
+			return quoteJSONString(formatUID(elems[i*16:]))
+		})
+	default:
+		panic(fmt.Errorf("cannot convert array type %v to JSON", arrayType))
+	}
+}
+
+func (_this *JSONEventReceiver) OnMedia(mediaType string, data []byte) {
+	_this.onString("data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data))
+}
+
+func (_this *JSONEventReceiver) OnCustomBinary(customType uint64, data []byte) {
+	_this.onString(base64.StdEncoding.EncodeToString(data))
+}
+
+func (_this *JSONEventReceiver) OnCustomText(customType uint64, data string) {
+	_this.onString(data)
+}
+
+func (_this *JSONEventReceiver) OnArrayBegin(arrayType events.ArrayType) {
+	_this.array.begin(jsonArrayKindArray, arrayType)
+}
+
+func (_this *JSONEventReceiver) OnMediaBegin(mediaType string) {
+	_this.array.begin(jsonArrayKindMedia, events.ArrayTypeUint8)
+	_this.array.mediaType = mediaType
+}
+
+func (_this *JSONEventReceiver) OnCustomBegin(arrayType events.ArrayType, customType uint64) {
+	_this.array.begin(jsonArrayKindCustom, arrayType)
+	_this.array.customType = customType
+}
+
+func (_this *JSONEventReceiver) OnArrayChunk(chunkSize uint64, moreChunksComing bool) {
+	_this.array.elementCount += chunkSize
+	_this.array.bytesRemaining = arrayByteCount(_this.array.arrayType, chunkSize)
+	_this.array.moreChunksComing = moreChunksComing
+	if _this.array.bytesRemaining == 0 && !moreChunksComing {
+		_this.endArray()
+	}
+}
+
+func (_this *JSONEventReceiver) OnArrayData(data []byte) {
+	_this.array.data = append(_this.array.data, data...)
+	_this.array.bytesRemaining -= uint64(len(data))
+	if _this.array.bytesRemaining == 0 && !_this.array.moreChunksComing {
+		_this.endArray()
+	}
+}
+
+func (_this *JSONEventReceiver) OnList() {
+	_this.beginContainer(jsonFrameList, "list")
+	_this.writer.BeginArray()
+}
+
+func (_this *JSONEventReceiver) OnMap() {
+	_this.beginContainer(jsonFrameMap, "map")
+	_this.writer.BeginObject()
+}
+
+func (_this *JSONEventReceiver) OnNode() {
+	_this.beginContainer(jsonFrameNode, "node")
+	_this.writer.BeginObject()
+}
+
+func (_this *JSONEventReceiver) OnEdge() {
+	_this.beginContainer(jsonFrameEdge, "edge")
+	_this.writer.BeginObject()
+}
+
+func (_this *JSONEventReceiver) OnRecordType(id []byte) {
+	_this.frames = append(_this.frames, jsonFrame{
+		frameType: jsonFrameRecordType,
+		id:        string(id),
+	})
+}
+
+func (_this *JSONEventReceiver) OnRecord(id []byte) {
+	keys, ok := _this.recordTypes[string(id)]
+	if !ok {
+		panic(fmt.Errorf("record type %v has not been defined", string(id)))
+	}
+	_this.beginContainer(jsonFrameRecord, "record")
+	_this.frames[len(_this.frames)-1].keys = keys
+	_this.writer.BeginObject()
+}
+
+func (_this *JSONEventReceiver) OnEndContainer() {
+	frame := _this.frames[len(_this.frames)-1]
+	_this.frames = _this.frames[:len(_this.frames)-1]
+
+	switch frame.frameType {
+	case jsonFrameRecordType:
+		_this.recordTypes[frame.id] = frame.keys
+	case jsonFrameNode:
+		if frame.count < 2 {
+			_this.writer.Key("children")
+			_this.writer.BeginArray()
+		}
+		_this.writer.End()
+		_this.writer.End()
+	default:
+		_this.writer.End()
+	}
+}
+
+func (_this *JSONEventReceiver) OnMarker([]byte) {}
+
+func (_this *JSONEventReceiver) OnReferenceLocal(id []byte) {
+	_this.onReference("#" + string(id))
+}
+
+func (_this *JSONEventReceiver) OnReferenceRemote() {
+	_this.isRemoteReference = true
+}
+
+func (_this *JSONEventReceiver) OnConstant(name []byte) {
+	_this.onString("#" + string(name))
+}
+
+func (_this *JSONEventReceiver) OnEndDocument() {
+	if err := _this.writer.out.Flush(); err != nil {
+		panic(err)
+	}
+}
+
+func (_this *JSONEventReceiver) endArray() {
+	array := &_this.array
+	switch array.kind {
+	case jsonArrayKindMedia:
+		_this.OnMedia(array.mediaType, array.data)
+	case jsonArrayKindCustom:
+		if array.arrayType == events.ArrayTypeCustomText {
+			_this.OnCustomText(array.customType, string(array.data))
+		} else {
+			_this.OnCustomBinary(array.customType, array.data)
+		}
+	default:
+		_this.OnArray(array.arrayType, array.elementCount, array.data)
+	}
+}
+
+// Prepare the current container to receive a value, returning true if the
+// value is in a position that requires a string key.
+func (_this *JSONEventReceiver) prepareValue() (isKey bool) {
+	if len(_this.frames) == 0 {
+		return false
+	}
+
+	frame := &_this.frames[len(_this.frames)-1]
+	index := frame.count
+	frame.count++
+
+	switch frame.frameType {
+	case jsonFrameMap:
+		return index&1 == 0
+	case jsonFrameRecordType:
+		return true
+	case jsonFrameRecord:
+		if index >= len(frame.keys) {
+			panic(fmt.Errorf("record contains more values than its type has keys"))
+		}
+		_this.writer.Key(frame.keys[index])
+	case jsonFrameNode:
+		switch index {
+		case 0:
+			_this.writer.Key("value")
+		case 1:
+			_this.writer.Key("children")
+			_this.writer.BeginArray()
+		}
+	case jsonFrameEdge:
+		if index >= len(jsonEdgeKeys) {
+			panic(fmt.Errorf("edge contains more than %v values", len(jsonEdgeKeys)))
+		}
+		_this.writer.Key(jsonEdgeKeys[index])
+	}
+	return false
+}
+
+func (_this *JSONEventReceiver) beginContainer(frameType jsonFrameType, name string) {
+	if _this.prepareValue() {
+		panic(fmt.Errorf("cannot use a %v as a JSON map key", name))
+	}
+	_this.frames = append(_this.frames, jsonFrame{frameType: frameType})
+}
+
+// Write a scalar value, using keyText instead if it's in a key position.
+func (_this *JSONEventReceiver) onScalar(token string, keyText string) {
+	if !_this.prepareValue() {
+		_this.writer.Value(token)
+		return
+	}
+
+	frame := &_this.frames[len(_this.frames)-1]
+	if frame.frameType == jsonFrameRecordType {
+		frame.keys = append(frame.keys, keyText)
+	} else {
+		_this.writer.Key(keyText)
+	}
+}
+
+func (_this *JSONEventReceiver) onString(str string) {
+	_this.onScalar(quoteJSONString(str), str)
+}
+
+// Write a number if it's valid JSON number syntax, or a string otherwise.
+func (_this *JSONEventReceiver) onNumber(str string) {
+	if isJSONNumber(str) {
+		_this.onScalar(str, str)
+	} else {
+		_this.onString(str)
+	}
+}
+
+func (_this *JSONEventReceiver) onReference(ref string) {
+	if _this.prepareValue() {
+		panic(fmt.Errorf("cannot use a reference as a JSON map key"))
+	}
+	_this.writer.BeginObject()
+	_this.writer.Key("$ref")
+	_this.writer.Value(quoteJSONString(ref))
+	_this.writer.End()
+}
+
+func (_this *JSONEventReceiver) onTypedArray(elemCount uint64, getElement func(uint64) string) {
+	if _this.prepareValue() {
+		panic(fmt.Errorf("cannot use an array as a JSON map key"))
+	}
+	_this.writer.BeginArray()
+	for i := uint64(0); i < elemCount; i++ {
+		_this.writer.Value(getElement(i))
+	}
+	_this.writer.End()
+}
+
+var jsonEdgeKeys = []string{"source", "description", "destination"}
+
+type jsonFrameType int
+
+const (
+	jsonFrameList jsonFrameType = iota
+	jsonFrameMap
+	jsonFrameRecordType
+	jsonFrameRecord
+	jsonFrameNode
+	jsonFrameEdge
+)
+
+type jsonFrame struct {
+	frameType jsonFrameType
+	count     int
+	id        string
+	keys      []string
+}
+
+type jsonArrayKind int
+
+const (
+	jsonArrayKindArray jsonArrayKind = iota
+	jsonArrayKindMedia
+	jsonArrayKindCustom
+)
+
+type jsonArrayState struct {
+	kind             jsonArrayKind
+	arrayType        events.ArrayType
+	mediaType        string
+	customType       uint64
+	elementCount     uint64
+	bytesRemaining   uint64
+	moreChunksComing bool
+	data             []byte
+}
+
+func (_this *jsonArrayState) begin(kind jsonArrayKind, arrayType events.ArrayType) {
+	_this.kind = kind
+	_this.arrayType = arrayType
+	_this.elementCount = 0
+	_this.data = _this.data[:0]
+}
+
+// jsonWriter handles the punctuation and indentation of a JSON document.
+type jsonWriter struct {
+	out        *bufio.Writer
+	indent     string
+	levels     []jsonWriterLevel
+	isAfterKey bool
+}
+
+type jsonWriterLevel struct {
+	isObject bool
+	count    int
+}
+
+func (_this *jsonWriter) BeginObject() {
+	_this.beginEntry()
+	_this.out.WriteByte('{')
+	_this.levels = append(_this.levels, jsonWriterLevel{isObject: true})
+}
+
+func (_this *jsonWriter) BeginArray() {
+	_this.beginEntry()
+	_this.out.WriteByte('[')
+	_this.levels = append(_this.levels, jsonWriterLevel{})
+}
+
+func (_this *jsonWriter) End() {
+	level := _this.levels[len(_this.levels)-1]
+	_this.levels = _this.levels[:len(_this.levels)-1]
+	if level.count > 0 {
+		_this.newline()
+	}
+	if level.isObject {
+		_this.out.WriteByte('}')
+	} else {
+		_this.out.WriteByte(']')
+	}
+}
+
+func (_this *jsonWriter) Key(key string) {
+	_this.beginEntry()
+	_this.out.WriteString(quoteJSONString(key))
+	_this.out.WriteByte(':')
+	if len(_this.indent) > 0 {
+		_this.out.WriteByte(' ')
+	}
+	_this.isAfterKey = true
+}
+
+func (_this *jsonWriter) Value(token string) {
+	_this.beginEntry()
+	_this.out.WriteString(token)
+}
+
+func (_this *jsonWriter) beginEntry() {
+	if _this.isAfterKey {
+		_this.isAfterKey = false
+		return
+	}
+	if len(_this.levels) == 0 {
+		return
+	}
+	level := &_this.levels[len(_this.levels)-1]
+	if level.count > 0 {
+		_this.out.WriteByte(',')
+	}
+	level.count++
+	_this.newline()
+}
+
+func (_this *jsonWriter) newline() {
+	if len(_this.indent) == 0 {
+		return
+	}
+	_this.out.WriteByte('\n')
+	for i := 0; i < len(_this.levels); i++ {
+		_this.out.WriteString(_this.indent)
+	}
+}
+
+func quoteJSONString(str string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, ch := range str {
+		switch ch {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if ch < 0x20 {
+				fmt.Fprintf(&b, `\u%04x`, ch)
+			} else {
+				b.WriteRune(ch)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Check if str follows the JSON number grammar.
+func isJSONNumber(str string) bool {
+	i := 0
+	if i < len(str) && str[i] == '-' {
+		i++
+	}
+	switch {
+	case i < len(str) && str[i] == '0':
+		i++
+	case i < len(str) && str[i] >= '1' && str[i] <= '9':
+		for i < len(str) && str[i] >= '0' && str[i] <= '9' {
+			i++
+		}
+	default:
+		return false
+	}
+	if i < len(str) && str[i] == '.' {
+		i++
+		start := i
+		for i < len(str) && str[i] >= '0' && str[i] <= '9' {
+			i++
+		}
+		if i == start {
+			return false
+		}
+	}
+	if i < len(str) && (str[i] == 'e' || str[i] == 'E') {
+		i++
+		if i < len(str) && (str[i] == '+' || str[i] == '-') {
+			i++
+		}
+		start := i
+		for i < len(str) && str[i] >= '0' && str[i] <= '9' {
+			i++
+		}
+		if i == start {
+			return false
+		}
+	}
+	return i == len(str)
+}
+
+func jsonFloatToken(v float64, bitSize int) string {
+	switch {
+	case math.IsNaN(v):
+		return `"nan"`
+	case math.IsInf(v, 1):
+		return `"inf"`
+	case math.IsInf(v, -1):
+		return `"-inf"`
+	default:
+		return strconv.FormatFloat(v, 'g', -1, bitSize)
+	}
+}
+
+func formatUID(v []byte) string {
+	return fmt.Sprintf("%02x%02x%02x%02x-%02x%02x-%02x%02x-%02x%02x-%02x%02x%02x%02x%02x%02x",
+		v[0], v[1], v[2], v[3], v[4], v[5], v[6], v[7], v[8], v[9], v[10], v[11], v[12], v[13], v[14], v[15])
+}
+
+// Get the number of bytes occupied by count elements of an array type.
+func arrayByteCount(arrayType events.ArrayType, count uint64) uint64 {
+	switch arrayType {
+	case events.ArrayTypeBit:
+		return (count + 7) / 8
+	case events.ArrayTypeUint16, events.ArrayTypeInt16, events.ArrayTypeFloat16:
+		return count * 2
+	case events.ArrayTypeUint32, events.ArrayTypeInt32, events.ArrayTypeFloat32:
+		return count * 4
+	case events.ArrayTypeUint64, events.ArrayTypeInt64, events.ArrayTypeFloat64:
+		return count * 8
+	case events.ArrayTypeUID:
+		return count * 16
+	default:
+		return count
+	}
+}
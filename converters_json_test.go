@@ -0,0 +1,157 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/cockroachdb/apd/v2"
+	compact_float "github.com/kstenerud/go-compact-float"
+	"github.com/kstenerud/go-concise-encoding/ce/events"
+)
+
+// Records the single number a conversion produces. Any other event panics
+// through the nil embedded receiver.
+type numberRecorder struct {
+	events.DataEventReceiver
+	value interface{}
+}
+
+func (_this *numberRecorder) OnPositiveInt(v uint64) {
+	if v <= math.MaxInt64 {
+		_this.value = int64(v)
+	} else {
+		_this.value = v
+	}
+}
+
+func (_this *numberRecorder) OnNegativeInt(v uint64) {
+	if v <= 1<<63 {
+		_this.value = int64(-v)
+	} else {
+		_this.value = new(big.Int).Neg(new(big.Int).SetUint64(v))
+	}
+}
+
+func (_this *numberRecorder) OnBigInt(v *big.Int) {
+	_this.value = v
+}
+
+func (_this *numberRecorder) OnFloat(v float64) {
+	_this.value = v
+}
+
+func (_this *numberRecorder) OnDecimalFloat(v compact_float.DFloat) {
+	_this.value = v
+}
+
+func (_this *numberRecorder) OnBigDecimalFloat(v *apd.Decimal) {
+	_this.value = v
+}
+
+// Numbers are the same if they have the same type and representation, which
+// also tells -0 apart from 0.
+func sameNumber(a, b interface{}) bool {
+	return reflect.TypeOf(a) == reflect.TypeOf(b) && fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func bigIntValue(t *testing.T, str string) *big.Int {
+	t.Helper()
+	v, ok := new(big.Int).SetString(str, 10)
+	if !ok {
+		t.Fatalf("%v: invalid big int", str)
+	}
+	return v
+}
+
+func bigDecimalValue(t *testing.T, str string) *apd.Decimal {
+	t.Helper()
+	v, _, err := apd.NewFromString(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestJSONNumberToCE(t *testing.T) {
+	tests := []struct {
+		number   string
+		expected interface{}
+	}{
+		{"0", int64(0)},
+		{"1", int64(1)},
+		{"9223372036854775807", int64(math.MaxInt64)},
+		{"9223372036854775808", uint64(math.MaxInt64 + 1)},
+		{"18446744073709551615", uint64(math.MaxUint64)},
+		{"18446744073709551616", bigIntValue(t, "18446744073709551616")},
+		{"-1", int64(-1)},
+		{"-9223372036854775808", int64(math.MinInt64)},
+		{"-9223372036854775809", bigIntValue(t, "-9223372036854775809")},
+		{"-18446744073709551616", bigIntValue(t, "-18446744073709551616")},
+		{"-0", math.Copysign(0, -1)},
+		{"-0.0", math.Copysign(0, -1)},
+		{"-0e10", math.Copysign(0, -1)},
+		{"1.5", compact_float.DFloatValue(-1, 15)},
+		{"-1.5", compact_float.DFloatValue(-1, -15)},
+		{"1e5", compact_float.DFloatValue(5, 1)},
+		{"1.25E-3", compact_float.DFloatValue(-5, 125)},
+		{"123456789012345678901.5", bigDecimalValue(t, "123456789012345678901.5")},
+	}
+
+	for _, test := range tests {
+		recorder := &numberRecorder{}
+		if err := jsonNumberToCE(json.Number(test.number), recorder); err != nil {
+			t.Errorf("%v: %v", test.number, err)
+			continue
+		}
+		if !sameNumber(recorder.value, test.expected) {
+			t.Errorf("%v: expected %T %v but got %T %v", test.number, test.expected, test.expected, recorder.value, recorder.value)
+		}
+
+		// Writing the number back out as JSON must preserve its value.
+		buffer := &bytes.Buffer{}
+		sink := NewJSONEventReceiver(buffer, 0)
+		if err := jsonNumberToCE(json.Number(test.number), sink); err != nil {
+			t.Fatal(err)
+		}
+		sink.OnEndDocument()
+		reparsed := &numberRecorder{}
+		if err := jsonNumberToCE(json.Number(buffer.String()), reparsed); err != nil {
+			t.Errorf("%v: cannot reparse %v: %v", test.number, buffer, err)
+		} else if !sameNumber(reparsed.value, recorder.value) {
+			t.Errorf("%v: round trip produced %v", test.number, reparsed.value)
+		}
+	}
+}
+
+func TestJSONNumberToCEErrors(t *testing.T) {
+	for _, number := range []string{"", "abc", "1.2.3", "1e"} {
+		if err := jsonNumberToCE(json.Number(number), &numberRecorder{}); err == nil {
+			t.Errorf("%q: expected an error", number)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kstenerud/go-concise-encoding/ce/events"
+	qrcode "github.com/kstenerud/go-qrcode"
+	"github.com/liyue201/goqr"
+)
+
+// Documents that are too big for a single QR code are split across multiple
+// codes. Each code's payload begins with a header:
+//
+//	magic (0xce 0x71), part number (from 1), part count, CRC-32 of the whole document (big endian)
+//
+// Single code documents have no header, and contain only the CBE document.
+const qrPartHeaderSize = 8
+const maxQRParts = 255
+
+var errTooManyQRParts = errors.New("document is too big")
+
+var errImageTooBig = errors.New("image is too big")
+
+var qrPartMagic = []byte{0xce, 'q'}
+
+// The maximum binary payload of the largest QR code (version 40) at each error
+// correction level.
+var qrCapacities = []int{2953, 2331, 1663, 1273}
+
+// Read all QR codes from one or more concatenated images, and decode the CBE
+// document they contain.
+func qrSource(in io.Reader, receiver events.DataEventReceiver) error {
+	return limitedQRSource(0)(in, receiver)
+}
+
+// Create a QR image source that rejects images wider or taller than
+// maxImageSize pixels (0 = no limit) before decoding them, since even a small
+// compressed image can declare dimensions that need gigabytes of memory.
+func limitedQRSource(maxImageSize uint) eventSource {
+	return func(in io.Reader, receiver events.DataEventReceiver) error {
+		reader := bufio.NewReader(in)
+		var payloads [][]byte
+		for {
+			if maxImageSize > 0 {
+				var err error
+				if reader, err = checkImageSize(reader, maxImageSize); err != nil {
+					return err
+				}
+			}
+			img, _, err := image.Decode(reader)
+			if err != nil {
+				return err
+			}
+			qrCodes, err := goqr.Recognize(img)
+			if err != nil {
+				return err
+			}
+			for _, qrCode := range qrCodes {
+				payloads = append(payloads, qrCode.Payload)
+			}
+			if _, err = reader.Peek(1); err != nil {
+				break
+			}
+		}
+
+		document, err := joinQRParts(payloads)
+		if err != nil {
+			return err
+		}
+		return cbeSource(bytes.NewReader(document), receiver)
+	}
+}
+
+// Check the dimensions in the header of the next image in reader, returning a
+// reader that is still positioned at the start of the image.
+func checkImageSize(reader *bufio.Reader, maxImageSize uint) (*bufio.Reader, error) {
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(reader, &header))
+	reader = bufio.NewReader(io.MultiReader(&header, reader))
+	if err != nil {
+		return reader, err
+	}
+	if uint(config.Width) > maxImageSize || uint(config.Height) > maxImageSize {
+		return reader, fmt.Errorf("%w: it is %vx%v pixels, but the maximum is %vx%v",
+			errImageTooBig, config.Width, config.Height, maxImageSize, maxImageSize)
+	}
+	return reader, nil
+}
+
+// Read all text QR codes (as produced by the qrt encoder with or without
+// inverted colors), and decode the CBE document they contain.
+func qrtSource(in io.Reader, receiver events.DataEventReceiver) error {
+	text, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	matrices, err := parseQRTexts(string(text))
+	if err != nil {
+		return err
+	}
+	if len(matrices) == 0 {
+		return fmt.Errorf("no text QR codes found")
+	}
+
+	var payloads [][]byte
+	for i, matrix := range matrices {
+		codePayloads, err := recognizeQRMatrix(matrix)
+		if err != nil {
+			return fmt.Errorf("text QR code %v: %v", i+1, err)
+		}
+		payloads = append(payloads, codePayloads...)
+	}
+
+	document, err := joinQRParts(payloads)
+	if err != nil {
+		return err
+	}
+	return cbeSource(bytes.NewReader(document), receiver)
+}
+
+func qrSink(out io.Writer, config *encoderConfig) (events.DataEventReceiver, func() error) {
+	return qrRenderSink(out, config, qrImageRenderers, "png")
+}
+
+func qrtSink(out io.Writer, config *encoderConfig) (events.DataEventReceiver, func() error) {
+	return qrRenderSink(out, config, qrTextRenderers, "text")
+}
+
+// Create a sink that encodes the document as QR codes and renders them using
+// the renderer for the configured QR style.
+func qrRenderSink(out io.Writer, config *encoderConfig, renderers map[string]qrRenderer, defaultStyle string) (events.DataEventReceiver, func() error) {
+	buff := &bytes.Buffer{}
+	receiver, _ := cbeSink(buff, config)
+	return receiver, func() error {
+		style := config.qrStyle
+		if style == "" {
+			style = defaultStyle
+		}
+		render := renderers[style]
+		if render == nil {
+			return fmt.Errorf("%v: QR style not supported by this format", style)
+		}
+
+		codes, err := newQRCodes(buff.Bytes(), config)
+		if err != nil {
+			return err
+		}
+		if err = writeQRPartFiles(codes, render, config); err != nil {
+			return err
+		}
+		return render(codes, out, config)
+	}
+}
+
+func newQRCodes(document []byte, config *encoderConfig) (codes []*qrcode.QRCode, err error) {
+	parts, err := splitQRParts(document, config)
+	if err != nil {
+		return
+	}
+	for i, part := range parts {
+		var q *qrcode.QRCode
+		if q, err = qrcode.New(part, qrcode.RecoveryLevel(config.errorCorrection)); err != nil {
+			return nil, fmt.Errorf("QR code %v of %v: %v", i+1, len(parts), err)
+		}
+		codes = append(codes, q)
+	}
+	return
+}
+
+// If a multi-part file pattern was given, also write each code to its own file.
+func writeQRPartFiles(codes []*qrcode.QRCode, render qrRenderer, config *encoderConfig) error {
+	if config.qrPartPath == "" || len(codes) < 2 {
+		return nil
+	}
+	for i, q := range codes {
+		path := strings.Replace(config.qrPartPath, "*", fmt.Sprintf("%v", i+1), -1)
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err = render([]*qrcode.QRCode{q}, f, config); err != nil {
+			f.Close()
+			return err
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitQRParts(document []byte, config *encoderConfig) (parts [][]byte, err error) {
+	capacity := qrCapacities[config.errorCorrection]
+	if config.qrPartSize > 0 && int(config.qrPartSize) < capacity {
+		capacity = int(config.qrPartSize)
+	}
+	if len(document) <= capacity {
+		return [][]byte{document}, nil
+	}
+
+	chunkSize := capacity - qrPartHeaderSize
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("QR codes must hold more than %v bytes to split a document", qrPartHeaderSize)
+	}
+	count := (len(document) + chunkSize - 1) / chunkSize
+	maxParts := maxQRParts
+	if config.qrMaxParts > 0 && int(config.qrMaxParts) < maxParts {
+		maxParts = int(config.qrMaxParts)
+	}
+	if count > maxParts {
+		return nil, fmt.Errorf("%w: it would need %v QR codes, but the maximum is %v", errTooManyQRParts, count, maxParts)
+	}
+
+	var checksum [4]byte
+	binary.BigEndian.PutUint32(checksum[:], crc32.ChecksumIEEE(document))
+	for i := 0; i < count; i++ {
+		chunk := document[i*chunkSize:]
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		part := make([]byte, 0, qrPartHeaderSize+len(chunk))
+		part = append(part, qrPartMagic...)
+		part = append(part, byte(i+1), byte(count))
+		part = append(part, checksum[:]...)
+		part = append(part, chunk...)
+		parts = append(parts, part)
+	}
+	return
+}
+
+func isQRPart(payload []byte) bool {
+	return len(payload) >= qrPartHeaderSize && bytes.HasPrefix(payload, qrPartMagic)
+}
+
+// Reassemble a document from QR code payloads, which can be in any order.
+func joinQRParts(payloads [][]byte) ([]byte, error) {
+	if len(payloads) == 0 {
+		return nil, fmt.Errorf("no QR codes found")
+	}
+	if !isQRPart(payloads[0]) {
+		if len(payloads) > 1 {
+			return nil, fmt.Errorf("found %v QR codes, but they aren't parts of a multi-part document", len(payloads))
+		}
+		return payloads[0], nil
+	}
+
+	count := int(payloads[0][3])
+	checksum := binary.BigEndian.Uint32(payloads[0][4:])
+	parts := make([][]byte, count)
+	for _, payload := range payloads {
+		if !isQRPart(payload) ||
+			int(payload[3]) != count ||
+			binary.BigEndian.Uint32(payload[4:]) != checksum {
+			return nil, fmt.Errorf("found QR codes that belong to different documents")
+		}
+		index := int(payload[2]) - 1
+		if index < 0 || index >= count {
+			return nil, fmt.Errorf("QR code has invalid part number %v of %v", index+1, count)
+		}
+		chunk := payload[qrPartHeaderSize:]
+		if parts[index] != nil && !bytes.Equal(parts[index], chunk) {
+			return nil, fmt.Errorf("found conflicting copies of QR code part %v", index+1)
+		}
+		parts[index] = chunk
+	}
+
+	var missing []string
+	for i, part := range parts {
+		if part == nil {
+			missing = append(missing, fmt.Sprintf("%v", i+1))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing QR code part(s) %v of %v", strings.Join(missing, ", "), count)
+	}
+
+	document := bytes.Join(parts, nil)
+	if crc32.ChecksumIEEE(document) != checksum {
+		return nil, fmt.Errorf("QR document checksum mismatch")
+	}
+	return document, nil
+}
+
+const (
+	qrtFullBlock = '█'
+	qrtUpperHalf = '▀'
+	qrtLowerHalf = '▄'
+)
+
+func isQRTChar(ch rune) bool {
+	switch ch {
+	case ' ', qrtFullBlock, qrtUpperHalf, qrtLowerHalf:
+		return true
+	}
+	return false
+}
+
+// Parse text containing one or more text QR codes separated by empty lines,
+// returning a module matrix for each, where true means a block character.
+func parseQRTexts(text string) (matrices [][][]bool, err error) {
+	var lines []string
+	endCode := func() error {
+		if len(lines) > 0 {
+			matrix, err := parseQRText(lines)
+			if err != nil {
+				return err
+			}
+			matrices = append(matrices, matrix)
+			lines = nil
+		}
+		return nil
+	}
+
+	for _, line := range strings.Split(strings.Replace(text, "\r", "", -1), "\n") {
+		if strings.TrimSpace(line) == "" {
+			if err = endCode(); err != nil {
+				return
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	err = endCode()
+	return
+}
+
+// Parse a single text QR code, which can use either two full block characters
+// per module or one half block character per two modules.
+func parseQRText(lines []string) ([][]bool, error) {
+	var rows [][]rune
+	isHalfBlocks := false
+	isDoubleWidth := true
+	for _, line := range lines {
+		row := []rune(line)
+		for i, ch := range row {
+			if !isQRTChar(ch) {
+				return nil, fmt.Errorf("unexpected character %q in text QR code", ch)
+			}
+			if ch == qrtUpperHalf || ch == qrtLowerHalf {
+				isHalfBlocks = true
+			}
+			if i&1 == 1 && row[i-1] != ch {
+				isDoubleWidth = false
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	var matrix [][]bool
+	if isHalfBlocks {
+		for _, row := range rows {
+			upper := make([]bool, len(row))
+			lower := make([]bool, len(row))
+			for x, ch := range row {
+				upper[x] = ch == qrtFullBlock || ch == qrtUpperHalf
+				lower[x] = ch == qrtFullBlock || ch == qrtLowerHalf
+			}
+			matrix = append(matrix, upper, lower)
+		}
+	} else {
+		step := 1
+		if isDoubleWidth {
+			step = 2
+		}
+		for _, row := range rows {
+			modules := make([]bool, 0, len(row)/step+1)
+			for x := 0; x < len(row); x += step {
+				modules = append(modules, row[x] == qrtFullBlock)
+			}
+			matrix = append(matrix, modules)
+		}
+	}
+
+	// Lines may have had trailing spaces trimmed.
+	width := 0
+	for _, row := range matrix {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	for y, row := range matrix {
+		if len(row) < width {
+			matrix[y] = append(row, make([]bool, width-len(row))...)
+		}
+	}
+	return matrix, nil
+}
+
+// Recognize the QR code in a module matrix. Block characters are light modules
+// in normal output and dark modules in inverted output, so both are tried.
+func recognizeQRMatrix(matrix [][]bool) (payloads [][]byte, err error) {
+	for _, isBlockDark := range []bool{false, true} {
+		qrCodes, recognizeErr := goqr.Recognize(qrMatrixImage(matrix, isBlockDark))
+		if recognizeErr != nil {
+			err = recognizeErr
+			continue
+		}
+		for _, qrCode := range qrCodes {
+			payloads = append(payloads, qrCode.Payload)
+		}
+		if len(payloads) > 0 {
+			return payloads, nil
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("no QR code found")
+	}
+	return nil, err
+}
+
+// Draw a module matrix as an image with a quiet zone around it.
+func qrMatrixImage(matrix [][]bool, isBlockDark bool) image.Image {
+	const scale = 4
+	const quietZone = 4
+
+	width := 0
+	if len(matrix) > 0 {
+		width = len(matrix[0])
+	}
+	img := image.NewGray(image.Rect(0, 0, (width+quietZone*2)*scale, (len(matrix)+quietZone*2)*scale))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	for y, row := range matrix {
+		for x, isBlock := range row {
+			if isBlock == isBlockDark {
+				left := (x + quietZone) * scale
+				top := (y + quietZone) * scale
+				draw.Draw(img, image.Rect(left, top, left+scale, top+scale), image.Black, image.Point{}, draw.Src)
+			}
+		}
+	}
+	return img
+}
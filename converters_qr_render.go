@@ -0,0 +1,235 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+
+	qrcode "github.com/kstenerud/go-qrcode"
+)
+
+// A qrRenderer draws one or more QR codes. Multiple codes are drawn together so
+// that they can be scanned at once.
+type qrRenderer func(codes []*qrcode.QRCode, out io.Writer, config *encoderConfig) error
+
+var qrImageRenderers = map[string]qrRenderer{
+	"png": renderQRPNG,
+	"svg": renderQRSVG,
+	"eps": renderQREPS,
+}
+
+var qrTextRenderers = map[string]qrRenderer{
+	"text": renderQRText,
+	"ansi": renderQRANSI,
+}
+
+func isKnownQRStyle(style string) bool {
+	return qrImageRenderers[style] != nil || qrTextRenderers[style] != nil
+}
+
+func renderQRPNG(codes []*qrcode.QRCode, out io.Writer, config *encoderConfig) error {
+	var images []image.Image
+	for _, q := range codes {
+		q.BorderSize = int(config.borderSize)
+		images = append(images, q.Image(int(config.imageSize)))
+	}
+	if len(images) == 1 {
+		return png.Encode(out, images[0])
+	}
+	return png.Encode(out, makeContactSheet(images))
+}
+
+// Arrange images into a grid.
+func makeContactSheet(images []image.Image) image.Image {
+	cellSize := 0
+	for _, img := range images {
+		if img.Bounds().Dx() > cellSize {
+			cellSize = img.Bounds().Dx()
+		}
+		if img.Bounds().Dy() > cellSize {
+			cellSize = img.Bounds().Dy()
+		}
+	}
+
+	columns, rows := getGridSize(len(images))
+	sheet := image.NewGray(image.Rect(0, 0, columns*cellSize, rows*cellSize))
+	draw.Draw(sheet, sheet.Bounds(), image.White, image.Point{}, draw.Src)
+	for i, img := range images {
+		x := (i % columns) * cellSize
+		y := (i / columns) * cellSize
+		draw.Draw(sheet, image.Rect(x, y, x+cellSize, y+cellSize), img, img.Bounds().Min, draw.Src)
+	}
+	return sheet
+}
+
+func getGridSize(count int) (columns int, rows int) {
+	columns = int(math.Ceil(math.Sqrt(float64(count))))
+	rows = (count + columns - 1) / columns
+	return
+}
+
+func renderQRText(codes []*qrcode.QRCode, out io.Writer, config *encoderConfig) error {
+	var arts []string
+	for _, q := range codes {
+		if config.invertText {
+			q.BorderSize = 0
+		}
+		arts = append(arts, q.ToString(config.invertText))
+	}
+
+	// Multiple codes are separated by an empty line.
+	_, err := out.Write([]byte(strings.Join(arts, "\n")))
+	return err
+}
+
+const (
+	ansiBlack = "\x1b[40m  "
+	ansiWhite = "\x1b[47m  "
+	ansiReset = "\x1b[0m"
+)
+
+// Draw codes using ANSI background colors, so that they scan regardless of the
+// terminal's own colors.
+func renderQRANSI(codes []*qrcode.QRCode, out io.Writer, config *encoderConfig) error {
+	dark, light := ansiBlack, ansiWhite
+	if config.invertText {
+		dark, light = light, dark
+	}
+
+	var b strings.Builder
+	for i, bitmap := range getQRBitmaps(codes, config) {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		for _, row := range bitmap {
+			for _, isDark := range row {
+				if isDark {
+					b.WriteString(dark)
+				} else {
+					b.WriteString(light)
+				}
+			}
+			b.WriteString(ansiReset)
+			b.WriteString("\n")
+		}
+	}
+	_, err := out.Write([]byte(b.String()))
+	return err
+}
+
+func renderQRSVG(codes []*qrcode.QRCode, out io.Writer, config *encoderConfig) error {
+	bitmaps := getQRBitmaps(codes, config)
+	cellSize := getMaxBitmapSize(bitmaps)
+	columns, rows := getGridSize(len(bitmaps))
+	dark, light := "#000", "#fff"
+	if config.invertText {
+		dark, light = light, dark
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%v" height="%v" viewBox="0 0 %v %v" shape-rendering="crispEdges">`+"\n",
+		columns*int(config.imageSize), rows*int(config.imageSize), columns*cellSize, rows*cellSize)
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="%v"/>`+"\n", light)
+	fmt.Fprintf(&b, `<path fill="%v" d="`, dark)
+	for i, bitmap := range bitmaps {
+		left := (i % columns) * cellSize
+		top := (i / columns) * cellSize
+		forEachDarkRun(bitmap, func(x, y, length int) {
+			fmt.Fprintf(&b, "M%v %vh%vv1h-%vz", left+x, top+y, length, length)
+		})
+	}
+	b.WriteString(`"/>` + "\n</svg>\n")
+	_, err := out.Write([]byte(b.String()))
+	return err
+}
+
+func renderQREPS(codes []*qrcode.QRCode, out io.Writer, config *encoderConfig) error {
+	bitmaps := getQRBitmaps(codes, config)
+	cellSize := getMaxBitmapSize(bitmaps)
+	columns, rows := getGridSize(len(bitmaps))
+	width := columns * int(config.imageSize)
+	height := rows * int(config.imageSize)
+	dark, light := "0", "1"
+	if config.invertText {
+		dark, light = light, dark
+	}
+
+	var b strings.Builder
+	b.WriteString("%!PS-Adobe-3.0 EPSF-3.0\n")
+	fmt.Fprintf(&b, "%%%%BoundingBox: 0 0 %v %v\n", width, height)
+	b.WriteString("%%EndComments\n")
+	fmt.Fprintf(&b, "%v setgray 0 0 %v %v rectfill\n", light, width, height)
+	// Draw in module units, with the origin at the top left.
+	fmt.Fprintf(&b, "0 %v translate %v %v scale\n", height,
+		float64(config.imageSize)/float64(cellSize), -float64(config.imageSize)/float64(cellSize))
+	fmt.Fprintf(&b, "%v setgray\n", dark)
+	for i, bitmap := range bitmaps {
+		left := (i % columns) * cellSize
+		top := (i / columns) * cellSize
+		forEachDarkRun(bitmap, func(x, y, length int) {
+			fmt.Fprintf(&b, "%v %v %v 1 rectfill\n", left+x, top+y, length)
+		})
+	}
+	b.WriteString("showpage\n%%EOF\n")
+	_, err := out.Write([]byte(b.String()))
+	return err
+}
+
+func getQRBitmaps(codes []*qrcode.QRCode, config *encoderConfig) (bitmaps [][][]bool) {
+	for _, q := range codes {
+		q.BorderSize = int(config.borderSize)
+		bitmaps = append(bitmaps, q.Bitmap())
+	}
+	return
+}
+
+func getMaxBitmapSize(bitmaps [][][]bool) (size int) {
+	for _, bitmap := range bitmaps {
+		if len(bitmap) > size {
+			size = len(bitmap)
+		}
+	}
+	return
+}
+
+// Call f for each horizontal run of dark modules in a bitmap.
+func forEachDarkRun(bitmap [][]bool, f func(x, y, length int)) {
+	for y, row := range bitmap {
+		for x := 0; x < len(row); {
+			if !row[x] {
+				x++
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			f(start, y, x-start)
+		}
+	}
+}
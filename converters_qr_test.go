@@ -0,0 +1,165 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+func TestQRPartsRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	tests := []struct {
+		size     int
+		partSize uint
+	}{
+		{0, 0},
+		{100, 0},
+		{2953, 0},
+		{2954, 0},
+		{10000, 0},
+		{1000, 100},
+		{200, 9},
+	}
+
+	for _, test := range tests {
+		document := make([]byte, test.size)
+		random.Read(document)
+		config := &encoderConfig{qrPartSize: test.partSize}
+
+		parts, err := splitQRParts(document, config)
+		if err != nil {
+			t.Errorf("size %v, part size %v: %v", test.size, test.partSize, err)
+			continue
+		}
+		for _, part := range parts {
+			if test.partSize > 0 && len(parts) > 1 && len(part) > int(test.partSize) {
+				t.Errorf("size %v, part size %v: part is %v bytes", test.size, test.partSize, len(part))
+			}
+		}
+
+		// Parts can be scanned in any order, and more than once.
+		random.Shuffle(len(parts), func(i, j int) { parts[i], parts[j] = parts[j], parts[i] })
+		if len(parts) > 1 {
+			parts = append(parts, parts[0])
+		}
+
+		joined, err := joinQRParts(parts)
+		if err != nil {
+			t.Errorf("size %v, part size %v: %v", test.size, test.partSize, err)
+		} else if !bytes.Equal(joined, document) {
+			t.Errorf("size %v, part size %v: document changed after round trip", test.size, test.partSize)
+		}
+	}
+}
+
+func TestQRPartsErrors(t *testing.T) {
+	document := bytes.Repeat([]byte("abcdefghij"), 100)
+	config := &encoderConfig{qrPartSize: 100}
+
+	if _, err := splitQRParts(document, &encoderConfig{qrPartSize: 8}); err == nil {
+		t.Error("expected an error for parts too small to hold data")
+	}
+	config.qrMaxParts = 5
+	if _, err := splitQRParts(document, config); !errors.Is(err, errTooManyQRParts) {
+		t.Errorf("expected errTooManyQRParts but got %v", err)
+	}
+	config.qrMaxParts = 0
+
+	parts, err := splitQRParts(document, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := joinQRParts(parts[1:]); err == nil {
+		t.Error("expected an error for a missing part")
+	}
+
+	corrupted := append([][]byte{}, parts...)
+	corrupted[1] = append([]byte{}, parts[1]...)
+	corrupted[1][qrPartHeaderSize] ^= 0xff
+	if _, err := joinQRParts(corrupted); err == nil {
+		t.Error("expected an error for a corrupted part")
+	}
+	if _, err := joinQRParts(append(corrupted, parts[1])); err == nil {
+		t.Error("expected an error for conflicting parts")
+	}
+
+	other, err := splitQRParts(bytes.Repeat([]byte("0123456789"), 100), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := joinQRParts(append(parts[1:], other[0])); err == nil {
+		t.Error("expected an error for parts from different documents")
+	}
+	if _, err := joinQRParts([][]byte{[]byte("a"), []byte("b")}); err == nil {
+		t.Error("expected an error for multiple single-part documents")
+	}
+}
+
+func encodeTestPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	buffer := &bytes.Buffer{}
+	if err := png.Encode(buffer, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestCheckImageSize(t *testing.T) {
+	// Consecutive images must still decode after their sizes are checked.
+	images := append(encodeTestPNG(t, 10, 20), encodeTestPNG(t, 30, 40)...)
+	reader := bufio.NewReader(bytes.NewReader(images))
+	for _, expected := range []image.Point{{10, 20}, {30, 40}} {
+		var err error
+		if reader, err = checkImageSize(reader, 40); err != nil {
+			t.Fatal(err)
+		}
+		img, _, err := image.Decode(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := img.Bounds().Size(); size != expected {
+			t.Errorf("expected a %v image but got %v", expected, size)
+		}
+	}
+
+	if _, err := checkImageSize(bufio.NewReader(bytes.NewReader(images)), 15); !errors.Is(err, errImageTooBig) {
+		t.Errorf("expected errImageTooBig but got %v", err)
+	}
+
+	// A tiny file can claim to be enormous.
+	bomb := encodeTestPNG(t, 1, 1)
+	ihdr := bomb[16:29]
+	binary.BigEndian.PutUint32(ihdr[0:], 50000)
+	binary.BigEndian.PutUint32(ihdr[4:], 50000)
+	binary.BigEndian.PutUint32(bomb[29:], crc32.ChecksumIEEE(bomb[12:29]))
+	err := limitedQRSource(4096)(bytes.NewReader(bomb), nil)
+	if !errors.Is(err, errImageTooBig) {
+		t.Errorf("expected errImageTooBig but got %v", err)
+	}
+}
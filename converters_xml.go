@@ -7,11 +7,7 @@ import (
 	"math/big"
 	"strings"
 
-	"github.com/kstenerud/go-concise-encoding/cbe"
 	"github.com/kstenerud/go-concise-encoding/ce/events"
-	"github.com/kstenerud/go-concise-encoding/configuration"
-	"github.com/kstenerud/go-concise-encoding/cte"
-	"github.com/kstenerud/go-concise-encoding/rules"
 	"github.com/kstenerud/go-concise-encoding/version"
 
 	"github.com/cockroachdb/apd/v2"
@@ -19,44 +15,14 @@ import (
 	compact_time "github.com/kstenerud/go-compact-time"
 )
 
-func XMLToCBE(in io.Reader, out io.Writer, config *encoderConfig) error {
-	opts := configuration.New()
-	encoder := cbe.NewEncoder(opts)
-	rules := rules.NewRules(encoder, opts)
-	encoder.PrepareToEncode(out)
-
-	return XMLToCE(in, rules)
-}
-
-func XMLToCTE(in io.Reader, out io.Writer, config *encoderConfig) error {
-	opts := configuration.New()
-	encoder := cte.NewEncoder(opts)
-	rules := rules.NewRules(encoder, opts)
-	encoder.PrepareToEncode(out)
-
-	return XMLToCE(in, rules)
-}
-
-func CBEToXML(in io.Reader, out io.Writer, config *encoderConfig) error {
-	eventReceiver := NewXMLEventReceiver(out, config.indentSpaces)
-	decoder := cbe.NewDecoder(nil)
-	return decoder.Decode(in, eventReceiver)
-}
-
-func CTEToXML(in io.Reader, out io.Writer, config *encoderConfig) error {
-	eventReceiver := NewXMLEventReceiver(out, config.indentSpaces)
-	decoder := cte.NewDecoder(nil)
-	return decoder.Decode(in, eventReceiver)
-}
-
-func XMLToCE(in io.Reader, encoder events.DataEventReceiver) error {
+func XMLToCE(in io.Reader, encoder events.DataEventReceiver) (err error) {
 	var token xml.Token
-	var err error
 
 	encoder.OnBeginDocument()
 	encoder.OnVersion(version.ConciseEncodingVersion)
 
-	decoder := xml.NewDecoder(in)
+	decoder := xml.NewDecoder(skipBOM(in))
+	defer annotateSourceError(decoder.InputOffset, &err)
 	for {
 		token, err = decoder.Token()
 		if err != nil {
@@ -370,7 +336,11 @@ func (_this *XMLEventReceiver) OnConstant(_ []byte) {
 	panic(fmt.Errorf("cannot convert constant to XML"))
 }
 
-func (_this *XMLEventReceiver) OnEndDocument() {}
+func (_this *XMLEventReceiver) OnEndDocument() {
+	if err := _this.encoder.Flush(); err != nil {
+		panic(err)
+	}
+}
 
 func generateIndentSpaces(count int) string {
 	var buff []byte
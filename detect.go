@@ -0,0 +1,335 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// The number of bytes to examine when detecting a document's format.
+const detectionPeekSize = 512
+
+const (
+	confidenceNone    = 0
+	confidenceLow     = 25
+	confidenceMedium  = 50
+	confidenceHigh    = 75
+	confidenceCertain = 100
+)
+
+// A formatDetector examines the beginning of a document and reports how
+// confident it is that the document is in its format, and the format version
+// if it can tell.
+type formatDetector func(header []byte) (confidence int, version string)
+
+type formatDetection struct {
+	format     string
+	confidence int
+	version    string
+}
+
+var knownDetectors = make(map[string]formatDetector)
+
+func init() {
+	knownDetectors["cbe"] = detectCBE
+	knownDetectors["cte"] = detectCTE
+	knownDetectors["json"] = detectJSON
+	knownDetectors["xml"] = detectXML
+	knownDetectors["qr"] = detectQR
+	knownDetectors["qrt"] = detectQRT
+}
+
+// Run all detectors over the beginning of the reader's data (without consuming
+// it), returning the formats that could match, most likely first.
+func detectFormats(reader *bufio.Reader) ([]formatDetection, error) {
+	header, err := reader.Peek(detectionPeekSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	if len(header) == 0 {
+		return nil, fmt.Errorf("document is empty")
+	}
+
+	var detections []formatDetection
+	for format, detect := range knownDetectors {
+		if confidence, version := detect(header); confidence > confidenceNone {
+			detections = append(detections, formatDetection{
+				format:     format,
+				confidence: confidence,
+				version:    version,
+			})
+		}
+	}
+	sort.Slice(detections, func(i, j int) bool {
+		if detections[i].confidence != detections[j].confidence {
+			return detections[i].confidence > detections[j].confidence
+		}
+		return detections[i].format < detections[j].format
+	})
+	return detections, nil
+}
+
+func detectSrcFormat(reader *bufio.Reader) (string, error) {
+	if layer := findLayer(reader); layer != nil {
+		return "", fmt.Errorf("%v compressed data is not supported (decompress it first)", layer.name)
+	}
+	detections, err := detectFormats(reader)
+	if err != nil {
+		return "", err
+	}
+	if len(detections) == 0 {
+		return "", fmt.Errorf("unrecognized format (use a format option to specify it)")
+	}
+	if len(detections) > 1 && detections[0].confidence == detections[1].confidence {
+		var candidates []string
+		for _, d := range detections {
+			if d.confidence == detections[0].confidence {
+				candidates = append(candidates, d.format)
+			}
+		}
+		return "", fmt.Errorf("ambiguous format: could be any of %v (use a format option to specify it)",
+			strings.Join(candidates, ", "))
+	}
+	return detections[0].format, nil
+}
+
+// A wrappingLayer is a compression or other encoding that a document can be
+// wrapped in.
+type wrappingLayer struct {
+	name      string
+	signature []byte
+	unwrap    func(io.Reader) (io.Reader, error)
+}
+
+var knownLayers = []wrappingLayer{
+	{
+		name:      "gzip",
+		signature: []byte{0x1f, 0x8b},
+		unwrap:    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	},
+	{
+		name:      "bzip2",
+		signature: []byte("BZh"),
+		unwrap:    func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil },
+	},
+}
+
+// Strip away any wrapping layers, returning a reader for the unwrapped data and
+// the names of the layers from outermost to innermost.
+func unwrapLayers(reader *bufio.Reader) (*bufio.Reader, []string, error) {
+	var layers []string
+	for {
+		layer := findLayer(reader)
+		if layer == nil {
+			return reader, layers, nil
+		}
+		unwrapped, err := layer.unwrap(reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error unwrapping %v: %v", layer.name, err)
+		}
+		reader = bufio.NewReader(unwrapped)
+		layers = append(layers, layer.name)
+	}
+}
+
+func findLayer(reader *bufio.Reader) *wrappingLayer {
+	for i := range knownLayers {
+		layer := &knownLayers[i]
+		if header, _ := reader.Peek(len(layer.signature)); bytes.Equal(header, layer.signature) {
+			return layer
+		}
+	}
+	return nil
+}
+
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// Remove a UTF-8 byte order mark if present.
+func skipBOM(reader io.Reader) io.Reader {
+	bufReader := bufio.NewReader(reader)
+	if b, err := bufReader.Peek(len(utf8BOM)); err == nil && bytes.Equal(b, utf8BOM) {
+		bufReader.Discard(len(utf8BOM))
+	}
+	return bufReader
+}
+
+// Get the start of a text document, without any byte order mark or leading
+// whitespace.
+func trimTextHeader(header []byte) []byte {
+	return bytes.TrimLeft(bytes.TrimPrefix(header, utf8BOM), " \t\r\n")
+}
+
+func isDecimalDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+func detectCBE(header []byte) (confidence int, version string) {
+	if len(header) < 2 {
+		return
+	}
+	switch header[0] {
+	case 0x83:
+		confidence = confidenceHigh
+	case 0x81:
+		// Used by early prerelease documents
+		confidence = confidenceMedium
+	default:
+		return
+	}
+
+	// Versions are ULEB128 encoded, but only 0 and 1 are currently valid.
+	switch header[1] {
+	case 0, 1:
+		confidence += confidenceLow
+		version = fmt.Sprintf("%v", header[1])
+	default:
+		confidence = confidenceLow
+	}
+	return
+}
+
+func detectCTE(header []byte) (confidence int, version string) {
+	text := header
+	if trimmed := trimTextHeader(header); len(trimmed) != len(header) {
+		// The version header is supposed to come first.
+		text = trimmed
+		confidence = -confidenceLow
+	}
+	if len(text) < 2 || text[0] != 'c' || !isDecimalDigit(text[1]) {
+		return confidenceNone, ""
+	}
+
+	end := 1
+	for end < len(text) && isDecimalDigit(text[end]) {
+		end++
+	}
+	version = string(text[1:end])
+	if end == len(text) || bytes.IndexByte([]byte(" \t\r\n"), text[end]) >= 0 {
+		confidence += confidenceCertain
+	} else {
+		confidence += confidenceMedium
+	}
+	return
+}
+
+func detectJSON(header []byte) (confidence int, version string) {
+	text := trimTextHeader(header)
+	if len(text) == 0 {
+		return
+	}
+
+	switch text[0] {
+	case '{', '[':
+		confidence = confidenceHigh
+	case '"':
+		confidence = confidenceMedium
+	case '-', 't', 'f', 'n':
+		confidence = confidenceLow
+	default:
+		if !isDecimalDigit(text[0]) {
+			return
+		}
+		confidence = confidenceLow
+	}
+
+	// Check that what we can see is well-formed. A malformed document is
+	// still most likely broken JSON, so that it can be reported as such.
+	decoder := json.NewDecoder(bytes.NewReader(text))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return confidenceLow, ""
+		}
+	}
+	if confidence == confidenceHigh {
+		confidence = (confidenceHigh + confidenceCertain) / 2
+	}
+	return
+}
+
+func detectXML(header []byte) (confidence int, version string) {
+	text := trimTextHeader(header)
+	switch {
+	case bytes.HasPrefix(text, []byte("<?xml")):
+		confidence = confidenceCertain
+	case bytes.HasPrefix(text, []byte("<!DOCTYPE")), bytes.HasPrefix(text, []byte("<!--")):
+		confidence = confidenceHigh
+	case len(text) > 1 && text[0] == '<' && isXMLNameStart(text[1]):
+		confidence = confidenceHigh
+	}
+	return
+}
+
+func isXMLNameStart(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || b == '_' || b == ':' || b >= 0x80
+}
+
+var imageSignatures = [][]byte{
+	[]byte("\x89PNG\r\n\x1a\n"),
+	[]byte("\xff\xd8\xff"),
+	[]byte("GIF87a"),
+	[]byte("GIF89a"),
+}
+
+func detectQR(header []byte) (confidence int, version string) {
+	for _, signature := range imageSignatures {
+		if bytes.HasPrefix(header, signature) {
+			// It's an image, but we can't know if it has a QR code until we scan it.
+			return confidenceHigh, ""
+		}
+	}
+	return
+}
+
+func detectQRT(header []byte) (confidence int, version string) {
+	hasBlocks := false
+	for len(header) > 0 {
+		if !utf8.FullRune(header) {
+			// Cut off by the peek size
+			break
+		}
+		ch, size := utf8.DecodeRune(header)
+		header = header[size:]
+		switch {
+		case ch == qrtFullBlock || ch == qrtUpperHalf || ch == qrtLowerHalf:
+			hasBlocks = true
+		case ch == ' ' || ch == '\r' || ch == '\n':
+		default:
+			return
+		}
+	}
+	if hasBlocks {
+		confidence = confidenceHigh
+	}
+	return
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+)
+
+func gzipBytes(t *testing.T, text string) []byte {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write([]byte(text)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectReaderCompressed(t *testing.T) {
+	result := detectReader("doc.json.gz", bytes.NewReader(gzipBytes(t, `{"a": [1, 2]}`)))
+	if message, ok := result.Get("error"); ok {
+		t.Fatalf("unexpected error: %v", message)
+	}
+	if format, _ := result.Get("format"); format != "json" {
+		t.Errorf("expected format json but got %v", format)
+	}
+	if layers, _ := result.Get("layers"); len(layers.([]string)) != 1 || layers.([]string)[0] != "gzip" {
+		t.Errorf("expected layers [gzip] but got %v", layers)
+	}
+	if convertible, _ := result.Get("convertible"); convertible != false {
+		t.Errorf("expected a compressed document to be reported as not convertible")
+	}
+}
+
+func TestDetectSrcFormatCompressed(t *testing.T) {
+	_, err := detectSrcFormat(bufio.NewReader(bytes.NewReader(gzipBytes(t, `{"a": [1, 2]}`))))
+	if err == nil || !strings.Contains(err.Error(), "gzip") {
+		t.Errorf("expected an error about gzip compression but got %v", err)
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/cockroachdb/apd/v2"
+	compact_float "github.com/kstenerud/go-compact-float"
+	compact_time "github.com/kstenerud/go-compact-time"
+)
+
+type diffOperation string
+
+const (
+	diffAdded       diffOperation = "added"
+	diffRemoved     diffOperation = "removed"
+	diffChanged     diffOperation = "changed"
+	diffTypeChanged diffOperation = "type-changed"
+	diffMoved       diffOperation = "moved"
+)
+
+// A difference between two documents. Removals and moves (from) refer to
+// paths in the old document, while everything else refers to paths in the
+// new document.
+type diffChange struct {
+	op       diffOperation
+	path     string
+	from     string
+	oldValue interface{}
+	newValue interface{}
+}
+
+// Lists bigger than this (in elements of old x new) are compared element by
+// element rather than aligned.
+const maxDiffAlignmentCells = 4 * 1024 * 1024
+
+// Get the structural differences between two document trees.
+func diffDocuments(oldDoc, newDoc interface{}) (changes []diffChange) {
+	diffValues("", oldDoc, newDoc, &changes)
+	return
+}
+
+func childPath(path string, key interface{}) string {
+	return path + "/" + escapePathSegment(fmt.Sprintf("%v", key))
+}
+
+func diffValues(path string, oldValue, newValue interface{}, changes *[]diffChange) {
+	oldValue, newValue = unmarked(oldValue), unmarked(newValue)
+	if valuesEqual(oldValue, newValue) {
+		return
+	}
+	if typeCategory(oldValue) != typeCategory(newValue) {
+		*changes = append(*changes, diffChange{op: diffTypeChanged, path: path, oldValue: oldValue, newValue: newValue})
+		return
+	}
+
+	switch o := oldValue.(type) {
+	case *orderedMap:
+		diffMaps(path, o, newValue.(*orderedMap), changes)
+	case []interface{}:
+		diffLists(path, o, newValue.([]interface{}), 0, changes)
+	case *nodeValue:
+		n := newValue.(*nodeValue)
+		// As with validate and query paths, a node's value is at index 0, and
+		// its children follow.
+		diffValues(childPath(path, 0), o.value, n.value, changes)
+		diffLists(path, o.children, n.children, 1, changes)
+	case *edgeValue:
+		n := newValue.(*edgeValue)
+		diffValues(childPath(path, 0), o.source, n.source, changes)
+		diffValues(childPath(path, 1), o.description, n.description, changes)
+		diffValues(childPath(path, 2), o.destination, n.destination, changes)
+	default:
+		*changes = append(*changes, diffChange{op: diffChanged, path: path, oldValue: oldValue, newValue: newValue})
+	}
+}
+
+func diffMaps(path string, oldMap, newMap *orderedMap, changes *[]diffChange) {
+	for _, entry := range oldMap.entries {
+		if i := findMapKey(newMap, entry.key); i >= 0 {
+			diffValues(childPath(path, entry.key), entry.value, newMap.entries[i].value, changes)
+		} else {
+			*changes = append(*changes, diffChange{op: diffRemoved, path: childPath(path, entry.key), oldValue: entry.value})
+		}
+	}
+	for _, entry := range newMap.entries {
+		if findMapKey(oldMap, entry.key) < 0 {
+			*changes = append(*changes, diffChange{op: diffAdded, path: childPath(path, entry.key), newValue: entry.value})
+		}
+	}
+}
+
+// Find a key in a map, comparing keys by value rather than by Go type.
+func findMapKey(m *orderedMap, key interface{}) int {
+	for i, entry := range m.entries {
+		if valuesEqual(entry.key, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Compare two lists by aligning their equal elements (longest common
+// subsequence). Leftover elements that are equal to each other are reported as
+// moves, and the remaining leftovers are compared pairwise in order. Element
+// paths begin at firstIndex.
+func diffLists(path string, oldList, newList []interface{}, firstIndex int, changes *[]diffChange) {
+	var unmatchedOld, unmatchedNew []int
+	if len(oldList)*len(newList) > maxDiffAlignmentCells {
+		for i := range oldList {
+			if i >= len(newList) || !valuesEqual(oldList[i], newList[i]) {
+				unmatchedOld = append(unmatchedOld, i)
+			}
+		}
+		for i := range newList {
+			if i >= len(oldList) || !valuesEqual(oldList[i], newList[i]) {
+				unmatchedNew = append(unmatchedNew, i)
+			}
+		}
+	} else {
+		unmatchedOld, unmatchedNew = alignLists(oldList, newList)
+	}
+
+	var remainingOld []int
+	for _, o := range unmatchedOld {
+		moved := false
+		for j, n := range unmatchedNew {
+			if valuesEqual(oldList[o], newList[n]) {
+				*changes = append(*changes, diffChange{op: diffMoved, from: childPath(path, firstIndex+o), path: childPath(path, firstIndex+n)})
+				unmatchedNew = append(unmatchedNew[:j], unmatchedNew[j+1:]...)
+				moved = true
+				break
+			}
+		}
+		if !moved {
+			remainingOld = append(remainingOld, o)
+		}
+	}
+
+	for len(remainingOld) > 0 && len(unmatchedNew) > 0 {
+		o, n := remainingOld[0], unmatchedNew[0]
+		diffValues(childPath(path, firstIndex+n), oldList[o], newList[n], changes)
+		remainingOld, unmatchedNew = remainingOld[1:], unmatchedNew[1:]
+	}
+	for _, o := range remainingOld {
+		*changes = append(*changes, diffChange{op: diffRemoved, path: childPath(path, firstIndex+o), oldValue: oldList[o]})
+	}
+	for _, n := range unmatchedNew {
+		*changes = append(*changes, diffChange{op: diffAdded, path: childPath(path, firstIndex+n), newValue: newList[n]})
+	}
+}
+
+// Find the indices of the elements that aren't part of the longest common
+// subsequence of two lists.
+func alignLists(oldList, newList []interface{}) (unmatchedOld, unmatchedNew []int) {
+	lengths := make([][]int, len(oldList)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(newList)+1)
+	}
+	for i := len(oldList) - 1; i >= 0; i-- {
+		for j := len(newList) - 1; j >= 0; j-- {
+			switch {
+			case valuesEqual(oldList[i], newList[j]):
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			case lengths[i+1][j] >= lengths[i][j+1]:
+				lengths[i][j] = lengths[i+1][j]
+			default:
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(oldList) && j < len(newList) {
+		switch {
+		case valuesEqual(oldList[i], newList[j]):
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			unmatchedOld = append(unmatchedOld, i)
+			i++
+		default:
+			unmatchedNew = append(unmatchedNew, j)
+			j++
+		}
+	}
+	for ; i < len(oldList); i++ {
+		unmatchedOld = append(unmatchedOld, i)
+	}
+	for ; j < len(newList); j++ {
+		unmatchedNew = append(unmatchedNew, j)
+	}
+	return
+}
+
+func unmarked(value interface{}) interface{} {
+	if marked, ok := value.(*markedValue); ok {
+		return marked.value
+	}
+	return value
+}
+
+// Check if two document tree values are semantically equal. Numbers are
+// compared by value regardless of how they're represented, and map keys are
+// matched regardless of order.
+func valuesEqual(a, b interface{}) bool {
+	a, b = unmarked(a), unmarked(b)
+	switch va := a.(type) {
+	case *orderedMap:
+		vb, ok := b.(*orderedMap)
+		if !ok || va.Len() != vb.Len() {
+			return false
+		}
+		for _, entry := range va.entries {
+			i := findMapKey(vb, entry.key)
+			if i < 0 || !valuesEqual(entry.value, vb.entries[i].value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		vb, ok := b.([]interface{})
+		return ok && listsEqual(va, vb)
+	case []byte:
+		vb, ok := b.([]byte)
+		return ok && bytes.Equal(va, vb)
+	case *typedArray:
+		vb, ok := b.(*typedArray)
+		return ok && va.arrayType == vb.arrayType && va.elementCount == vb.elementCount && bytes.Equal(va.data, vb.data)
+	case *mediaValue:
+		vb, ok := b.(*mediaValue)
+		return ok && va.mediaType == vb.mediaType && bytes.Equal(va.data, vb.data)
+	case *customBinary:
+		vb, ok := b.(*customBinary)
+		return ok && va.customType == vb.customType && bytes.Equal(va.data, vb.data)
+	case *customText:
+		vb, ok := b.(*customText)
+		return ok && *va == *vb
+	case *nodeValue:
+		vb, ok := b.(*nodeValue)
+		return ok && valuesEqual(va.value, vb.value) && listsEqual(va.children, vb.children)
+	case *edgeValue:
+		vb, ok := b.(*edgeValue)
+		return ok && valuesEqual(va.source, vb.source) &&
+			valuesEqual(va.description, vb.description) &&
+			valuesEqual(va.destination, vb.destination)
+	case float64:
+		if math.IsNaN(va) {
+			vb, ok := b.(float64)
+			return ok && math.IsNaN(vb)
+		}
+	}
+
+	if result, ok := compareValues(a, b); ok {
+		return result == 0
+	}
+	return typeCategory(a) == typeCategory(b) && isComparable(a) && a == b
+}
+
+func listsEqual(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !valuesEqual(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isComparable(value interface{}) bool {
+	switch value.(type) {
+	case nil, bool, string, uidValue, resourceID, constantValue, localReference, remoteReference:
+		return true
+	default:
+		return false
+	}
+}
+
+// Get the name of a document tree value's type. All numeric types are
+// considered to be the same category.
+func typeCategory(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case int64, uint64, *big.Int, float64, *big.Float, compact_float.DFloat, *apd.Decimal:
+		return "number"
+	case string:
+		return "string"
+	case []byte:
+		return "bytes"
+	case uidValue:
+		return "uid"
+	case compact_time.Time:
+		return "time"
+	case resourceID:
+		return "resource-id"
+	case *typedArray:
+		return "typed-array"
+	case *mediaValue:
+		return "media"
+	case *customBinary, *customText:
+		return "custom"
+	case constantValue:
+		return "constant"
+	case localReference, remoteReference:
+		return "reference"
+	case *markedValue:
+		return typeCategory(value.(*markedValue).value)
+	case []interface{}:
+		return "list"
+	case *orderedMap:
+		return "map"
+	case *nodeValue:
+		return "node"
+	case *edgeValue:
+		return "edge"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	compact_float "github.com/kstenerud/go-compact-float"
+)
+
+// Describe changes compactly, for comparing against expected results.
+func describeDiffChanges(changes []diffChange) string {
+	var descriptions []string
+	for _, change := range changes {
+		if change.path == "" {
+			change.path = "/"
+		}
+		switch change.op {
+		case diffAdded:
+			descriptions = append(descriptions, fmt.Sprintf("+ %v %v", change.path, formatDiffValue(change.newValue)))
+		case diffRemoved:
+			descriptions = append(descriptions, fmt.Sprintf("- %v %v", change.path, formatDiffValue(change.oldValue)))
+		case diffMoved:
+			descriptions = append(descriptions, fmt.Sprintf("> %v %v", change.from, change.path))
+		case diffTypeChanged:
+			descriptions = append(descriptions, fmt.Sprintf("! %v %v %v", change.path,
+				typeCategory(change.oldValue), typeCategory(change.newValue)))
+		default:
+			descriptions = append(descriptions, fmt.Sprintf("~ %v %v %v", change.path,
+				formatDiffValue(change.oldValue), formatDiffValue(change.newValue)))
+		}
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+func TestDiffDocuments(t *testing.T) {
+	tests := []struct {
+		oldDoc   string
+		newDoc   string
+		expected string
+	}{
+		// Maps
+		{`{"a":1,"b":2}`, `{"b":2,"a":1}`, ``},
+		{`{"a":1}`, `{"a":2}`, `~ /a 1 2`},
+		{`{"a":1,"b":2}`, `{"a":1,"c":2}`, `- /b 2; + /c 2`},
+		{`{"a":{"b":1,"c":[1]}}`, `{"a":{"b":1,"c":[2]}}`, `~ /a/c/0 1 2`},
+		{`{"a/b":{"c~d":1}}`, `{"a/b":{"c~d":2}}`, `~ /a~1b/c~0d 1 2`},
+
+		// Types
+		{`1`, `"1"`, `! / number string`},
+		{`{"a":null}`, `{"a":false}`, `! /a null boolean`},
+		{`{"a":[]}`, `{"a":{}}`, `! /a list map`},
+
+		// Numbers are compared by value
+		{`1`, `1.0`, ``},
+		{`[100, 0.5, -0.25]`, `[1e2, 5e-1, -25e-2]`, ``},
+		{`1`, `2`, `~ / 1 2`},
+
+		// List alignment
+		{`[1,2,3]`, `[1,2,3]`, ``},
+		{`[1,2,3]`, `[1,3]`, `- /1 2`},
+		{`[1,2]`, `[0,1,2,3]`, `+ /0 0; + /3 3`},
+		{`[1,2,3]`, `[1,5,3]`, `~ /1 2 5`},
+		{`[1,2,3,4]`, `[9,2,3,8]`, `~ /0 1 9; ~ /3 4 8`},
+		{`[{"a":1},{"b":2}]`, `[{"a":1},{"b":3}]`, `~ /1/b 2 3`},
+		{`[1,2,3]`, `[]`, `- /0 1; - /1 2; - /2 3`},
+
+		// Moves
+		{`["a","b","c"]`, `["c","a","b"]`, `> /2 /0`},
+		{`["a","b","c","d"]`, `["b","c","d","a"]`, `> /0 /3`},
+		{`[{"x":1},"b"]`, `["b",{"x":1}]`, `> /0 /1`},
+	}
+
+	for _, test := range tests {
+		changes := diffDocuments(parseJSON(t, test.oldDoc), parseJSON(t, test.newDoc))
+		if actual := describeDiffChanges(changes); actual != test.expected {
+			t.Errorf("%v -> %v: expected [%v] but got [%v]", test.oldDoc, test.newDoc, test.expected, actual)
+		}
+	}
+}
+
+func TestDiffNumericEquality(t *testing.T) {
+	one := []interface{}{
+		int64(1),
+		uint64(1),
+		big.NewInt(1),
+		float64(1),
+		compact_float.DFloatValue(0, 1),
+		compact_float.DFloatValue(-2, 100),
+	}
+	for _, a := range one {
+		for _, b := range one {
+			if changes := diffDocuments(a, b); len(changes) > 0 {
+				t.Errorf("%T %v -> %T %v: expected no changes but got [%v]", a, a, b, b, describeDiffChanges(changes))
+			}
+		}
+	}
+	if changes := diffDocuments(int64(1), float64(1.5)); describeDiffChanges(changes) != "~ / 1 1.5" {
+		t.Errorf("expected 1 and 1.5 to differ, but got [%v]", describeDiffChanges(changes))
+	}
+}
+
+// A node's value is at index 0 and its children follow, so that changes to
+// the value and to the first child have different paths.
+func TestDiffNodesAndEdges(t *testing.T) {
+	node := func(value interface{}, children ...interface{}) *nodeValue {
+		return &nodeValue{value: value, children: children}
+	}
+	tests := []struct {
+		oldDoc   interface{}
+		newDoc   interface{}
+		expected string
+	}{
+		{node("a", "b"), node("a", "b"), ``},
+		{node("a", "b"), node("x", "b"), `~ /0 "a" "x"`},
+		{node("a", "b"), node("a", "x"), `~ /1 "b" "x"`},
+		{node("a", "b"), node("x", "y"), `~ /0 "a" "x"; ~ /1 "b" "y"`},
+		{node("a", "b", "c"), node("a", "c"), `- /1 "b"`},
+		{node("a", "b"), node("a", "b", "c"), `+ /2 "c"`},
+		{node("a", "b", "c"), node("a", "c", "b"), `> /1 /2`},
+		{node("a", node("b", "c")), node("a", node("b", "d")), `~ /1/1 "c" "d"`},
+		{
+			&edgeValue{source: "a", description: "b", destination: "c"},
+			&edgeValue{source: "x", description: "b", destination: "y"},
+			`~ /0 "a" "x"; ~ /2 "c" "y"`,
+		},
+	}
+
+	for i, test := range tests {
+		if actual := describeDiffChanges(diffDocuments(test.oldDoc, test.newDoc)); actual != test.expected {
+			t.Errorf("test %v: expected [%v] but got [%v]", i, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,627 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/kstenerud/go-concise-encoding/ce/events"
+	"github.com/kstenerud/go-concise-encoding/configuration"
+	"github.com/kstenerud/go-concise-encoding/rules"
+	"github.com/kstenerud/go-concise-encoding/version"
+
+	"github.com/cockroachdb/apd/v2"
+	compact_float "github.com/kstenerud/go-compact-float"
+	compact_time "github.com/kstenerud/go-compact-time"
+)
+
+type mapEntry struct {
+	key   interface{}
+	value interface{}
+}
+
+// orderedMap is a map that keeps its entries in the order they were added.
+type orderedMap struct {
+	entries []mapEntry
+}
+
+func newOrderedMap() *orderedMap {
+	return &orderedMap{}
+}
+
+func (_this *orderedMap) Len() int {
+	return len(_this.entries)
+}
+
+func (_this *orderedMap) indexOf(key interface{}) int {
+	for i, entry := range _this.entries {
+		if entry.key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (_this *orderedMap) Get(key interface{}) (value interface{}, ok bool) {
+	if i := _this.indexOf(key); i >= 0 {
+		return _this.entries[i].value, true
+	}
+	return nil, false
+}
+
+// Set a key's value, replacing any existing value in place.
+func (_this *orderedMap) Set(key interface{}, value interface{}) *orderedMap {
+	if i := _this.indexOf(key); i >= 0 {
+		_this.entries[i].value = value
+	} else {
+		_this.entries = append(_this.entries, mapEntry{key: key, value: value})
+	}
+	return _this
+}
+
+func (_this *orderedMap) Delete(key interface{}) bool {
+	i := _this.indexOf(key)
+	if i < 0 {
+		return false
+	}
+	_this.entries = append(_this.entries[:i], _this.entries[i+1:]...)
+	return true
+}
+
+// Read a document into a document tree, also returning its format. The format
+// is detected if not specified.
+func readDocument(path string, format string) (document interface{}, actualFormat string, err error) {
+	reader, err := openFileRead(path)
+	if err != nil {
+		return
+	}
+	if f, ok := reader.(*os.File); ok && f != os.Stdin {
+		defer f.Close()
+	}
+
+	if format == "" {
+		bufReader := bufio.NewReader(reader)
+		reader = bufReader
+		if format, err = detectSrcFormat(bufReader); err != nil {
+			return nil, "", fmt.Errorf("%v: %v", path, err)
+		}
+	}
+	actualFormat = strings.ToLower(format)
+	source := knownSources[actualFormat]
+	if source == nil {
+		return nil, "", fmt.Errorf("%v: Unknown source format", format)
+	}
+
+	if document, err = buildDocument(source, reader); err != nil {
+		return nil, "", fmt.Errorf("%v: %v", path, err)
+	}
+	return
+}
+
+// Build a document tree from the events of a source.
+func buildDocument(source eventSource, in io.Reader) (document interface{}, err error) {
+	builder := newDocumentBuilder()
+	if err = runSource(source, in, rules.NewRules(builder, configuration.New())); err != nil {
+		return
+	}
+	document = builder.Document()
+	return
+}
+
+// Create an event source that produces a document containing value.
+func valueSource(value interface{}) eventSource {
+	return func(_ io.Reader, receiver events.DataEventReceiver) error {
+		receiver.OnBeginDocument()
+		receiver.OnVersion(version.ConciseEncodingVersion)
+		emitValue(value, receiver)
+		receiver.OnEndDocument()
+		return nil
+	}
+}
+
+// Write value as a document in the specified format.
+func writeValue(value interface{}, format string, out io.Writer, config *encoderConfig) error {
+	sink := knownSinks[format]
+	if sink == nil {
+		return fmt.Errorf("%v: Unknown destination format", format)
+	}
+	return pipe(valueSource(value), nil, sink, out, config)
+}
+
+func emitValue(value interface{}, receiver events.DataEventReceiver) {
+	switch v := value.(type) {
+	case nil:
+		receiver.OnNull()
+	case bool:
+		receiver.OnBoolean(v)
+	case int:
+		emitInt(int64(v), receiver)
+	case int64:
+		emitInt(v, receiver)
+	case uint64:
+		receiver.OnPositiveInt(v)
+	case *big.Int:
+		receiver.OnBigInt(v)
+	case float64:
+		if math.IsNaN(v) {
+			receiver.OnNan(false)
+		} else {
+			receiver.OnFloat(v)
+		}
+	case *big.Float:
+		receiver.OnBigFloat(v)
+	case compact_float.DFloat:
+		receiver.OnDecimalFloat(v)
+	case *apd.Decimal:
+		receiver.OnBigDecimalFloat(v)
+	case string:
+		receiver.OnStringlikeArray(events.ArrayTypeString, v)
+	case []byte:
+		receiver.OnArray(events.ArrayTypeUint8, uint64(len(v)), v)
+	case uidValue:
+		receiver.OnUID(v[:])
+	case compact_time.Time:
+		receiver.OnTime(v)
+	case resourceID:
+		receiver.OnStringlikeArray(events.ArrayTypeResourceID, string(v))
+	case *typedArray:
+		receiver.OnArray(v.arrayType, v.elementCount, v.data)
+	case *mediaValue:
+		receiver.OnMedia(v.mediaType, v.data)
+	case *customBinary:
+		receiver.OnCustomBinary(v.customType, v.data)
+	case *customText:
+		receiver.OnCustomText(v.customType, v.data)
+	case constantValue:
+		receiver.OnConstant([]byte(v))
+	case localReference:
+		receiver.OnReferenceLocal([]byte(v))
+	case remoteReference:
+		receiver.OnReferenceRemote()
+		receiver.OnStringlikeArray(events.ArrayTypeResourceID, string(v))
+	case *markedValue:
+		receiver.OnMarker([]byte(v.id))
+		emitValue(v.value, receiver)
+	case []interface{}:
+		receiver.OnList()
+		for _, elem := range v {
+			emitValue(elem, receiver)
+		}
+		receiver.OnEndContainer()
+	case []string:
+		receiver.OnList()
+		for _, elem := range v {
+			receiver.OnStringlikeArray(events.ArrayTypeString, elem)
+		}
+		receiver.OnEndContainer()
+	case *orderedMap:
+		receiver.OnMap()
+		for _, entry := range v.entries {
+			emitValue(entry.key, receiver)
+			emitValue(entry.value, receiver)
+		}
+		receiver.OnEndContainer()
+	case *nodeValue:
+		receiver.OnNode()
+		emitValue(v.value, receiver)
+		for _, child := range v.children {
+			emitValue(child, receiver)
+		}
+		receiver.OnEndContainer()
+	case *edgeValue:
+		receiver.OnEdge()
+		emitValue(v.source, receiver)
+		emitValue(v.description, receiver)
+		emitValue(v.destination, receiver)
+		receiver.OnEndContainer()
+	default:
+		panic(fmt.Errorf("BUG: Cannot emit value of type %T", value))
+	}
+}
+
+func emitInt(v int64, receiver events.DataEventReceiver) {
+	if v < 0 {
+		receiver.OnNegativeInt(uint64(-v))
+	} else {
+		receiver.OnPositiveInt(uint64(v))
+	}
+}
+
+// Document tree values for the Concise Encoding types that have no natural Go
+// equivalent. Everything else is represented as nil, bool, int64, uint64,
+// *big.Int, float64, *big.Float, compact_float.DFloat, *apd.Decimal, string,
+// []byte, compact_time.Time, []interface{} (lists) and *orderedMap (maps and
+// records).
+type uidValue [16]byte
+type resourceID string
+type constantValue string
+type localReference string
+type remoteReference string
+
+type typedArray struct {
+	arrayType    events.ArrayType
+	elementCount uint64
+	data         []byte
+}
+
+// Get the elements of a typed array as document tree values.
+func (_this *typedArray) Elements() []interface{} {
+	elements := make([]interface{}, 0, _this.elementCount)
+	data := _this.data
+	for i := uint64(0); i < _this.elementCount; i++ {
+		var elem interface{}
+		switch _this.arrayType {
+		case events.ArrayTypeBit:
+			elem = data[i/8]&(1<<(i%8)) != 0
+		case events.ArrayTypeInt8:
+			elem = int64(int8(data[i]))
+		case events.ArrayTypeUint16:
+			elem = int64(binary.LittleEndian.Uint16(data[i*2:]))
+		case events.ArrayTypeInt16:
+			elem = int64(int16(binary.LittleEndian.Uint16(data[i*2:])))
+		case events.ArrayTypeUint32:
+			elem = int64(binary.LittleEndian.Uint32(data[i*4:]))
+		case events.ArrayTypeInt32:
+			elem = int64(int32(binary.LittleEndian.Uint32(data[i*4:])))
+		case events.ArrayTypeUint64:
+			elem = binary.LittleEndian.Uint64(data[i*8:])
+		case events.ArrayTypeInt64:
+			elem = int64(binary.LittleEndian.Uint64(data[i*8:]))
+		case events.ArrayTypeFloat16:
+			elem = float64(math.Float32frombits(uint32(binary.LittleEndian.Uint16(data[i*2:])) << 16))
+		case events.ArrayTypeFloat32:
+			elem = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:])))
+		case events.ArrayTypeFloat64:
+			elem = math.Float64frombits(binary.LittleEndian.Uint64(data[i*8:]))
+		case events.ArrayTypeUID:
+			var uid uidValue
+			copy(uid[:], data[i*16:])
+			elem = uid
+		default:
+			elem = int64(data[i])
+		}
+		elements = append(elements, elem)
+	}
+	return elements
+}
+
+type mediaValue struct {
+	mediaType string
+	data      []byte
+}
+
+type customBinary struct {
+	customType uint64
+	data       []byte
+}
+
+type customText struct {
+	customType uint64
+	data       string
+}
+
+type markedValue struct {
+	id    string
+	value interface{}
+}
+
+type nodeValue struct {
+	value    interface{}
+	children []interface{}
+}
+
+type edgeValue struct {
+	source      interface{}
+	description interface{}
+	destination interface{}
+}
+
+type builderFrameType int
+
+const (
+	builderFrameList builderFrameType = iota
+	builderFrameMap
+	builderFrameNode
+	builderFrameEdge
+	builderFrameRecordType
+	builderFrameRecord
+	builderFrameMarker
+	builderFrameRemoteReference
+)
+
+type builderFrame struct {
+	frameType builderFrameType
+	id        string
+	values    []interface{}
+}
+
+type builderArray struct {
+	arrayType        events.ArrayType
+	isMedia          bool
+	mediaType        string
+	isCustom         bool
+	customType       uint64
+	elementCount     uint64
+	bytesRemaining   uint64
+	moreChunksComing bool
+	data             []byte
+}
+
+// documentBuilder builds a document tree from events. Records become maps
+// using the keys from their record type. Comments and padding are dropped.
+type documentBuilder struct {
+	nullEventReceiver
+	frames      []*builderFrame
+	array       *builderArray
+	recordTypes map[string][]interface{}
+	document    interface{}
+}
+
+func newDocumentBuilder() *documentBuilder {
+	return &documentBuilder{recordTypes: make(map[string][]interface{})}
+}
+
+// Get the document that was built.
+func (_this *documentBuilder) Document() interface{} {
+	return _this.document
+}
+
+func (_this *documentBuilder) addValue(value interface{}) {
+	if len(_this.frames) == 0 {
+		_this.document = value
+		return
+	}
+	frame := _this.frames[len(_this.frames)-1]
+	switch frame.frameType {
+	case builderFrameMarker:
+		_this.frames = _this.frames[:len(_this.frames)-1]
+		_this.addValue(&markedValue{id: frame.id, value: value})
+	case builderFrameRemoteReference:
+		_this.frames = _this.frames[:len(_this.frames)-1]
+		_this.addValue(remoteReference(fmt.Sprintf("%v", value)))
+	default:
+		frame.values = append(frame.values, value)
+	}
+}
+
+func (_this *documentBuilder) beginContainer(frameType builderFrameType, id []byte) {
+	_this.frames = append(_this.frames, &builderFrame{frameType: frameType, id: string(id)})
+}
+
+func (_this *documentBuilder) OnNull()           { _this.addValue(nil) }
+func (_this *documentBuilder) OnBoolean(v bool)  { _this.addValue(v) }
+func (_this *documentBuilder) OnTrue()           { _this.addValue(true) }
+func (_this *documentBuilder) OnFalse()          { _this.addValue(false) }
+func (_this *documentBuilder) OnInt(v int64)     { _this.addValue(v) }
+func (_this *documentBuilder) OnFloat(v float64) { _this.addValue(v) }
+
+func (_this *documentBuilder) OnPositiveInt(v uint64) {
+	if v <= math.MaxInt64 {
+		_this.addValue(int64(v))
+	} else {
+		_this.addValue(v)
+	}
+}
+
+func (_this *documentBuilder) OnNegativeInt(v uint64) {
+	switch {
+	case v == 0:
+		_this.addValue(math.Copysign(0, -1))
+	case v <= 1<<63:
+		_this.addValue(int64(-v))
+	default:
+		_this.addValue(new(big.Int).Neg(new(big.Int).SetUint64(v)))
+	}
+}
+
+func (_this *documentBuilder) OnBigInt(v *big.Int) {
+	if v.IsInt64() {
+		_this.addValue(v.Int64())
+	} else {
+		_this.addValue(v)
+	}
+}
+
+func (_this *documentBuilder) OnBigFloat(v *big.Float)               { _this.addValue(v) }
+func (_this *documentBuilder) OnDecimalFloat(v compact_float.DFloat) { _this.addValue(v) }
+func (_this *documentBuilder) OnBigDecimalFloat(v *apd.Decimal)      { _this.addValue(v) }
+func (_this *documentBuilder) OnNan(signaling bool)                  { _this.addValue(math.NaN()) }
+func (_this *documentBuilder) OnTime(v compact_time.Time)            { _this.addValue(v) }
+
+func (_this *documentBuilder) OnUID(v []byte) {
+	var uid uidValue
+	copy(uid[:], v)
+	_this.addValue(uid)
+}
+
+func (_this *documentBuilder) OnArray(arrayType events.ArrayType, elementCount uint64, data []byte) {
+	_this.addValue(arrayValue(arrayType, elementCount, copyBytes(data)))
+}
+
+func (_this *documentBuilder) OnStringlikeArray(arrayType events.ArrayType, data string) {
+	_this.addValue(arrayValue(arrayType, uint64(len(data)), []byte(data)))
+}
+
+func arrayValue(arrayType events.ArrayType, elementCount uint64, data []byte) interface{} {
+	switch arrayType {
+	case events.ArrayTypeString:
+		return string(data)
+	case events.ArrayTypeResourceID:
+		return resourceID(data)
+	case events.ArrayTypeUint8:
+		return data
+	default:
+		return &typedArray{arrayType: arrayType, elementCount: elementCount, data: data}
+	}
+}
+
+func (_this *documentBuilder) OnMedia(mediaType string, data []byte) {
+	_this.addValue(&mediaValue{mediaType: mediaType, data: copyBytes(data)})
+}
+
+func (_this *documentBuilder) OnCustomBinary(customType uint64, data []byte) {
+	_this.addValue(&customBinary{customType: customType, data: copyBytes(data)})
+}
+
+func (_this *documentBuilder) OnCustomText(customType uint64, data string) {
+	_this.addValue(&customText{customType: customType, data: data})
+}
+
+func (_this *documentBuilder) OnArrayBegin(arrayType events.ArrayType) {
+	_this.array = &builderArray{arrayType: arrayType}
+}
+
+func (_this *documentBuilder) OnMediaBegin(mediaType string) {
+	_this.array = &builderArray{arrayType: events.ArrayTypeUint8, isMedia: true, mediaType: mediaType}
+}
+
+func (_this *documentBuilder) OnCustomBegin(arrayType events.ArrayType, customType uint64) {
+	_this.array = &builderArray{arrayType: arrayType, isCustom: true, customType: customType}
+}
+
+func (_this *documentBuilder) OnArrayChunk(length uint64, moreChunksFollow bool) {
+	_this.array.elementCount += length
+	_this.array.bytesRemaining = arrayByteCount(_this.array.arrayType, length)
+	_this.array.moreChunksComing = moreChunksFollow
+	if _this.array.bytesRemaining == 0 && !moreChunksFollow {
+		_this.endArray()
+	}
+}
+
+func (_this *documentBuilder) OnArrayData(data []byte) {
+	_this.array.data = append(_this.array.data, data...)
+	_this.array.bytesRemaining -= uint64(len(data))
+	if _this.array.bytesRemaining == 0 && !_this.array.moreChunksComing {
+		_this.endArray()
+	}
+}
+
+func (_this *documentBuilder) endArray() {
+	array := _this.array
+	_this.array = nil
+	switch {
+	case array.isMedia:
+		_this.addValue(&mediaValue{mediaType: array.mediaType, data: array.data})
+	case array.isCustom && array.arrayType == events.ArrayTypeCustomText:
+		_this.addValue(&customText{customType: array.customType, data: string(array.data)})
+	case array.isCustom:
+		_this.addValue(&customBinary{customType: array.customType, data: array.data})
+	default:
+		_this.addValue(arrayValue(array.arrayType, array.elementCount, array.data))
+	}
+}
+
+func (_this *documentBuilder) OnList() {
+	_this.beginContainer(builderFrameList, nil)
+}
+
+func (_this *documentBuilder) OnMap() {
+	_this.beginContainer(builderFrameMap, nil)
+}
+
+func (_this *documentBuilder) OnNode() {
+	_this.beginContainer(builderFrameNode, nil)
+}
+
+func (_this *documentBuilder) OnEdge() {
+	_this.beginContainer(builderFrameEdge, nil)
+}
+
+func (_this *documentBuilder) OnRecordType(id []byte) {
+	_this.beginContainer(builderFrameRecordType, id)
+}
+
+func (_this *documentBuilder) OnRecord(id []byte) {
+	_this.beginContainer(builderFrameRecord, id)
+}
+
+func (_this *documentBuilder) OnMarker(id []byte) {
+	_this.beginContainer(builderFrameMarker, id)
+}
+
+func (_this *documentBuilder) OnReferenceRemote() {
+	_this.beginContainer(builderFrameRemoteReference, nil)
+}
+
+func (_this *documentBuilder) OnReferenceLocal(id []byte) {
+	_this.addValue(localReference(id))
+}
+
+func (_this *documentBuilder) OnConstant(name []byte) {
+	_this.addValue(constantValue(name))
+}
+
+func (_this *documentBuilder) OnEndContainer() {
+	frame := _this.frames[len(_this.frames)-1]
+	_this.frames = _this.frames[:len(_this.frames)-1]
+
+	switch frame.frameType {
+	case builderFrameList:
+		if frame.values == nil {
+			frame.values = []interface{}{}
+		}
+		_this.addValue(frame.values)
+	case builderFrameMap:
+		// The rules have already rejected duplicate keys, so entries can be
+		// appended directly rather than through Set (which is a linear search).
+		m := &orderedMap{entries: make([]mapEntry, 0, len(frame.values)/2)}
+		for i := 0; i+1 < len(frame.values); i += 2 {
+			m.entries = append(m.entries, mapEntry{key: frame.values[i], value: frame.values[i+1]})
+		}
+		_this.addValue(m)
+	case builderFrameNode:
+		node := &nodeValue{}
+		if len(frame.values) > 0 {
+			node.value = frame.values[0]
+			node.children = frame.values[1:]
+		}
+		_this.addValue(node)
+	case builderFrameEdge:
+		edge := &edgeValue{}
+		if len(frame.values) == 3 {
+			edge.source, edge.description, edge.destination = frame.values[0], frame.values[1], frame.values[2]
+		}
+		_this.addValue(edge)
+	case builderFrameRecordType:
+		_this.recordTypes[frame.id] = frame.values
+	case builderFrameRecord:
+		keys, ok := _this.recordTypes[frame.id]
+		if !ok {
+			panic(fmt.Errorf("%v: record type not defined", frame.id))
+		}
+		m := &orderedMap{entries: make([]mapEntry, 0, len(frame.values))}
+		for i, value := range frame.values {
+			if i < len(keys) {
+				m.entries = append(m.entries, mapEntry{key: keys[i], value: value})
+			}
+		}
+		_this.addValue(m)
+	default:
+		panic(fmt.Errorf("BUG: Unexpected end of container in builder frame type %v", frame.frameType))
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/kstenerud/go-concise-encoding/ce/events"
+
+	"github.com/cockroachdb/apd/v2"
+	compact_float "github.com/kstenerud/go-compact-float"
+	compact_time "github.com/kstenerud/go-compact-time"
+)
+
+var arrayTypeNames = map[events.ArrayType]string{
+	events.ArrayTypeString:          "string",
+	events.ArrayTypeResourceID:      "resource-id",
+	events.ArrayTypeReferenceRemote: "remote-reference",
+	events.ArrayTypeCustomText:      "custom-text",
+	events.ArrayTypeCustomBinary:    "custom-binary",
+	events.ArrayTypeMedia:           "media",
+	events.ArrayTypeBit:             "bit-array",
+	events.ArrayTypeUint8:           "bytes",
+	events.ArrayTypeUint16:          "u16-array",
+	events.ArrayTypeUint32:          "u32-array",
+	events.ArrayTypeUint64:          "u64-array",
+	events.ArrayTypeInt8:            "i8-array",
+	events.ArrayTypeInt16:           "i16-array",
+	events.ArrayTypeInt32:           "i32-array",
+	events.ArrayTypeInt64:           "i64-array",
+	events.ArrayTypeFloat16:         "f16-array",
+	events.ArrayTypeFloat32:         "f32-array",
+	events.ArrayTypeFloat64:         "f64-array",
+	events.ArrayTypeUID:             "uid-array",
+}
+
+func arrayTypeName(arrayType events.ArrayType) string {
+	if name, ok := arrayTypeNames[arrayType]; ok {
+		return name
+	}
+	return fmt.Sprintf("array-type-%d", arrayType)
+}
+
+func isStringlikeArrayType(arrayType events.ArrayType) bool {
+	switch arrayType {
+	case events.ArrayTypeString, events.ArrayTypeResourceID,
+		events.ArrayTypeReferenceRemote, events.ArrayTypeCustomText:
+		return true
+	}
+	return false
+}
+
+type spanRole int
+
+const (
+	spanOther spanRole = iota
+	spanHeader
+	spanValue
+	spanTextValue // A complete string-like value
+	spanContainer
+	spanEnd
+	spanArray // The beginning of a chunked array
+	spanChunk
+	spanData
+	spanText // Data of a chunked string-like array
+)
+
+func (_this spanRole) IsTyped() bool {
+	switch _this {
+	case spanValue, spanTextValue, spanContainer, spanEnd, spanArray:
+		return true
+	}
+	return false
+}
+
+// The range of bytes that a decoder consumed to produce an event. For array
+// values and data, payload holds the decoded array contents.
+type dumpSpan struct {
+	role    spanRole
+	offset  int64
+	length  int
+	depth   int
+	payload string
+}
+
+// cbeDumper prints the bytes that a CBE decoder consumes for each event it
+// produces. Each line shows the offset, the bytes in hex, the type code (the
+// first byte of a value), and the decoded value indented by nesting level.
+// The decoder must read from the dumper's reader.
+//
+// If recordSpans is set, the range of bytes consumed for each event is also
+// recorded in Spans. This works with any format's decoder.
+type cbeDumper struct {
+	reader       *byteCountingReader
+	out          io.Writer
+	indent       string
+	bytesPerLine int
+	containers   []string
+	arrayType    events.ArrayType
+	recordSpans  bool
+	Spans        []dumpSpan
+	spanPayload  string
+	bytesLeft    uint64
+	moreChunks   bool
+}
+
+func newCBEDumper(reader *byteCountingReader, out io.Writer, indentSpaces int, bytesPerLine int) *cbeDumper {
+	reader.capture = true
+	if bytesPerLine < 1 {
+		bytesPerLine = 1
+	}
+	return &cbeDumper{
+		reader:       reader,
+		out:          out,
+		indent:       strings.Repeat(" ", indentSpaces),
+		bytesPerLine: bytesPerLine,
+	}
+}
+
+// Print the bytes consumed since the last line, recording them as a span if
+// spans are being recorded. The first byte of typed spans is shown as the type
+// code.
+func (_this *cbeDumper) line(role spanRole, format string, args ...interface{}) {
+	data, offset := _this.reader.TakeCaptured()
+	if _this.recordSpans {
+		_this.Spans = append(_this.Spans, dumpSpan{
+			role:    role,
+			offset:  offset,
+			length:  len(data),
+			depth:   len(_this.containers),
+			payload: _this.spanPayload,
+		})
+	}
+	_this.spanPayload = ""
+
+	description := strings.Repeat(_this.indent, len(_this.containers)) + fmt.Sprintf(format, args...)
+	hexWidth := _this.bytesPerLine*3 - 1
+	typeCode := "  "
+	if role.IsTyped() && len(data) > 0 {
+		typeCode = fmt.Sprintf("%02x", data[0])
+	}
+
+	if len(data) == 0 {
+		fmt.Fprintf(_this.out, "%8v  %-*v  %v  %v\n", "", hexWidth, "", typeCode, description)
+		return
+	}
+	for i := 0; i < len(data); i += _this.bytesPerLine {
+		end := i + _this.bytesPerLine
+		if end > len(data) {
+			end = len(data)
+		}
+		hexBytes := make([]string, 0, end-i)
+		for _, b := range data[i:end] {
+			hexBytes = append(hexBytes, fmt.Sprintf("%02x", b))
+		}
+		fmt.Fprintf(_this.out, "%08x  %-*v  %v  %v\n", offset+int64(i), hexWidth, strings.Join(hexBytes, " "), typeCode, description)
+		typeCode, description = "  ", ""
+	}
+}
+
+// Print any bytes that were consumed without producing an event, such as
+// those leading up to a decoding error.
+func (_this *cbeDumper) Flush(description string) {
+	if len(_this.reader.captured) > 0 {
+		_this.line(spanOther, "%v", description)
+	}
+}
+
+func (_this *cbeDumper) beginContainer(format string, args ...interface{}) {
+	name := fmt.Sprintf(format, args...)
+	_this.line(spanContainer, "%v", name)
+	_this.containers = append(_this.containers, strings.Fields(name)[0])
+}
+
+func (_this *cbeDumper) beginArray(arrayType events.ArrayType, format string, args ...interface{}) {
+	_this.line(spanArray, format, args...)
+	_this.arrayType = arrayType
+	_this.containers = append(_this.containers, arrayTypeName(arrayType))
+}
+
+func (_this *cbeDumper) OnBeginDocument()         {}
+func (_this *cbeDumper) OnVersion(version uint64) { _this.line(spanHeader, "version %v", version) }
+func (_this *cbeDumper) OnPadding()               { _this.line(spanValue, "padding") }
+func (_this *cbeDumper) OnComment(bool, []byte)   { _this.line(spanValue, "comment") }
+func (_this *cbeDumper) OnNull()                  { _this.line(spanValue, "null") }
+func (_this *cbeDumper) OnBoolean(v bool)         { _this.line(spanValue, "%v", v) }
+func (_this *cbeDumper) OnTrue()                  { _this.line(spanValue, "true") }
+func (_this *cbeDumper) OnFalse()                 { _this.line(spanValue, "false") }
+func (_this *cbeDumper) OnPositiveInt(v uint64)   { _this.line(spanValue, "integer %v", v) }
+func (_this *cbeDumper) OnNegativeInt(v uint64)   { _this.line(spanValue, "integer -%v", v) }
+func (_this *cbeDumper) OnInt(v int64)            { _this.line(spanValue, "integer %v", v) }
+func (_this *cbeDumper) OnBigInt(v *big.Int)      { _this.line(spanValue, "integer %v", v) }
+func (_this *cbeDumper) OnBigFloat(v *big.Float)  { _this.line(spanValue, "float %v", v.Text('g', -1)) }
+func (_this *cbeDumper) OnBigDecimalFloat(v *apd.Decimal) {
+	_this.line(spanValue, "decimal float %v", v)
+}
+func (_this *cbeDumper) OnUID(v []byte)             { _this.line(spanValue, "uid %v", formatUID(v)) }
+func (_this *cbeDumper) OnTime(v compact_time.Time) { _this.line(spanValue, "time %v", v.String()) }
+func (_this *cbeDumper) OnList()                    { _this.beginContainer("list") }
+func (_this *cbeDumper) OnMap()                     { _this.beginContainer("map") }
+func (_this *cbeDumper) OnNode()                    { _this.beginContainer("node") }
+func (_this *cbeDumper) OnEdge()                    { _this.beginContainer("edge") }
+func (_this *cbeDumper) OnRecordType(id []byte)     { _this.beginContainer("record-type %v", string(id)) }
+func (_this *cbeDumper) OnRecord(id []byte)         { _this.beginContainer("record %v", string(id)) }
+func (_this *cbeDumper) OnMarker(id []byte)         { _this.line(spanValue, "marker &%v", string(id)) }
+func (_this *cbeDumper) OnReferenceLocal(id []byte) {
+	_this.line(spanValue, "reference $%v", string(id))
+}
+func (_this *cbeDumper) OnReferenceRemote()     { _this.line(spanValue, "remote reference") }
+func (_this *cbeDumper) OnConstant(name []byte) { _this.line(spanValue, "constant #%v", string(name)) }
+func (_this *cbeDumper) OnEndDocument()         { _this.Flush("end of document") }
+
+func (_this *cbeDumper) OnFloat(v float64) {
+	_this.line(spanValue, "float %v", strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+func (_this *cbeDumper) OnDecimalFloat(v compact_float.DFloat) {
+	_this.line(spanValue, "decimal float %v", v.String())
+}
+
+func (_this *cbeDumper) OnNan(isSignaling bool) {
+	if isSignaling {
+		_this.line(spanValue, "signaling NaN")
+	} else {
+		_this.line(spanValue, "NaN")
+	}
+}
+
+func (_this *cbeDumper) OnStringlikeArray(arrayType events.ArrayType, v string) {
+	_this.spanPayload = v
+	_this.line(spanTextValue, "%v %q", arrayTypeName(arrayType), v)
+}
+
+func (_this *cbeDumper) OnArray(arrayType events.ArrayType, elementCount uint64, data []byte) {
+	_this.spanPayload = string(data)
+	if isStringlikeArrayType(arrayType) {
+		_this.line(spanTextValue, "%v %q", arrayTypeName(arrayType), string(data))
+	} else {
+		_this.line(spanValue, "%v (%v elements)", arrayTypeName(arrayType), elementCount)
+	}
+}
+
+func (_this *cbeDumper) OnMedia(mediaType string, data []byte) {
+	_this.spanPayload = string(data)
+	_this.line(spanValue, "media %v (%v bytes)", mediaType, len(data))
+}
+
+func (_this *cbeDumper) OnCustomBinary(customType uint64, data []byte) {
+	_this.spanPayload = string(data)
+	_this.line(spanValue, "custom-binary type %v (%v bytes)", customType, len(data))
+}
+
+func (_this *cbeDumper) OnCustomText(customType uint64, v string) {
+	_this.spanPayload = v
+	_this.line(spanTextValue, "custom-text type %v %q", customType, v)
+}
+
+func (_this *cbeDumper) OnArrayBegin(arrayType events.ArrayType) {
+	_this.beginArray(arrayType, "%v", arrayTypeName(arrayType))
+}
+
+func (_this *cbeDumper) OnMediaBegin(mediaType string) {
+	_this.beginArray(events.ArrayTypeUint8, "media %v", mediaType)
+}
+
+func (_this *cbeDumper) OnCustomBegin(arrayType events.ArrayType, customType uint64) {
+	_this.beginArray(arrayType, "%v type %v", arrayTypeName(arrayType), customType)
+}
+
+func (_this *cbeDumper) OnArrayChunk(length uint64, moreChunksFollow bool) {
+	if moreChunksFollow {
+		_this.line(spanChunk, "chunk: %v elements, more follow", length)
+	} else {
+		_this.line(spanChunk, "chunk: %v elements, last", length)
+	}
+	_this.bytesLeft = arrayByteCount(_this.arrayType, length)
+	_this.moreChunks = moreChunksFollow
+	if _this.bytesLeft == 0 && !moreChunksFollow {
+		_this.endArray()
+	}
+}
+
+func (_this *cbeDumper) OnArrayData(data []byte) {
+	_this.spanPayload = string(data)
+	if isStringlikeArrayType(_this.arrayType) {
+		_this.line(spanText, "%q", string(data))
+	} else {
+		_this.line(spanData, "data (%v bytes)", len(data))
+	}
+	_this.bytesLeft -= uint64(len(data))
+	if _this.bytesLeft == 0 && !_this.moreChunks {
+		_this.endArray()
+	}
+}
+
+func (_this *cbeDumper) endArray() {
+	_this.containers = _this.containers[:len(_this.containers)-1]
+}
+
+func (_this *cbeDumper) OnEndContainer() {
+	name := _this.containers[len(_this.containers)-1]
+	_this.containers = _this.containers[:len(_this.containers)-1]
+	_this.line(spanEnd, "end %v", name)
+}
@@ -0,0 +1,411 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kstenerud/go-concise-encoding/ce/events"
+	"github.com/kstenerud/go-concise-encoding/version"
+
+	"github.com/cockroachdb/apd/v2"
+	compact_time "github.com/kstenerud/go-compact-time"
+)
+
+// The types that the generator can produce, and their default weights.
+var generatorTypes = []string{
+	"null", "boolean", "integer", "bigint", "float", "decimal", "bigdecimal", "bigfloat",
+	"string", "resource-id", "uid", "time", "bytes", "typed-array", "media", "custom",
+	"list", "map", "record", "node", "edge", "reference", "remote-reference",
+}
+
+var generatorDefaultWeights = map[string]int{
+	"integer": 4, "string": 4, "map": 3, "list": 3, "boolean": 2, "float": 2, "decimal": 2,
+}
+
+var generatorContainerTypes = map[string]bool{
+	"list": true, "map": true, "record": true, "node": true, "edge": true,
+}
+
+var generatorStringRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 _-.éßøЖλ日本語😀")
+
+var generatorArrayTypes = []events.ArrayType{
+	events.ArrayTypeBit, events.ArrayTypeUint8, events.ArrayTypeUint16, events.ArrayTypeUint32,
+	events.ArrayTypeUint64, events.ArrayTypeInt8, events.ArrayTypeInt16, events.ArrayTypeInt32,
+	events.ArrayTypeInt64, events.ArrayTypeFloat32, events.ArrayTypeFloat64, events.ArrayTypeUID,
+}
+
+type weightedType struct {
+	name   string
+	weight int
+}
+
+// Parse a type mix such as "string=5,integer,map=2" (types without a weight
+// get a weight of 1). An empty mix selects all types with default weights.
+func parseTypeMix(mix string) (types []weightedType, err error) {
+	if strings.TrimSpace(mix) == "" {
+		for _, name := range generatorTypes {
+			weight := generatorDefaultWeights[name]
+			if weight == 0 {
+				weight = 1
+			}
+			types = append(types, weightedType{name, weight})
+		}
+		return
+	}
+
+	for _, entry := range strings.Split(mix, ",") {
+		name, weightText := strings.TrimSpace(entry), ""
+		if i := strings.Index(name, "="); i >= 0 {
+			name, weightText = name[:i], name[i+1:]
+		}
+		if !isGeneratorType(name) {
+			return nil, fmt.Errorf("%v: unknown type (must be one of %v)", name, strings.Join(generatorTypes, ", "))
+		}
+		weight := 1
+		if weightText != "" {
+			if weight, err = strconv.Atoi(weightText); err != nil || weight < 0 {
+				return nil, fmt.Errorf("%v: invalid weight for %v", weightText, name)
+			}
+		}
+		types = append(types, weightedType{name, weight})
+	}
+	return
+}
+
+func isGeneratorType(name string) bool {
+	for _, t := range generatorTypes {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// documentGenerator produces random but valid documents as events.
+type documentGenerator struct {
+	rng         *rand.Rand
+	maxDepth    int
+	maxBreadth  int
+	types       []weightedType
+	scalarTypes []weightedType
+
+	markerCount    int
+	definedMarkers []string
+	recordTypes    [][]string
+}
+
+func newDocumentGenerator(seed int64, maxDepth int, maxBreadth int, types []weightedType) *documentGenerator {
+	generator := &documentGenerator{
+		rng:        rand.New(rand.NewSource(seed)),
+		maxDepth:   maxDepth,
+		maxBreadth: maxBreadth,
+		types:      types,
+	}
+	for _, t := range types {
+		if !generatorContainerTypes[t.name] {
+			generator.scalarTypes = append(generator.scalarTypes, t)
+		}
+	}
+	return generator
+}
+
+// Get a source that produces the next random document.
+func (_this *documentGenerator) Source() eventSource {
+	return func(_ io.Reader, receiver events.DataEventReceiver) error {
+		_this.markerCount = 0
+		_this.definedMarkers = nil
+		_this.recordTypes = nil
+
+		receiver.OnBeginDocument()
+		receiver.OnVersion(version.ConciseEncodingVersion)
+		if _this.hasType("record") {
+			_this.generateRecordTypes(receiver)
+		}
+		_this.generateValue(receiver, _this.chooseType(_this.types), 0)
+		receiver.OnEndDocument()
+		return nil
+	}
+}
+
+func (_this *documentGenerator) hasType(name string) bool {
+	for _, t := range _this.types {
+		if t.name == name && t.weight > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (_this *documentGenerator) chooseType(types []weightedType) string {
+	total := 0
+	for _, t := range types {
+		total += t.weight
+	}
+	if total == 0 {
+		return "null"
+	}
+	choice := _this.rng.Intn(total)
+	for _, t := range types {
+		if choice < t.weight {
+			return t.name
+		}
+		choice -= t.weight
+	}
+	return types[len(types)-1].name
+}
+
+// Choose the type of a child value: containers are only chosen above the
+// maximum depth.
+func (_this *documentGenerator) chooseChildType(depth int) string {
+	if depth < _this.maxDepth {
+		return _this.chooseType(_this.types)
+	}
+	return _this.chooseType(_this.scalarTypes)
+}
+
+func (_this *documentGenerator) childCount() int {
+	return _this.rng.Intn(_this.maxBreadth + 1)
+}
+
+func (_this *documentGenerator) generateRecordTypes(receiver events.DataEventReceiver) {
+	count := _this.rng.Intn(3) + 1
+	for i := 0; i < count; i++ {
+		keyCount := _this.rng.Intn(_this.maxBreadth) + 1
+		keys := make([]string, keyCount)
+		receiver.OnRecordType([]byte(fmt.Sprintf("rt%v", i)))
+		for j := range keys {
+			keys[j] = fmt.Sprintf("%v%v", _this.randomWord(), j)
+			receiver.OnStringlikeArray(events.ArrayTypeString, keys[j])
+		}
+		receiver.OnEndContainer()
+		_this.recordTypes = append(_this.recordTypes, keys)
+	}
+}
+
+func (_this *documentGenerator) generateValue(receiver events.DataEventReceiver, typeName string, depth int) {
+	// Occasionally mark a value so that it can be referenced later.
+	markerID := ""
+	if _this.hasType("reference") && typeName != "reference" && typeName != "remote-reference" && _this.rng.Intn(10) == 0 {
+		_this.markerCount++
+		markerID = fmt.Sprintf("m%v", _this.markerCount)
+		receiver.OnMarker([]byte(markerID))
+	}
+
+	switch typeName {
+	case "null":
+		receiver.OnNull()
+	case "boolean":
+		receiver.OnBoolean(_this.rng.Intn(2) == 1)
+	case "integer":
+		emitInt(_this.randomInt(), receiver)
+	case "bigint":
+		v := new(big.Int).Rand(_this.rng, new(big.Int).Lsh(big.NewInt(1), uint(65+_this.rng.Intn(128))))
+		v.SetBit(v, 64, 1)
+		if _this.rng.Intn(2) == 0 {
+			v.Neg(v)
+		}
+		receiver.OnBigInt(v)
+	case "float":
+		receiver.OnFloat(_this.randomFloat())
+	case "decimal":
+		receiver.OnBigDecimalFloat(apd.New(_this.rng.Int63n(2000000)-1000000, int32(_this.rng.Intn(10)-8)))
+	case "bigdecimal":
+		coefficient := new(big.Int).Rand(_this.rng, new(big.Int).Lsh(big.NewInt(1), 200))
+		receiver.OnBigDecimalFloat(apd.NewWithBigInt(coefficient, int32(_this.rng.Intn(200)-100)))
+	case "bigfloat":
+		// Add a tiny fraction so that the value needs more than a float64's precision
+		v := new(big.Float).SetPrec(200).SetFloat64(_this.randomFloat())
+		v.Add(v, new(big.Float).SetPrec(200).SetMantExp(big.NewFloat(1), -150))
+		receiver.OnBigFloat(v)
+	case "string":
+		receiver.OnStringlikeArray(events.ArrayTypeString, _this.randomString())
+	case "resource-id":
+		receiver.OnStringlikeArray(events.ArrayTypeResourceID, "https://example.com/"+_this.randomWord())
+	case "uid":
+		receiver.OnUID(_this.randomUID())
+	case "time":
+		seconds := _this.rng.Int63n(4102444800) // 1970-2100
+		receiver.OnTime(compact_time.AsCompactTime(time.Unix(seconds, int64(_this.rng.Intn(1000))*1000000).UTC()))
+	case "bytes":
+		data := _this.randomBytes(_this.rng.Intn(64))
+		receiver.OnArray(events.ArrayTypeUint8, uint64(len(data)), data)
+	case "typed-array":
+		_this.generateTypedArray(receiver)
+	case "media":
+		receiver.OnMedia("application/octet-stream", _this.randomBytes(_this.rng.Intn(64)))
+	case "custom":
+		if _this.rng.Intn(2) == 0 {
+			receiver.OnCustomBinary(uint64(_this.rng.Intn(100)), _this.randomBytes(_this.rng.Intn(32)))
+		} else {
+			receiver.OnCustomText(uint64(_this.rng.Intn(100)), _this.randomWord())
+		}
+	case "reference":
+		if len(_this.definedMarkers) == 0 {
+			receiver.OnNull()
+		} else {
+			receiver.OnReferenceLocal([]byte(_this.definedMarkers[_this.rng.Intn(len(_this.definedMarkers))]))
+		}
+	case "remote-reference":
+		receiver.OnReferenceRemote()
+		receiver.OnStringlikeArray(events.ArrayTypeResourceID, "https://example.com/"+_this.randomWord()+"#"+_this.randomWord())
+	case "list":
+		receiver.OnList()
+		for i := _this.childCount(); i > 0; i-- {
+			_this.generateValue(receiver, _this.chooseChildType(depth+1), depth+1)
+		}
+		receiver.OnEndContainer()
+	case "map":
+		receiver.OnMap()
+		usedKeys := make(map[string]bool)
+		for i := _this.childCount(); i > 0; i-- {
+			_this.generateKey(receiver, usedKeys)
+			_this.generateValue(receiver, _this.chooseChildType(depth+1), depth+1)
+		}
+		receiver.OnEndContainer()
+	case "record":
+		if len(_this.recordTypes) == 0 {
+			receiver.OnNull()
+			break
+		}
+		index := _this.rng.Intn(len(_this.recordTypes))
+		receiver.OnRecord([]byte(fmt.Sprintf("rt%v", index)))
+		for range _this.recordTypes[index] {
+			_this.generateValue(receiver, _this.chooseChildType(depth+1), depth+1)
+		}
+		receiver.OnEndContainer()
+	case "node":
+		receiver.OnNode()
+		_this.generateValue(receiver, _this.chooseType(_this.scalarTypes), depth+1)
+		for i := _this.childCount(); i > 0; i-- {
+			_this.generateValue(receiver, _this.chooseChildType(depth+1), depth+1)
+		}
+		receiver.OnEndContainer()
+	case "edge":
+		receiver.OnEdge()
+		receiver.OnStringlikeArray(events.ArrayTypeResourceID, "https://example.com/"+_this.randomWord())
+		_this.generateValue(receiver, _this.chooseType(_this.scalarTypes), depth+1)
+		receiver.OnStringlikeArray(events.ArrayTypeResourceID, "https://example.com/"+_this.randomWord())
+		receiver.OnEndContainer()
+	default:
+		panic(fmt.Errorf("BUG: Unhandled generator type %v", typeName))
+	}
+
+	if markerID != "" {
+		_this.definedMarkers = append(_this.definedMarkers, markerID)
+	}
+}
+
+func (_this *documentGenerator) generateKey(receiver events.DataEventReceiver, usedKeys map[string]bool) {
+	for {
+		var key string
+		isInt := _this.rng.Intn(5) == 0
+		if isInt {
+			key = strconv.Itoa(_this.rng.Intn(1000))
+		} else {
+			key = _this.randomWord()
+		}
+		if usedKeys[key] {
+			continue
+		}
+		usedKeys[key] = true
+		if isInt {
+			v, _ := strconv.ParseInt(key, 10, 64)
+			emitInt(v, receiver)
+		} else {
+			receiver.OnStringlikeArray(events.ArrayTypeString, key)
+		}
+		return
+	}
+}
+
+func (_this *documentGenerator) generateTypedArray(receiver events.DataEventReceiver) {
+	arrayType := generatorArrayTypes[_this.rng.Intn(len(generatorArrayTypes))]
+	count := uint64(_this.rng.Intn(16))
+	var data []byte
+	switch arrayType {
+	case events.ArrayTypeFloat32:
+		data = make([]byte, count*4)
+		for i := uint64(0); i < count; i++ {
+			binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(float32(_this.randomFloat())))
+		}
+	case events.ArrayTypeFloat64:
+		data = make([]byte, count*8)
+		for i := uint64(0); i < count; i++ {
+			binary.LittleEndian.PutUint64(data[i*8:], math.Float64bits(_this.randomFloat()))
+		}
+	default:
+		data = _this.randomBytes(int(arrayByteCount(arrayType, count)))
+	}
+	receiver.OnArray(arrayType, count, data)
+}
+
+// Get an integer with a random magnitude, so that all sizes are exercised.
+func (_this *documentGenerator) randomInt() int64 {
+	v := _this.rng.Int63() >> uint(_this.rng.Intn(63))
+	if _this.rng.Intn(2) == 0 {
+		v = -v
+	}
+	return v
+}
+
+func (_this *documentGenerator) randomFloat() float64 {
+	return (_this.rng.Float64()*2 - 1) * math.Pow(10, float64(_this.rng.Intn(20)-10))
+}
+
+func (_this *documentGenerator) randomBytes(length int) []byte {
+	data := make([]byte, length)
+	_this.rng.Read(data)
+	return data
+}
+
+func (_this *documentGenerator) randomUID() []byte {
+	uid := _this.randomBytes(16)
+	uid[6] = (uid[6] & 0x0f) | 0x40 // Version 4
+	uid[8] = (uid[8] & 0x3f) | 0x80 // Variant 1
+	return uid
+}
+
+func (_this *documentGenerator) randomWord() string {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	word := make([]byte, _this.rng.Intn(8)+1)
+	for i := range word {
+		word[i] = letters[_this.rng.Intn(len(letters))]
+	}
+	return string(word)
+}
+
+func (_this *documentGenerator) randomString() string {
+	length := _this.rng.Intn(32)
+	var builder strings.Builder
+	for i := 0; i < length; i++ {
+		builder.WriteRune(generatorStringRunes[_this.rng.Intn(len(generatorStringRunes))])
+	}
+	return builder.String()
+}
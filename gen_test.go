@@ -0,0 +1,74 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGeneratedDocumentsAreValid(t *testing.T) {
+	mixes := []string{
+		"",
+		"null,boolean,integer,bigint,float,decimal,bigdecimal,bigfloat,string,resource-id,uid,time," +
+			"bytes,typed-array,media,custom,list,map,record,node,edge,reference,remote-reference",
+		"map=3,list=3,record=5,node=3,edge=5,reference=5,string,integer",
+	}
+	sinks := []string{"cbe", "cte", "json"}
+
+	for _, mix := range mixes {
+		types, err := parseTypeMix(mix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, sinkName := range sinks {
+			for seed := int64(1); seed <= 200; seed++ {
+				config := newEncoderConfig()
+				buffer := &bytes.Buffer{}
+				generator := newDocumentGenerator(seed, 5, 6, types)
+				if err = pipe(generator.Source(), nil, knownSinks[sinkName], buffer, &config); err != nil {
+					t.Errorf("mix %q, seed %v, %v: %v", mix, seed, sinkName, err)
+				}
+			}
+		}
+	}
+}
+
+func TestGeneratedDocumentsAreReproducible(t *testing.T) {
+	types, err := parseTypeMix("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generate := func() []byte {
+		config := newEncoderConfig()
+		buffer := &bytes.Buffer{}
+		generator := newDocumentGenerator(42, 5, 6, types)
+		for i := 0; i < 3; i++ {
+			if err := pipe(generator.Source(), nil, jsonSink, buffer, &config); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return buffer.Bytes()
+	}
+	if first, second := generate(), generate(); !bytes.Equal(first, second) {
+		t.Errorf("the same seed produced different documents:\n%s\n%s", first, second)
+	}
+}
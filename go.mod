@@ -10,4 +10,5 @@ require (
 	github.com/kstenerud/go-describe v1.2.15
 	github.com/kstenerud/go-qrcode v0.0.0-20220106104308-4302c70a75ed
 	github.com/liyue201/goqr v0.0.0-20200803022322-df443203d4ea
+	golang.org/x/text v0.14.0
 )
@@ -0,0 +1,188 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode/utf8"
+)
+
+// A lineReader reads lines of input for interactive commands.
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+	Close()
+}
+
+// A completer returns the possible completions of the last word of line.
+type completer func(line string) (word string, candidates []string)
+
+// Create a line reader for stdin. If stdin is a terminal (and stty is
+// available), the reader supports tab completion; otherwise lines are read
+// as-is without prompting.
+func newStdinLineReader(complete completer) lineReader {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		if reader, err := newTerminalLineReader(complete); err == nil {
+			return reader
+		}
+	}
+	return &plainLineReader{scanner: bufio.NewScanner(os.Stdin)}
+}
+
+type plainLineReader struct {
+	scanner *bufio.Scanner
+}
+
+func (_this *plainLineReader) ReadLine(prompt string) (string, error) {
+	if !_this.scanner.Scan() {
+		if err := _this.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return _this.scanner.Text(), nil
+}
+
+func (_this *plainLineReader) Close() {}
+
+// terminalLineReader puts the terminal into non-canonical mode so that it can
+// handle tab completion and simple line editing (backspace, ^U, ^C and ^D).
+type terminalLineReader struct {
+	complete      completer
+	in            *bufio.Reader
+	savedSettings string
+}
+
+func runStty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	output, err := cmd.Output()
+	return strings.TrimSpace(string(output)), err
+}
+
+func newTerminalLineReader(complete completer) (reader *terminalLineReader, err error) {
+	savedSettings, err := runStty("-g")
+	if err != nil {
+		return
+	}
+	if _, err = runStty("-icanon", "-echo", "-isig", "min", "1"); err != nil {
+		return
+	}
+	reader = &terminalLineReader{
+		complete:      complete,
+		in:            bufio.NewReader(os.Stdin),
+		savedSettings: savedSettings,
+	}
+	return
+}
+
+// Restore the terminal's original settings.
+func (_this *terminalLineReader) Close() {
+	runStty(_this.savedSettings)
+}
+
+func (_this *terminalLineReader) ReadLine(prompt string) (string, error) {
+	fmt.Print(prompt)
+	line := []rune{}
+	for {
+		r, _, err := _this.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Println()
+			return string(line), nil
+		case 4: // ^D
+			if len(line) == 0 {
+				fmt.Println()
+				return "", io.EOF
+			}
+		case 3: // ^C
+			fmt.Println("^C")
+			return "", nil
+		case 21: // ^U
+			fmt.Print(strings.Repeat("\b \b", len(line)))
+			line = line[:0]
+		case 127, '\b':
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				fmt.Print("\b \b")
+			}
+		case '\t':
+			line = _this.completeLine(prompt, line)
+		case 27: // Escape sequences (arrow keys etc) aren't supported
+			_this.skipEscapeSequence()
+		default:
+			if r >= ' ' && r != utf8.RuneError {
+				line = append(line, r)
+				fmt.Print(string(r))
+			}
+		}
+	}
+}
+
+func (_this *terminalLineReader) skipEscapeSequence() {
+	if next, _, err := _this.in.ReadRune(); err != nil || next != '[' {
+		return
+	}
+	for {
+		r, _, err := _this.in.ReadRune()
+		if err != nil || (r >= '@' && r <= '~') {
+			return
+		}
+	}
+}
+
+// Complete the last word of the line as far as all candidates agree, listing
+// the candidates if that doesn't add anything.
+func (_this *terminalLineReader) completeLine(prompt string, line []rune) []rune {
+	word, candidates := _this.complete(string(line))
+	if len(candidates) == 0 {
+		return line
+	}
+
+	prefix := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	if len(candidates) == 1 && !strings.HasSuffix(prefix, "/") {
+		prefix += " "
+	}
+
+	if len(prefix) > len(word) {
+		addition := prefix[len(word):]
+		fmt.Print(addition)
+		return append(line, []rune(addition)...)
+	}
+
+	fmt.Println()
+	fmt.Println(strings.Join(candidates, "  "))
+	fmt.Print(prompt + string(line))
+	return line
+}
@@ -90,6 +90,10 @@ func main() {
 		if errors.Is(err, ErrorUsage) {
 			printUsageExit(cmd)
 		}
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
 		os.Exit(1)
 	}
 }
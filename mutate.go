@@ -0,0 +1,354 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+
+	"github.com/kstenerud/go-concise-encoding/configuration"
+	"github.com/kstenerud/go-concise-encoding/rules"
+)
+
+// The kinds of corruption that the mutator can apply.
+var mutationTypes = []string{"truncate", "remove-end", "type-code", "length", "utf8", "version"}
+
+// Characters that cannot begin a CTE value.
+var invalidCTEValueStarts = []byte("`^\\\x01\x7f")
+
+var invalidUTF8Sequences = []struct {
+	description string
+	bytes       []byte
+}{
+	{"invalid byte", []byte{0xff}},
+	{"unexpected continuation byte", []byte{0x80}},
+	{"overlong encoding", []byte{0xc0, 0x80}},
+	{"truncated sequence", []byte{0xe2, 0x28}},
+	{"surrogate half", []byte{0xed, 0xa0, 0x80}},
+}
+
+// A corrupted copy of a document, and what was done to it.
+type mutant struct {
+	data        []byte
+	mutation    string
+	offset      int64
+	description string
+}
+
+// documentMutator produces corrupted variants of a valid CBE or CTE document.
+// The document is decoded once, recording the range of bytes behind each
+// event, so that mutations can target specific structures (container ends,
+// type codes, array lengths, string contents and the version header) rather
+// than random bytes.
+type documentMutator struct {
+	rand     *rand.Rand
+	format   string
+	document []byte
+	spans    []dumpSpan
+	// Container end span index -> container begin span index
+	containerBegins map[int]int
+	// Mutation type -> indices of the spans it can be applied to
+	candidates map[string][]int
+	mutations  []string
+}
+
+func newDocumentMutator(format string, document []byte, seed int64, mutations []string) (mutator *documentMutator, err error) {
+	reader := &byteCountingReader{reader: bytes.NewReader(document)}
+	dumper := newCBEDumper(reader, io.Discard, 0, 1)
+	dumper.recordSpans = true
+	if err = runSource(knownSources[format], reader, rules.NewRules(dumper, configuration.New())); err != nil {
+		err = fmt.Errorf("seed document is invalid: %v", err)
+		return
+	}
+
+	mutator = &documentMutator{
+		rand:            rand.New(rand.NewSource(seed)),
+		format:          format,
+		document:        document,
+		spans:           dumper.Spans,
+		containerBegins: make(map[int]int),
+		candidates:      make(map[string][]int),
+	}
+
+	var openContainers []int
+	for i, span := range mutator.spans {
+		if span.length == 0 {
+			continue
+		}
+		switch span.role {
+		case spanContainer:
+			openContainers = append(openContainers, i)
+		case spanEnd:
+			mutator.containerBegins[i] = openContainers[len(openContainers)-1]
+			openContainers = openContainers[:len(openContainers)-1]
+			mutator.addCandidate("truncate", i)
+			mutator.addCandidate("remove-end", i)
+		case spanHeader:
+			mutator.addCandidate("version", i)
+		}
+		if span.role.IsTyped() {
+			mutator.addCandidate("type-code", i)
+		}
+		if _, _, ok := mutator.chunkHeader(span); ok {
+			mutator.addCandidate("length", i)
+		}
+		if (span.role == spanTextValue || span.role == spanText) && len(span.payload) > 0 &&
+			bytes.LastIndex(mutator.spanBytes(span), []byte(span.payload)) >= 0 {
+			mutator.addCandidate("utf8", i)
+		}
+	}
+
+	for _, mutation := range mutations {
+		if len(mutator.candidates[mutation]) > 0 {
+			mutator.mutations = append(mutator.mutations, mutation)
+		}
+	}
+	if len(mutator.mutations) == 0 {
+		err = fmt.Errorf("none of the requested mutations can be applied to this document")
+	}
+	return
+}
+
+func (_this *documentMutator) addCandidate(mutation string, spanIndex int) {
+	_this.candidates[mutation] = append(_this.candidates[mutation], spanIndex)
+}
+
+func (_this *documentMutator) spanBytes(span dumpSpan) []byte {
+	return _this.document[span.offset : span.offset+int64(span.length)]
+}
+
+// Produce a new corrupted copy of the document using a randomly selected
+// mutation.
+func (_this *documentMutator) Mutate() (result mutant) {
+	mutation := _this.mutations[_this.rand.Intn(len(_this.mutations))]
+	candidates := _this.candidates[mutation]
+	spanIndex := candidates[_this.rand.Intn(len(candidates))]
+
+	switch mutation {
+	case "truncate":
+		result = _this.truncate(spanIndex)
+	case "remove-end":
+		result = _this.removeEnd(spanIndex)
+	case "type-code":
+		result = _this.corruptTypeCode(spanIndex)
+	case "length":
+		result = _this.oversizeLength(spanIndex)
+	case "utf8":
+		result = _this.corruptUTF8(spanIndex)
+	case "version":
+		result = _this.corruptVersion(spanIndex)
+	}
+	result.mutation = mutation
+	return
+}
+
+// Build a copy of the document with the bytes at [offset, offset+length)
+// replaced.
+func (_this *documentMutator) splice(offset int64, length int, replacement []byte) []byte {
+	data := make([]byte, 0, len(_this.document)-length+len(replacement))
+	data = append(data, _this.document[:offset]...)
+	data = append(data, replacement...)
+	return append(data, _this.document[offset+int64(length):]...)
+}
+
+// Cut the document off somewhere between a container's beginning and end.
+func (_this *documentMutator) truncate(endIndex int) (result mutant) {
+	begin := _this.spans[_this.containerBegins[endIndex]]
+	end := _this.spans[endIndex]
+	first := begin.offset + int64(begin.length)
+	result.offset = first + _this.rand.Int63n(end.offset+int64(end.length)-first)
+	result.data = append([]byte(nil), _this.document[:result.offset]...)
+	result.description = fmt.Sprintf("truncated at offset %v, inside the container beginning at offset %v (depth %v)",
+		result.offset, begin.offset, begin.depth)
+	return
+}
+
+// Remove a container's end marker.
+func (_this *documentMutator) removeEnd(endIndex int) (result mutant) {
+	begin := _this.spans[_this.containerBegins[endIndex]]
+	end := _this.spans[endIndex]
+	result.offset = end.offset
+	result.data = _this.splice(end.offset, end.length, nil)
+	result.description = fmt.Sprintf("removed the end of the container beginning at offset %v (depth %v)",
+		begin.offset, begin.depth)
+	return
+}
+
+// Replace the byte that identifies a value's type. In CTE, this is the first
+// non-whitespace character of the value.
+func (_this *documentMutator) corruptTypeCode(spanIndex int) (result mutant) {
+	span := _this.spans[spanIndex]
+	data := _this.spanBytes(span)
+	position := 0
+	var replacement byte
+	if _this.format == "cte" {
+		position = len(data) - len(bytes.TrimLeft(data, " \t\r\n"))
+		if position == len(data) {
+			position = 0
+		}
+		replacement = invalidCTEValueStarts[_this.rand.Intn(len(invalidCTEValueStarts))]
+	} else {
+		replacement = byte(_this.rand.Intn(255))
+		if replacement >= data[0] {
+			replacement++
+		}
+	}
+
+	result.offset = span.offset + int64(position)
+	result.data = _this.splice(result.offset, 1, []byte{replacement})
+	result.description = fmt.Sprintf("replaced type code 0x%02x with 0x%02x", data[position], replacement)
+	return
+}
+
+// Locate the array chunk header within a CBE span: either the whole span
+// (for a chunk event), or the ULEB128 immediately preceding the payload of
+// an array value. Array values with the length embedded in the type code
+// have no chunk header.
+func (_this *documentMutator) chunkHeader(span dumpSpan) (start int, end int, ok bool) {
+	if _this.format != "cbe" || span.length == 0 {
+		return
+	}
+	data := _this.spanBytes(span)
+	if span.role == spanChunk {
+		return 0, len(data), true
+	}
+	if span.role != spanValue && span.role != spanTextValue {
+		return
+	}
+	if !bytes.HasSuffix(data, []byte(span.payload)) {
+		return
+	}
+
+	end = len(data) - len(span.payload)
+	if end < 2 || data[end-1]&0x80 != 0 {
+		return
+	}
+	start = end - 1
+	for start > 1 && data[start-1]&0x80 != 0 {
+		start--
+	}
+	header, length := binary.Uvarint(data[start:end])
+	if length != end-start || header&1 != 0 {
+		return
+	}
+	elementCount := header >> 1
+	if elementCount > uint64(len(span.payload))*8 || (elementCount == 0) != (len(span.payload) == 0) {
+		return
+	}
+	return start, end, true
+}
+
+// Replace an array chunk length with one far larger than the document.
+func (_this *documentMutator) oversizeLength(spanIndex int) (result mutant) {
+	span := _this.spans[spanIndex]
+	start, end, _ := _this.chunkHeader(span)
+	header, _ := binary.Uvarint(_this.spanBytes(span)[start:end])
+	moreChunks := header & 1
+
+	newLength := uint64(len(_this.document)) + uint64(1)<<uint(16+_this.rand.Intn(46))
+	encoded := make([]byte, binary.MaxVarintLen64)
+	encoded = encoded[:binary.PutUvarint(encoded, newLength<<1|moreChunks)]
+
+	result.offset = span.offset + int64(start)
+	result.data = _this.splice(result.offset, end-start, encoded)
+	result.description = fmt.Sprintf("replaced array chunk length %v with %v", header>>1, newLength)
+	return
+}
+
+// Overwrite part of a string with an invalid UTF-8 sequence of no greater
+// length.
+func (_this *documentMutator) corruptUTF8(spanIndex int) (result mutant) {
+	span := _this.spans[spanIndex]
+	textStart := bytes.LastIndex(_this.spanBytes(span), []byte(span.payload))
+	position := _this.rand.Intn(len(span.payload))
+	room := len(span.payload) - position
+
+	var choices []int
+	for i, sequence := range invalidUTF8Sequences {
+		if len(sequence.bytes) <= room {
+			choices = append(choices, i)
+		}
+	}
+	sequence := invalidUTF8Sequences[choices[_this.rand.Intn(len(choices))]]
+
+	result.offset = span.offset + int64(textStart+position)
+	result.data = _this.splice(result.offset, len(sequence.bytes), sequence.bytes)
+	result.description = fmt.Sprintf("wrote invalid UTF-8 (%v: % x) into a string", sequence.description, sequence.bytes)
+	return
+}
+
+// Change the document version, or the byte that identifies the format.
+func (_this *documentMutator) corruptVersion(spanIndex int) (result mutant) {
+	span := _this.spans[spanIndex]
+	data := _this.spanBytes(span)
+	result.offset = span.offset
+
+	if _this.rand.Intn(4) == 0 || len(data) < 2 {
+		replacement := byte(_this.rand.Intn(255))
+		if replacement >= data[0] {
+			replacement++
+		}
+		result.data = _this.splice(span.offset, 1, []byte{replacement})
+		result.description = fmt.Sprintf("replaced header byte 0x%02x with 0x%02x", data[0], replacement)
+		return
+	}
+
+	if _this.format == "cte" {
+		end := 1
+		for end < len(data) && data[end] >= '0' && data[end] <= '9' {
+			end++
+		}
+		oldVersion := string(data[1:end])
+		newVersion := oldVersion
+		for newVersion == oldVersion {
+			newVersion = strconv.Itoa(_this.rand.Intn(1000))
+		}
+		result.offset++
+		result.data = _this.splice(result.offset, end-1, []byte(newVersion))
+		result.description = fmt.Sprintf("replaced version %q with %q", oldVersion, newVersion)
+		return
+	}
+
+	oldVersion, length := binary.Uvarint(data[1:])
+	if length <= 0 {
+		length = len(data) - 1
+	}
+	newVersion := oldVersion
+	for newVersion == oldVersion {
+		newVersion = uint64(_this.rand.Intn(1000))
+	}
+	encoded := make([]byte, binary.MaxVarintLen64)
+	encoded = encoded[:binary.PutUvarint(encoded, newVersion)]
+	result.offset++
+	result.data = _this.splice(result.offset, length, encoded)
+	result.description = fmt.Sprintf("replaced version %v with %v", oldVersion, newVersion)
+	return
+}
+
+// Check whether a mutant still decodes. Some mutations (such as replacing a
+// type code with another valid one) can produce a valid document.
+func (_this *documentMutator) IsValid(data []byte) bool {
+	return runSource(knownSources[_this.format], bytes.NewReader(data), rules.NewRules(new(nullEventReceiver), configuration.New())) == nil
+}
@@ -0,0 +1,444 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Apply an RFC 6902 JSON Patch (a list of operations) to a document tree,
+// returning the patched document.
+func applyJSONPatch(document interface{}, patch interface{}) (interface{}, error) {
+	operations, ok := unmarked(patch).([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("a JSON patch must be a list of operations")
+	}
+
+	for i, o := range operations {
+		operation, ok := unmarked(o).(*orderedMap)
+		if !ok {
+			return nil, fmt.Errorf("patch operation %v: must be a map", i)
+		}
+		var err error
+		if document, err = applyPatchOperation(document, operation); err != nil {
+			return nil, fmt.Errorf("patch operation %v: %v", i, err)
+		}
+	}
+	return document, nil
+}
+
+func getPatchString(operation *orderedMap, name string) (string, error) {
+	v, ok := operation.Get(name)
+	if !ok {
+		return "", fmt.Errorf("missing \"%v\"", name)
+	}
+	str, ok := unmarked(v).(string)
+	if !ok {
+		return "", fmt.Errorf("\"%v\" must be a string", name)
+	}
+	return str, nil
+}
+
+func applyPatchOperation(document interface{}, operation *orderedMap) (interface{}, error) {
+	op, err := getPatchString(operation, "op")
+	if err != nil {
+		return nil, err
+	}
+	pathString, err := getPatchString(operation, "path")
+	if err != nil {
+		return nil, err
+	}
+	path, err := parsePointer(pathString)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "add", "replace", "test":
+		value, ok := operation.Get("value")
+		if !ok {
+			return nil, fmt.Errorf("missing \"value\"")
+		}
+		switch op {
+		case "add":
+			return patchAdd(document, path, value)
+		case "replace":
+			return patchReplace(document, path, value)
+		default:
+			existing, err := getPointerValue(document, path)
+			if err != nil {
+				return nil, err
+			}
+			if !valuesEqual(existing, value) {
+				return nil, fmt.Errorf("test failed: %v is not %v", pathString, formatDiffValue(value))
+			}
+			return document, nil
+		}
+	case "remove":
+		document, _, err = patchRemove(document, path)
+		return document, err
+	case "move", "copy":
+		fromString, err := getPatchString(operation, "from")
+		if err != nil {
+			return nil, err
+		}
+		from, err := parsePointer(fromString)
+		if err != nil {
+			return nil, err
+		}
+		var value interface{}
+		if op == "move" {
+			if len(path) > len(from) && strings.HasPrefix(pathString, fromString+"/") {
+				return nil, fmt.Errorf("cannot move %v into itself", fromString)
+			}
+			if document, value, err = patchRemove(document, from); err != nil {
+				return nil, err
+			}
+		} else {
+			if value, err = getPointerValue(document, from); err != nil {
+				return nil, err
+			}
+			value = copyValue(value)
+		}
+		return patchAdd(document, path, value)
+	default:
+		return nil, fmt.Errorf("%v: unknown operation", op)
+	}
+}
+
+// Parse a JSON Pointer into its reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%v: JSON pointers must begin with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func getPointerValue(document interface{}, path []string) (interface{}, error) {
+	value := document
+	for _, token := range path {
+		var err error
+		if value, err = getChild(value, token); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+func findMapToken(m *orderedMap, token string) int {
+	for i, entry := range m.entries {
+		if fmt.Sprintf("%v", unmarked(entry.key)) == token {
+			return i
+		}
+	}
+	return -1
+}
+
+func listIndex(list []interface{}, token string, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return len(list), nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("%v: invalid list index", token)
+	}
+	limit := len(list)
+	if allowEnd {
+		limit++
+	}
+	if index >= limit {
+		return 0, fmt.Errorf("%v: list index out of range", token)
+	}
+	return index, nil
+}
+
+func getChild(container interface{}, token string) (interface{}, error) {
+	switch v := unmarked(container).(type) {
+	case *orderedMap:
+		if i := findMapToken(v, token); i >= 0 {
+			return v.entries[i].value, nil
+		}
+		return nil, fmt.Errorf("%v: no such key", token)
+	case []interface{}:
+		i, err := listIndex(v, token, false)
+		if err != nil {
+			return nil, err
+		}
+		return v[i], nil
+	default:
+		return nil, fmt.Errorf("%v: cannot look up a child of a %v", token, typeCategory(container))
+	}
+}
+
+// Apply modify to the container holding the last token of path, returning the
+// updated document.
+func modifyAt(document interface{}, path []string,
+	modify func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+
+	if len(path) == 1 {
+		return modify(document, path[0])
+	}
+
+	child, err := getChild(document, path[0])
+	if err != nil {
+		return nil, err
+	}
+	newChild, err := modifyAt(child, path[1:], modify)
+	if err != nil {
+		return nil, err
+	}
+	setChild(document, path[0], newChild)
+	return document, nil
+}
+
+// Replace an existing child.
+func setChild(container interface{}, token string, value interface{}) {
+	switch v := unmarked(container).(type) {
+	case *orderedMap:
+		v.entries[findMapToken(v, token)].value = value
+	case []interface{}:
+		i, _ := listIndex(v, token, false)
+		v[i] = value
+	}
+}
+
+func patchAdd(document interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return modifyAt(document, path, func(container interface{}, token string) (interface{}, error) {
+		switch v := unmarked(container).(type) {
+		case *orderedMap:
+			if i := findMapToken(v, token); i >= 0 {
+				v.entries[i].value = value
+			} else {
+				v.Set(token, value)
+			}
+			return container, nil
+		case []interface{}:
+			i, err := listIndex(v, token, true)
+			if err != nil {
+				return nil, err
+			}
+			v = append(v, nil)
+			copy(v[i+1:], v[i:])
+			v[i] = value
+			return rewrapMarked(container, v), nil
+		default:
+			return nil, fmt.Errorf("%v: cannot add a child to a %v", token, typeCategory(container))
+		}
+	})
+}
+
+func patchReplace(document interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return modifyAt(document, path, func(container interface{}, token string) (interface{}, error) {
+		if _, err := getChild(container, token); err != nil {
+			return nil, err
+		}
+		setChild(container, token, value)
+		return container, nil
+	})
+}
+
+func patchRemove(document interface{}, path []string) (newDocument interface{}, removed interface{}, err error) {
+	if len(path) == 0 {
+		return nil, document, nil
+	}
+	newDocument, err = modifyAt(document, path, func(container interface{}, token string) (interface{}, error) {
+		switch v := unmarked(container).(type) {
+		case *orderedMap:
+			i := findMapToken(v, token)
+			if i < 0 {
+				return nil, fmt.Errorf("%v: no such key", token)
+			}
+			removed = v.entries[i].value
+			v.entries = append(v.entries[:i], v.entries[i+1:]...)
+			return container, nil
+		case []interface{}:
+			i, err := listIndex(v, token, false)
+			if err != nil {
+				return nil, err
+			}
+			removed = v[i]
+			return rewrapMarked(container, append(v[:i], v[i+1:]...)), nil
+		default:
+			return nil, fmt.Errorf("%v: cannot remove a child from a %v", token, typeCategory(container))
+		}
+	})
+	return
+}
+
+// Put a modified value back in the marker that wrapped the original, if any.
+func rewrapMarked(original interface{}, value interface{}) interface{} {
+	if marked, ok := original.(*markedValue); ok {
+		marked.value = value
+		return marked
+	}
+	return value
+}
+
+// Make a deep copy of a document tree value.
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *orderedMap:
+		m := newOrderedMap()
+		for _, entry := range v.entries {
+			m.entries = append(m.entries, mapEntry{key: entry.key, value: copyValue(entry.value)})
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, elem := range v {
+			list[i] = copyValue(elem)
+		}
+		return list
+	case *nodeValue:
+		return &nodeValue{value: copyValue(v.value), children: copyValue(v.children).([]interface{})}
+	case *edgeValue:
+		return &edgeValue{source: copyValue(v.source), description: copyValue(v.description), destination: copyValue(v.destination)}
+	case *markedValue:
+		// A copy can't have the same marker ID as the original.
+		return copyValue(v.value)
+	default:
+		return value
+	}
+}
+
+// Apply an RFC 7396 JSON Merge Patch to a document tree, returning the
+// patched document.
+func applyMergePatch(target interface{}, patch interface{}) interface{} {
+	patchMap, ok := unmarked(patch).(*orderedMap)
+	if !ok {
+		return patch
+	}
+	targetMap, ok := unmarked(target).(*orderedMap)
+	if !ok {
+		targetMap = newOrderedMap()
+		target = targetMap
+	}
+	for _, entry := range patchMap.entries {
+		i := findMapKey(targetMap, entry.key)
+		switch {
+		case unmarked(entry.value) == nil:
+			if i >= 0 {
+				targetMap.entries = append(targetMap.entries[:i], targetMap.entries[i+1:]...)
+			}
+		case i >= 0:
+			targetMap.entries[i].value = applyMergePatch(targetMap.entries[i].value, entry.value)
+		default:
+			targetMap.Set(entry.key, applyMergePatch(nil, entry.value))
+		}
+	}
+	return target
+}
+
+// Generate an RFC 6902 JSON Patch that transforms oldDoc into newDoc.
+func generateJSONPatch(oldDoc, newDoc interface{}) []interface{} {
+	operations := []interface{}{}
+	generatePatchOperations("", oldDoc, newDoc, &operations)
+	return operations
+}
+
+func patchOperation(op string, path string) *orderedMap {
+	return newOrderedMap().Set("op", op).Set("path", path)
+}
+
+func generatePatchOperations(path string, oldValue, newValue interface{}, operations *[]interface{}) {
+	if valuesEqual(oldValue, newValue) {
+		return
+	}
+
+	switch o := unmarked(oldValue).(type) {
+	case *orderedMap:
+		if n, ok := unmarked(newValue).(*orderedMap); ok {
+			for _, entry := range o.entries {
+				if i := findMapKey(n, entry.key); i >= 0 {
+					generatePatchOperations(childPath(path, entry.key), entry.value, n.entries[i].value, operations)
+				} else {
+					*operations = append(*operations, patchOperation("remove", childPath(path, entry.key)))
+				}
+			}
+			for _, entry := range n.entries {
+				if findMapKey(o, entry.key) < 0 {
+					*operations = append(*operations, patchOperation("add", childPath(path, entry.key)).Set("value", entry.value))
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if n, ok := unmarked(newValue).([]interface{}); ok {
+			common := len(o)
+			if len(n) < common {
+				common = len(n)
+			}
+			for i := 0; i < common; i++ {
+				generatePatchOperations(childPath(path, i), o[i], n[i], operations)
+			}
+			for i := common; i < len(n); i++ {
+				*operations = append(*operations, patchOperation("add", childPath(path, i)).Set("value", n[i]))
+			}
+			for i := len(o) - 1; i >= common; i-- {
+				*operations = append(*operations, patchOperation("remove", childPath(path, i)))
+			}
+			return
+		}
+	}
+	*operations = append(*operations, patchOperation("replace", path).Set("value", newValue))
+}
+
+// Generate an RFC 7396 JSON Merge Patch that transforms oldDoc into newDoc.
+// Merge patches can't set a value to null, because null means removal.
+func generateMergePatch(oldDoc, newDoc interface{}) interface{} {
+	o, oldIsMap := unmarked(oldDoc).(*orderedMap)
+	n, newIsMap := unmarked(newDoc).(*orderedMap)
+	if !oldIsMap || !newIsMap {
+		return newDoc
+	}
+
+	patch := newOrderedMap()
+	for _, entry := range o.entries {
+		if findMapKey(n, entry.key) < 0 {
+			patch.Set(entry.key, nil)
+		}
+	}
+	for _, entry := range n.entries {
+		i := findMapKey(o, entry.key)
+		switch {
+		case i < 0:
+			patch.Set(entry.key, entry.value)
+		case !valuesEqual(o.entries[i].value, entry.value):
+			patch.Set(entry.key, generateMergePatch(o.entries[i].value, entry.value))
+		}
+	}
+	return patch
+}
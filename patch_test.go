@@ -0,0 +1,189 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test vectors from RFC 6902 Appendix A.
+func TestApplyJSONPatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		patch    string
+		expected string // Empty means the patch must fail
+	}{
+		{"A.1 add object member",
+			`{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz","value":"qux"}]`,
+			`{"baz":"qux","foo":"bar"}`},
+		{"A.2 add array element",
+			`{"foo":["bar","baz"]}`,
+			`[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			`{"foo":["bar","qux","baz"]}`},
+		{"A.3 remove object member",
+			`{"baz":"qux","foo":"bar"}`,
+			`[{"op":"remove","path":"/baz"}]`,
+			`{"foo":"bar"}`},
+		{"A.4 remove array element",
+			`{"foo":["bar","qux","baz"]}`,
+			`[{"op":"remove","path":"/foo/1"}]`,
+			`{"foo":["bar","baz"]}`},
+		{"A.5 replace value",
+			`{"baz":"qux","foo":"bar"}`,
+			`[{"op":"replace","path":"/baz","value":"boo"}]`,
+			`{"baz":"boo","foo":"bar"}`},
+		{"A.6 move value",
+			`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{"A.7 move array element",
+			`{"foo":["all","grass","cows","eat"]}`,
+			`[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			`{"foo":["all","cows","eat","grass"]}`},
+		{"A.8 test value success",
+			`{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{"A.9 test value error",
+			`{"baz":"qux"}`,
+			`[{"op":"test","path":"/baz","value":"bar"}]`,
+			``},
+		{"A.10 add nested member object",
+			`{"foo":"bar"}`,
+			`[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			`{"foo":"bar","child":{"grandchild":{}}}`},
+		{"A.11 ignore unrecognized elements",
+			`{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+			`{"foo":"bar","baz":"qux"}`},
+		{"A.12 add to nonexistent target",
+			`{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+			``},
+		{"A.14 escape ordering",
+			`{"/":9,"~1":10}`,
+			`[{"op":"test","path":"/~01","value":10}]`,
+			`{"/":9,"~1":10}`},
+		{"A.15 compare strings and numbers",
+			`{"/":9,"~1":10}`,
+			`[{"op":"test","path":"/~01","value":"10"}]`,
+			``},
+		{"A.16 add array value",
+			`{"foo":["bar"]}`,
+			`[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			`{"foo":["bar",["abc","def"]]}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := applyJSONPatch(parseJSON(t, test.document), parseJSON(t, test.patch))
+			if test.expected == "" {
+				if err == nil {
+					t.Fatalf("expected an error, but got %v", formatDiffValue(result))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := parseJSON(t, test.expected); !valuesEqual(result, expected) {
+				t.Errorf("expected %v but got %v", formatDiffValue(expected), formatDiffValue(result))
+			}
+		})
+	}
+}
+
+// RFC 6902 A.13: a patch with duplicate members is invalid. The rules reject
+// duplicate map keys, so the patch can't even be read.
+func TestApplyJSONPatchDuplicateMembers(t *testing.T) {
+	_, err := buildDocument(JSONToCE, strings.NewReader(`[{"op":"add","path":"/baz","value":"qux","op":"remove"}]`))
+	if err == nil {
+		t.Error("expected the duplicate \"op\" member to be rejected")
+	}
+}
+
+// Test vectors from RFC 7396 Appendix A.
+func TestApplyMergePatch(t *testing.T) {
+	tests := []struct {
+		target   string
+		patch    string
+		expected string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, test := range tests {
+		result := applyMergePatch(parseJSON(t, test.target), parseJSON(t, test.patch))
+		if expected := parseJSON(t, test.expected); !valuesEqual(result, expected) {
+			t.Errorf("%v + %v: expected %v but got %v", test.target, test.patch,
+				formatDiffValue(expected), formatDiffValue(result))
+		}
+	}
+}
+
+func TestGeneratePatches(t *testing.T) {
+	tests := []struct {
+		oldDoc string
+		newDoc string
+	}{
+		{`{"a":1}`, `{"a":1}`},
+		{`{"a":1,"b":2}`, `{"a":3,"c":[1,2]}`},
+		{`{"a":{"b":{"c":"d"}}}`, `{"a":{"b":{"c":"e","f":true}}}`},
+		{`[1,2,3]`, `[1,3]`},
+		{`[1,2]`, `[0,1,2,3]`},
+		{`{"a/b":1,"c~d":2}`, `{"a/b":2}`},
+		{`{"a":[1]}`, `"scalar"`},
+	}
+
+	for _, test := range tests {
+		oldDoc, newDoc := parseJSON(t, test.oldDoc), parseJSON(t, test.newDoc)
+
+		result, err := applyJSONPatch(oldDoc, generateJSONPatch(oldDoc, newDoc))
+		if err != nil {
+			t.Errorf("%v -> %v: JSON patch: %v", test.oldDoc, test.newDoc, err)
+		} else if !valuesEqual(result, newDoc) {
+			t.Errorf("%v -> %v: JSON patch produced %v", test.oldDoc, test.newDoc, formatDiffValue(result))
+		}
+
+		oldDoc = parseJSON(t, test.oldDoc)
+		result = applyMergePatch(oldDoc, generateMergePatch(oldDoc, newDoc))
+		if !valuesEqual(result, newDoc) {
+			t.Errorf("%v -> %v: merge patch produced %v", test.oldDoc, test.newDoc, formatDiffValue(result))
+		}
+	}
+}
@@ -0,0 +1,1009 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/kstenerud/go-concise-encoding/ce/events"
+	"github.com/kstenerud/go-concise-encoding/version"
+
+	"github.com/cockroachdb/apd/v2"
+	compact_float "github.com/kstenerud/go-compact-float"
+	compact_time "github.com/kstenerud/go-compact-time"
+)
+
+// A query selects values from a document. It can be written as a JSON Pointer
+// (/servers/3/address), where a * segment matches any child and a ** segment
+// matches any number of levels, or as a JSONPath style expression:
+//
+//	$.servers[3].address
+//	$.servers[*].address
+//	$..address
+//	$.servers[?(@.port >= 8000 && @.name != 'test')].address
+type query struct {
+	steps []queryStep
+}
+
+type queryStepType int
+
+const (
+	queryStepKey      queryStepType = iota // The child with a key (or list index) of name
+	queryStepIndex                         // The list element at index
+	queryStepWildcard                      // Any child
+	queryStepDescent                       // Zero or more levels of descendants
+	queryStepFilter                        // Any child that the filter accepts
+)
+
+type queryStep struct {
+	stepType queryStepType
+	name     string
+	index    int
+	filter   filterExpr
+}
+
+// Where a value is within its parent container.
+type querySegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+func parseQuery(expression string) (*query, error) {
+	switch {
+	case expression == "" || expression == "$":
+		return &query{}, nil
+	case strings.HasPrefix(expression, "/"):
+		return parseQueryPointer(expression), nil
+	case strings.HasPrefix(expression, "$"):
+		parser := &queryParser{text: expression, pos: 1}
+		return parser.parsePath()
+	default:
+		return nil, fmt.Errorf("%v: queries must begin with / or $", expression)
+	}
+}
+
+func parseQueryPointer(expression string) *query {
+	q := &query{}
+	for _, segment := range strings.Split(expression[1:], "/") {
+		switch segment {
+		case "*":
+			q.steps = append(q.steps, queryStep{stepType: queryStepWildcard})
+		case "**":
+			q.steps = append(q.steps, queryStep{stepType: queryStepDescent})
+		default:
+			segment = strings.Replace(strings.Replace(segment, "~1", "/", -1), "~0", "~", -1)
+			q.steps = append(q.steps, queryStep{stepType: queryStepKey, name: segment})
+		}
+	}
+	return q
+}
+
+// Returns true if the query can match at most one value.
+func (_this *query) IsSingular() bool {
+	for _, step := range _this.steps {
+		if step.stepType != queryStepKey && step.stepType != queryStepIndex {
+			return false
+		}
+	}
+	return true
+}
+
+func (_this *query) matches(step queryStep, segment querySegment) bool {
+	switch step.stepType {
+	case queryStepKey:
+		if segment.isIndex {
+			return step.name == strconv.Itoa(segment.index)
+		}
+		return step.name == segment.key
+	case queryStepIndex:
+		return segment.isIndex && segment.index == step.index
+	case queryStepWildcard:
+		return true
+	default:
+		return false
+	}
+}
+
+// Get the step positions reachable from positions without moving to a child.
+func (_this *query) closure(positions []int) []int {
+	for i := 0; i < len(positions); i++ {
+		p := positions[i]
+		if p < len(_this.steps) && _this.steps[p].stepType == queryStepDescent {
+			positions = addQueryPosition(positions, p+1)
+		}
+	}
+	return positions
+}
+
+// Get the step positions that apply to a child at segment, and the positions
+// of any filters that must examine the child before continuing.
+func (_this *query) advance(positions []int, segment querySegment) (next []int, filters []int) {
+	for _, p := range positions {
+		if p >= len(_this.steps) {
+			continue
+		}
+		step := _this.steps[p]
+		switch step.stepType {
+		case queryStepDescent:
+			next = addQueryPosition(next, p)
+		case queryStepFilter:
+			filters = append(filters, p)
+		default:
+			if _this.matches(step, segment) {
+				next = addQueryPosition(next, p+1)
+			}
+		}
+	}
+	return _this.closure(next), filters
+}
+
+func addQueryPosition(positions []int, p int) []int {
+	for _, existing := range positions {
+		if existing == p {
+			return positions
+		}
+	}
+	return append(positions, p)
+}
+
+type queryParser struct {
+	text string
+	pos  int
+}
+
+func (_this *queryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%v: %v (at position %v)", _this.text, fmt.Sprintf(format, args...), _this.pos)
+}
+
+func (_this *queryParser) skipWhitespace() {
+	for _this.pos < len(_this.text) && (_this.text[_this.pos] == ' ' || _this.text[_this.pos] == '\t') {
+		_this.pos++
+	}
+}
+
+func (_this *queryParser) hasPrefix(prefix string) bool {
+	return strings.HasPrefix(_this.text[_this.pos:], prefix)
+}
+
+func (_this *queryParser) consume(prefix string) bool {
+	_this.skipWhitespace()
+	if _this.hasPrefix(prefix) {
+		_this.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+func (_this *queryParser) expect(prefix string) error {
+	if !_this.consume(prefix) {
+		return _this.errorf("expected %v", prefix)
+	}
+	return nil
+}
+
+func isQueryNameChar(b byte) bool {
+	return b == '_' || b == '-' || b >= 0x80 ||
+		(b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
+}
+
+func (_this *queryParser) parseName() string {
+	start := _this.pos
+	for _this.pos < len(_this.text) && isQueryNameChar(_this.text[_this.pos]) {
+		_this.pos++
+	}
+	return _this.text[start:_this.pos]
+}
+
+func (_this *queryParser) parseQuoted() (string, error) {
+	quote := _this.text[_this.pos]
+	var b strings.Builder
+	for _this.pos++; _this.pos < len(_this.text); _this.pos++ {
+		ch := _this.text[_this.pos]
+		switch {
+		case ch == quote:
+			_this.pos++
+			return b.String(), nil
+		case ch == '\\' && _this.pos+1 < len(_this.text):
+			_this.pos++
+			b.WriteByte(_this.text[_this.pos])
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return "", _this.errorf("unterminated string")
+}
+
+// Parse a list index.
+func (_this *queryParser) parseInt() (int, error) {
+	if _this.hasPrefix("-") {
+		return 0, _this.errorf("negative indexes are not supported")
+	}
+	start := _this.pos
+	for _this.pos < len(_this.text) && isDecimalDigit(_this.text[_this.pos]) {
+		_this.pos++
+	}
+	v, err := strconv.Atoi(_this.text[start:_this.pos])
+	if err != nil {
+		return 0, _this.errorf("invalid index")
+	}
+	return v, nil
+}
+
+func (_this *queryParser) parsePath() (*query, error) {
+	q := &query{}
+	for _this.pos < len(_this.text) {
+		switch {
+		case _this.consume(".."):
+			q.steps = append(q.steps, queryStep{stepType: queryStepDescent})
+			if _this.hasPrefix("[") {
+				continue
+			}
+			fallthrough
+		case _this.consume("."):
+			if _this.consume("*") {
+				q.steps = append(q.steps, queryStep{stepType: queryStepWildcard})
+				continue
+			}
+			name := _this.parseName()
+			if name == "" {
+				return nil, _this.errorf("expected a name")
+			}
+			q.steps = append(q.steps, queryStep{stepType: queryStepKey, name: name})
+		case _this.consume("["):
+			step, err := _this.parseBracket()
+			if err != nil {
+				return nil, err
+			}
+			q.steps = append(q.steps, step)
+		default:
+			return nil, _this.errorf("unexpected character %q", _this.text[_this.pos])
+		}
+	}
+	return q, nil
+}
+
+func (_this *queryParser) parseBracket() (step queryStep, err error) {
+	_this.skipWhitespace()
+	switch {
+	case _this.consume("*"):
+		step.stepType = queryStepWildcard
+	case _this.consume("?"):
+		step.stepType = queryStepFilter
+		if step.filter, err = _this.parseExpression(); err != nil {
+			return
+		}
+	case _this.hasPrefix("'") || _this.hasPrefix("\""):
+		step.stepType = queryStepKey
+		if step.name, err = _this.parseQuoted(); err != nil {
+			return
+		}
+	default:
+		step.stepType = queryStepIndex
+		if step.index, err = _this.parseInt(); err != nil {
+			return
+		}
+	}
+	err = _this.expect("]")
+	return
+}
+
+// A filterExpr examines a candidate value (@) and produces a result, which is
+// filterMissing if it refers to something that doesn't exist.
+type filterExpr interface {
+	evaluate(candidate interface{}) interface{}
+}
+
+type filterMissingValue struct{}
+
+var filterMissing = filterMissingValue{}
+
+type filterLiteral struct{ value interface{} }
+type filterPath struct{ segments []querySegment }
+type filterNot struct{ expr filterExpr }
+type filterLogical struct {
+	isAnd       bool
+	left, right filterExpr
+}
+type filterCompare struct {
+	op          string
+	left, right filterExpr
+}
+
+func (_this *queryParser) parseExpression() (filterExpr, error) {
+	return _this.parseOr()
+}
+
+func (_this *queryParser) parseOr() (filterExpr, error) {
+	left, err := _this.parseAnd()
+	for err == nil && _this.consume("||") {
+		var right filterExpr
+		right, err = _this.parseAnd()
+		left = &filterLogical{isAnd: false, left: left, right: right}
+	}
+	return left, err
+}
+
+func (_this *queryParser) parseAnd() (filterExpr, error) {
+	left, err := _this.parseUnary()
+	for err == nil && _this.consume("&&") {
+		var right filterExpr
+		right, err = _this.parseUnary()
+		left = &filterLogical{isAnd: true, left: left, right: right}
+	}
+	return left, err
+}
+
+var filterOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+func (_this *queryParser) parseUnary() (filterExpr, error) {
+	if _this.consume("!") {
+		expr, err := _this.parseUnary()
+		return &filterNot{expr: expr}, err
+	}
+	left, err := _this.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range filterOperators {
+		if _this.consume(op) {
+			right, err := _this.parseOperand()
+			return &filterCompare{op: op, left: left, right: right}, err
+		}
+	}
+	return left, nil
+}
+
+func (_this *queryParser) parseOperand() (filterExpr, error) {
+	_this.skipWhitespace()
+	switch {
+	case _this.consume("("):
+		expr, err := _this.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		return expr, _this.expect(")")
+	case _this.consume("@"):
+		return _this.parseFilterPath()
+	case _this.hasPrefix("'") || _this.hasPrefix("\""):
+		str, err := _this.parseQuoted()
+		return &filterLiteral{value: str}, err
+	case _this.consume("true"):
+		return &filterLiteral{value: true}, nil
+	case _this.consume("false"):
+		return &filterLiteral{value: false}, nil
+	case _this.consume("null"):
+		return &filterLiteral{value: nil}, nil
+	}
+
+	start := _this.pos
+	for _this.pos < len(_this.text) && strings.IndexByte("+-.0123456789eE", _this.text[_this.pos]) >= 0 {
+		_this.pos++
+	}
+	v, _, err := apd.NewFromString(_this.text[start:_this.pos])
+	if err != nil {
+		_this.pos = start
+		return nil, _this.errorf("expected a value")
+	}
+	return &filterLiteral{value: v}, nil
+}
+
+func (_this *queryParser) parseFilterPath() (filterExpr, error) {
+	path := &filterPath{}
+	for {
+		switch {
+		case _this.hasPrefix("."):
+			_this.pos++
+			name := _this.parseName()
+			if name == "" {
+				return nil, _this.errorf("expected a name")
+			}
+			path.segments = append(path.segments, querySegment{key: name})
+		case _this.hasPrefix("["):
+			_this.pos++
+			_this.skipWhitespace()
+			if _this.hasPrefix("'") || _this.hasPrefix("\"") {
+				name, err := _this.parseQuoted()
+				if err != nil {
+					return nil, err
+				}
+				path.segments = append(path.segments, querySegment{key: name})
+			} else {
+				index, err := _this.parseInt()
+				if err != nil {
+					return nil, err
+				}
+				path.segments = append(path.segments, querySegment{index: index, isIndex: true})
+			}
+			if err := _this.expect("]"); err != nil {
+				return nil, err
+			}
+		default:
+			return path, nil
+		}
+	}
+}
+
+func (_this *filterLiteral) evaluate(candidate interface{}) interface{} {
+	return _this.value
+}
+
+func (_this *filterPath) evaluate(candidate interface{}) interface{} {
+	value := candidate
+	for _, segment := range _this.segments {
+		value = lookupChild(value, segment)
+		if value == filterMissing {
+			break
+		}
+	}
+	return value
+}
+
+// Get a child of a document tree value, or filterMissing if there's no such child.
+func lookupChild(value interface{}, segment querySegment) interface{} {
+	if marked, ok := value.(*markedValue); ok {
+		value = marked.value
+	}
+	switch v := value.(type) {
+	case *orderedMap:
+		for _, entry := range v.entries {
+			if fmt.Sprintf("%v", entry.key) == segment.key {
+				return entry.value
+			}
+		}
+	case []interface{}:
+		index := segment.index
+		if !segment.isIndex {
+			var err error
+			if index, err = strconv.Atoi(segment.key); err != nil {
+				return filterMissing
+			}
+		}
+		if index >= 0 && index < len(v) {
+			return v[index]
+		}
+	}
+	return filterMissing
+}
+
+func (_this *filterNot) evaluate(candidate interface{}) interface{} {
+	return !isFilterTrue(_this.expr.evaluate(candidate))
+}
+
+func (_this *filterLogical) evaluate(candidate interface{}) interface{} {
+	left := isFilterTrue(_this.left.evaluate(candidate))
+	if _this.isAnd != left {
+		return left
+	}
+	return isFilterTrue(_this.right.evaluate(candidate))
+}
+
+func (_this *filterCompare) evaluate(candidate interface{}) interface{} {
+	left := _this.left.evaluate(candidate)
+	right := _this.right.evaluate(candidate)
+	if left == filterMissing || right == filterMissing {
+		return _this.op == "!="
+	}
+	result, ok := compareValues(left, right)
+	switch _this.op {
+	case "==":
+		return ok && result == 0
+	case "!=":
+		return !ok || result != 0
+	case "<":
+		return ok && result < 0
+	case "<=":
+		return ok && result <= 0
+	case ">":
+		return ok && result > 0
+	default:
+		return ok && result >= 0
+	}
+}
+
+// A filter result is true if it's true, or if it's a value that exists.
+func isFilterTrue(result interface{}) bool {
+	if b, ok := result.(bool); ok {
+		return b
+	}
+	return result != filterMissing
+}
+
+// Compare two document tree values. ok is false if the values can't be compared.
+func compareValues(a, b interface{}) (result int, ok bool) {
+	if da, isNumber := toDecimal(a); isNumber {
+		if db, isNumber := toDecimal(b); isNumber {
+			return da.Cmp(db), true
+		}
+		return 0, false
+	}
+	switch va := a.(type) {
+	case string:
+		if vb, isString := b.(string); isString {
+			return strings.Compare(va, vb), true
+		}
+	case resourceID:
+		if vb, isResourceID := b.(resourceID); isResourceID {
+			return strings.Compare(string(va), string(vb)), true
+		}
+	case compact_time.Time:
+		if vb, isTime := b.(compact_time.Time); isTime {
+			return strings.Compare(va.String(), vb.String()), true
+		}
+	case nil, bool, uidValue:
+		if a == b {
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+// Convert a numeric document tree value to a decimal. NaN values can't be
+// compared, and so aren't converted.
+func toDecimal(value interface{}) (*apd.Decimal, bool) {
+	var str string
+	switch v := value.(type) {
+	case *apd.Decimal:
+		return v, v.Form == apd.Finite || v.Form == apd.Infinite
+	case int64:
+		return apd.New(v, 0), true
+	case uint64:
+		return apd.NewWithBigInt(new(big.Int).SetUint64(v), 0), true
+	case *big.Int:
+		return apd.NewWithBigInt(new(big.Int).Set(v), 0), true
+	case float64:
+		str = strconv.FormatFloat(v, 'g', -1, 64)
+	case *big.Float:
+		str = v.Text('g', -1)
+	case compact_float.DFloat:
+		str = v.String()
+	default:
+		return nil, false
+	}
+	d, _, err := apd.NewFromString(str)
+	return d, err == nil && (d.Form == apd.Finite || d.Form == apd.Infinite)
+}
+
+type queryRecording struct {
+	recorder *eventRecorder
+	slot     int
+	// If filter is not nil, the recorded value is a candidate for a filter,
+	// and matching continues from rest if the filter accepts it.
+	filter filterExpr
+	rest   *query
+}
+
+type queryFrame struct {
+	positions    []int
+	isMap        bool
+	isRecordType bool
+	recordKeys   []string
+	count        int
+	key          string
+}
+
+// queryMatcher selects the values matching a query from the events it
+// receives. Only the matching values (and the values being examined by filters)
+// are kept in memory.
+type queryMatcher struct {
+	nullEventReceiver
+	query        *query
+	frames       []*queryFrame
+	valueStack   [][]*queryRecording
+	active       []*queryRecording
+	slots        [][]*eventRecorder
+	array        *builderArray
+	arrayIsKey   bool
+	inRemoteRef  bool
+	recordTypes  eventRecorder
+	recordKeys   map[string][]string
+	recordTypeID string
+}
+
+func newQueryMatcher(q *query) *queryMatcher {
+	return &queryMatcher{
+		query:      q,
+		recordKeys: make(map[string][]string),
+	}
+}
+
+// Get the recorded events of each value that matched, in document order.
+func (_this *queryMatcher) Results() (results []*eventRecorder) {
+	for _, slot := range _this.slots {
+		results = append(results, slot...)
+	}
+	return
+}
+
+// Create an event source producing the matched values: the first matching
+// value, or a list of all of them if asList is true.
+func (_this *queryMatcher) ResultSource(asList bool) eventSource {
+	return func(_ io.Reader, receiver events.DataEventReceiver) error {
+		results := _this.Results()
+		if !asList && len(results) == 0 {
+			return fmt.Errorf("no value matches the query")
+		}
+		receiver.OnBeginDocument()
+		receiver.OnVersion(version.ConciseEncodingVersion)
+		_this.recordTypes.Replay(receiver)
+		if asList {
+			receiver.OnList()
+			for _, result := range results {
+				result.Replay(receiver)
+			}
+			receiver.OnEndContainer()
+		} else {
+			results[0].Replay(receiver)
+		}
+		receiver.OnEndDocument()
+		return nil
+	}
+}
+
+func (_this *queryMatcher) forward(event func(receiver events.DataEventReceiver)) {
+	if len(_this.frames) > 0 && _this.frames[len(_this.frames)-1].isRecordType {
+		_this.recordTypes.record(event)
+		return
+	}
+	for _, recording := range _this.active {
+		recording.recorder.record(event)
+	}
+}
+
+// Begin a value (described by text if it's a scalar), returning the query
+// positions that apply to its children, and whether it's actually a map key.
+func (_this *queryMatcher) beginValue(text string) (positions []int, isKey bool) {
+	var filters []int
+	if len(_this.frames) == 0 {
+		positions = _this.query.closure([]int{0})
+	} else {
+		parent := _this.frames[len(_this.frames)-1]
+		var segment querySegment
+		switch {
+		case parent.isRecordType:
+			_this.recordKeys[_this.recordTypeID] = append(_this.recordKeys[_this.recordTypeID], text)
+			return nil, true
+		case parent.isMap:
+			parent.count++
+			if parent.count&1 == 1 {
+				parent.key = text
+				return nil, true
+			}
+			segment.key = parent.key
+		case parent.recordKeys != nil:
+			if parent.count < len(parent.recordKeys) {
+				segment.key = parent.recordKeys[parent.count]
+			}
+			parent.count++
+		default:
+			segment.index = parent.count
+			segment.isIndex = true
+			parent.count++
+		}
+		if len(parent.positions) > 0 {
+			positions, filters = _this.query.advance(parent.positions, segment)
+		}
+	}
+
+	var started []*queryRecording
+	if containsQueryPosition(positions, len(_this.query.steps)) {
+		started = append(started, _this.startRecording(nil, nil))
+	}
+	for _, p := range filters {
+		started = append(started, _this.startRecording(_this.query.steps[p].filter,
+			&query{steps: _this.query.steps[p+1:]}))
+	}
+	_this.valueStack = append(_this.valueStack, started)
+	return positions, false
+}
+
+func containsQueryPosition(positions []int, p int) bool {
+	for _, existing := range positions {
+		if existing == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (_this *queryMatcher) startRecording(filter filterExpr, rest *query) *queryRecording {
+	recording := &queryRecording{
+		recorder: &eventRecorder{},
+		slot:     len(_this.slots),
+		filter:   filter,
+		rest:     rest,
+	}
+	_this.slots = append(_this.slots, nil)
+	_this.active = append(_this.active, recording)
+	return recording
+}
+
+func (_this *queryMatcher) endValue() {
+	finished := _this.valueStack[len(_this.valueStack)-1]
+	_this.valueStack = _this.valueStack[:len(_this.valueStack)-1]
+	for _, recording := range finished {
+		for i, active := range _this.active {
+			if active == recording {
+				_this.active = append(_this.active[:i], _this.active[i+1:]...)
+				break
+			}
+		}
+		_this.slots[recording.slot] = _this.finishRecording(recording)
+	}
+}
+
+func (_this *queryMatcher) finishRecording(recording *queryRecording) []*eventRecorder {
+	if recording.filter == nil {
+		return []*eventRecorder{recording.recorder}
+	}
+
+	builder := newDocumentBuilder()
+	_this.recordTypes.Replay(builder)
+	recording.recorder.Replay(builder)
+	if !isFilterTrue(recording.filter.evaluate(builder.Document())) {
+		return nil
+	}
+
+	matcher := newQueryMatcher(recording.rest)
+	_this.recordTypes.Replay(matcher)
+	recording.recorder.Replay(matcher)
+	return matcher.Results()
+}
+
+func (_this *queryMatcher) onScalar(text string, event func(receiver events.DataEventReceiver)) {
+	if _this.inRemoteRef {
+		_this.inRemoteRef = false
+		_this.forward(event)
+		_this.endValue()
+		return
+	}
+	_, isKey := _this.beginValue(text)
+	_this.forward(event)
+	if !isKey {
+		_this.endValue()
+	}
+}
+
+func (_this *queryMatcher) onContainer(frame *queryFrame, event func(receiver events.DataEventReceiver)) {
+	frame.positions, _ = _this.beginValue("")
+	_this.forward(event)
+	_this.frames = append(_this.frames, frame)
+}
+
+func (_this *queryMatcher) onArrayBegin(array *builderArray, event func(receiver events.DataEventReceiver)) {
+	_, _this.arrayIsKey = _this.beginValue("")
+	_this.array = array
+	_this.forward(event)
+}
+
+func (_this *queryMatcher) endArray() {
+	array := _this.array
+	_this.array = nil
+	if _this.arrayIsKey {
+		if len(_this.frames) > 0 {
+			frame := _this.frames[len(_this.frames)-1]
+			if frame.isRecordType {
+				keys := _this.recordKeys[_this.recordTypeID]
+				keys[len(keys)-1] = string(array.data)
+			} else {
+				frame.key = string(array.data)
+			}
+		}
+		return
+	}
+	_this.endValue()
+}
+
+func (_this *queryMatcher) OnPadding() {
+	_this.forward(func(r events.DataEventReceiver) { r.OnPadding() })
+}
+
+func (_this *queryMatcher) OnComment(isMultiline bool, contents []byte) {
+	contents = copyBytes(contents)
+	_this.forward(func(r events.DataEventReceiver) { r.OnComment(isMultiline, contents) })
+}
+
+func (_this *queryMatcher) OnNull() {
+	_this.onScalar("null", func(r events.DataEventReceiver) { r.OnNull() })
+}
+
+func (_this *queryMatcher) OnBoolean(v bool) {
+	_this.onScalar(strconv.FormatBool(v), func(r events.DataEventReceiver) { r.OnBoolean(v) })
+}
+
+func (_this *queryMatcher) OnTrue() {
+	_this.onScalar("true", func(r events.DataEventReceiver) { r.OnTrue() })
+}
+
+func (_this *queryMatcher) OnFalse() {
+	_this.onScalar("false", func(r events.DataEventReceiver) { r.OnFalse() })
+}
+
+func (_this *queryMatcher) OnPositiveInt(v uint64) {
+	_this.onScalar(strconv.FormatUint(v, 10), func(r events.DataEventReceiver) { r.OnPositiveInt(v) })
+}
+
+func (_this *queryMatcher) OnNegativeInt(v uint64) {
+	_this.onScalar("-"+strconv.FormatUint(v, 10), func(r events.DataEventReceiver) { r.OnNegativeInt(v) })
+}
+
+func (_this *queryMatcher) OnInt(v int64) {
+	_this.onScalar(strconv.FormatInt(v, 10), func(r events.DataEventReceiver) { r.OnInt(v) })
+}
+
+func (_this *queryMatcher) OnBigInt(v *big.Int) {
+	_this.onScalar(v.String(), func(r events.DataEventReceiver) { r.OnBigInt(v) })
+}
+
+func (_this *queryMatcher) OnFloat(v float64) {
+	_this.onScalar(strconv.FormatFloat(v, 'g', -1, 64), func(r events.DataEventReceiver) { r.OnFloat(v) })
+}
+
+func (_this *queryMatcher) OnBigFloat(v *big.Float) {
+	_this.onScalar(v.Text('g', -1), func(r events.DataEventReceiver) { r.OnBigFloat(v) })
+}
+
+func (_this *queryMatcher) OnDecimalFloat(v compact_float.DFloat) {
+	_this.onScalar(v.String(), func(r events.DataEventReceiver) { r.OnDecimalFloat(v) })
+}
+
+func (_this *queryMatcher) OnBigDecimalFloat(v *apd.Decimal) {
+	_this.onScalar(v.String(), func(r events.DataEventReceiver) { r.OnBigDecimalFloat(v) })
+}
+
+func (_this *queryMatcher) OnNan(signaling bool) {
+	_this.onScalar("nan", func(r events.DataEventReceiver) { r.OnNan(signaling) })
+}
+
+func (_this *queryMatcher) OnUID(v []byte) {
+	v = copyBytes(v)
+	_this.onScalar(formatUID(v), func(r events.DataEventReceiver) { r.OnUID(v) })
+}
+
+func (_this *queryMatcher) OnTime(v compact_time.Time) {
+	_this.onScalar(v.String(), func(r events.DataEventReceiver) { r.OnTime(v) })
+}
+
+func (_this *queryMatcher) OnArray(arrayType events.ArrayType, elementCount uint64, data []byte) {
+	data = copyBytes(data)
+	_this.onScalar(string(data), func(r events.DataEventReceiver) { r.OnArray(arrayType, elementCount, data) })
+}
+
+func (_this *queryMatcher) OnStringlikeArray(arrayType events.ArrayType, data string) {
+	_this.onScalar(data, func(r events.DataEventReceiver) { r.OnStringlikeArray(arrayType, data) })
+}
+
+func (_this *queryMatcher) OnMedia(mediaType string, data []byte) {
+	data = copyBytes(data)
+	_this.onScalar("", func(r events.DataEventReceiver) { r.OnMedia(mediaType, data) })
+}
+
+func (_this *queryMatcher) OnCustomBinary(customType uint64, data []byte) {
+	data = copyBytes(data)
+	_this.onScalar("", func(r events.DataEventReceiver) { r.OnCustomBinary(customType, data) })
+}
+
+func (_this *queryMatcher) OnCustomText(customType uint64, data string) {
+	_this.onScalar(data, func(r events.DataEventReceiver) { r.OnCustomText(customType, data) })
+}
+
+func (_this *queryMatcher) OnArrayBegin(arrayType events.ArrayType) {
+	_this.onArrayBegin(&builderArray{arrayType: arrayType},
+		func(r events.DataEventReceiver) { r.OnArrayBegin(arrayType) })
+}
+
+func (_this *queryMatcher) OnMediaBegin(mediaType string) {
+	_this.onArrayBegin(&builderArray{arrayType: events.ArrayTypeUint8},
+		func(r events.DataEventReceiver) { r.OnMediaBegin(mediaType) })
+}
+
+func (_this *queryMatcher) OnCustomBegin(arrayType events.ArrayType, customType uint64) {
+	_this.onArrayBegin(&builderArray{arrayType: arrayType},
+		func(r events.DataEventReceiver) { r.OnCustomBegin(arrayType, customType) })
+}
+
+func (_this *queryMatcher) OnArrayChunk(length uint64, moreChunksFollow bool) {
+	_this.forward(func(r events.DataEventReceiver) { r.OnArrayChunk(length, moreChunksFollow) })
+	_this.array.bytesRemaining = arrayByteCount(_this.array.arrayType, length)
+	_this.array.moreChunksComing = moreChunksFollow
+	if _this.array.bytesRemaining == 0 && !moreChunksFollow {
+		_this.endArray()
+	}
+}
+
+func (_this *queryMatcher) OnArrayData(data []byte) {
+	data = copyBytes(data)
+	_this.forward(func(r events.DataEventReceiver) { r.OnArrayData(data) })
+	if _this.arrayIsKey {
+		_this.array.data = append(_this.array.data, data...)
+	}
+	_this.array.bytesRemaining -= uint64(len(data))
+	if _this.array.bytesRemaining == 0 && !_this.array.moreChunksComing {
+		_this.endArray()
+	}
+}
+
+func (_this *queryMatcher) OnList() {
+	_this.onContainer(&queryFrame{}, func(r events.DataEventReceiver) { r.OnList() })
+}
+
+func (_this *queryMatcher) OnMap() {
+	_this.onContainer(&queryFrame{isMap: true}, func(r events.DataEventReceiver) { r.OnMap() })
+}
+
+func (_this *queryMatcher) OnNode() {
+	_this.onContainer(&queryFrame{}, func(r events.DataEventReceiver) { r.OnNode() })
+}
+
+func (_this *queryMatcher) OnEdge() {
+	_this.onContainer(&queryFrame{}, func(r events.DataEventReceiver) { r.OnEdge() })
+}
+
+func (_this *queryMatcher) OnRecordType(id []byte) {
+	id = copyBytes(id)
+	_this.frames = append(_this.frames, &queryFrame{isRecordType: true})
+	_this.recordTypeID = string(id)
+	_this.recordKeys[_this.recordTypeID] = []string{}
+	_this.forward(func(r events.DataEventReceiver) { r.OnRecordType(id) })
+}
+
+func (_this *queryMatcher) OnRecord(id []byte) {
+	id = copyBytes(id)
+	frame := &queryFrame{recordKeys: _this.recordKeys[string(id)]}
+	if frame.recordKeys == nil {
+		frame.recordKeys = []string{}
+	}
+	_this.onContainer(frame, func(r events.DataEventReceiver) { r.OnRecord(id) })
+}
+
+func (_this *queryMatcher) OnEndContainer() {
+	_this.forward(func(r events.DataEventReceiver) { r.OnEndContainer() })
+	frame := _this.frames[len(_this.frames)-1]
+	_this.frames = _this.frames[:len(_this.frames)-1]
+	if !frame.isRecordType {
+		_this.endValue()
+	}
+}
+
+func (_this *queryMatcher) OnMarker(id []byte) {
+	id = copyBytes(id)
+	_this.forward(func(r events.DataEventReceiver) { r.OnMarker(id) })
+}
+
+func (_this *queryMatcher) OnReferenceLocal(id []byte) {
+	id = copyBytes(id)
+	_this.onScalar("$"+string(id), func(r events.DataEventReceiver) { r.OnReferenceLocal(id) })
+}
+
+func (_this *queryMatcher) OnReferenceRemote() {
+	_this.beginValue("")
+	_this.forward(func(r events.DataEventReceiver) { r.OnReferenceRemote() })
+	_this.inRemoteRef = true
+}
+
+func (_this *queryMatcher) OnConstant(name []byte) {
+	name = copyBytes(name)
+	_this.onScalar("#"+string(name), func(r events.DataEventReceiver) { r.OnConstant(name) })
+}
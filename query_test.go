@@ -0,0 +1,168 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kstenerud/go-concise-encoding/configuration"
+	"github.com/kstenerud/go-concise-encoding/rules"
+)
+
+const queryTestDocument = `{
+	"store": {
+		"book": [
+			{"title": "A", "price": 8, "tags": ["x", "y"]},
+			{"title": "B", "price": 12.5, "isbn": "123"},
+			{"title": "C", "price": 22, "author": {"name": "Z"}}
+		],
+		"bicycle": {"color": "red", "price": 19.95}
+	},
+	"a/b": {"c~d": 1},
+	"list": [[1, 2], [3]]
+}`
+
+// Run a query over a JSON document, returning all matching values as a list.
+func runJSONQuery(t *testing.T, expression string, document string) interface{} {
+	t.Helper()
+	q, err := parseQuery(expression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher := newQueryMatcher(q)
+	if err = runSource(JSONToCE, strings.NewReader(document), rules.NewRules(matcher, configuration.New())); err != nil {
+		t.Fatalf("%v: %v", expression, err)
+	}
+	results, err := buildDocument(matcher.ResultSource(true), nil)
+	if err != nil {
+		t.Fatalf("%v: %v", expression, err)
+	}
+	return results
+}
+
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		// JSON Pointers
+		{`/store/book/0/title`, `["A"]`},
+		{`/store/bicycle`, `[{"color": "red", "price": 19.95}]`},
+		{`/store/book/*/title`, `["A", "B", "C"]`},
+		{`/store/*/price`, `[19.95]`},
+		{`/store/**/price`, `[8, 12.5, 22, 19.95]`},
+		{`/**/name`, `["Z"]`},
+		{`/a~1b/c~0d`, `[1]`},
+		{`/list/1/0`, `[3]`},
+		{`/store/book/3`, `[]`},
+		{`/missing`, `[]`},
+
+		// JSONPath
+		{`$`, `[` + queryTestDocument + `]`},
+		{`$.store.book[1].title`, `["B"]`},
+		{`$.store.book[*].title`, `["A", "B", "C"]`},
+		{`$.store.*.price`, `[19.95]`},
+		{`$..price`, `[8, 12.5, 22, 19.95]`},
+		{`$..name`, `["Z"]`},
+		{`$..book[2].author`, `[{"name": "Z"}]`},
+		{`$..[0]`, `[{"title": "A", "price": 8, "tags": ["x", "y"]}, "x", [1, 2], 1, 3]`},
+		{`$['store']['bicycle']["color"]`, `["red"]`},
+		{`$['a/b']['c~d']`, `[1]`},
+		{`$.list[*][*]`, `[1, 2, 3]`},
+		{`$.store.book[10]`, `[]`},
+
+		// Filters
+		{`$.store.book[?(@.price < 10)].title`, `["A"]`},
+		{`$.store.book[?(@.price > 12.5)].title`, `["C"]`},
+		{`$.store.book[?(@.price >= 12.5 && @.price <= 22)].title`, `["B", "C"]`},
+		{`$.store.book[?(@.price == 12.50)].title`, `["B"]`},
+		{`$.store.book[?(@.price != 8)].title`, `["B", "C"]`},
+		{`$.store.book[?(@.isbn)].title`, `["B"]`},
+		{`$.store.book[?(!@.isbn)].title`, `["A", "C"]`},
+		{`$.store.book[?(@.title == 'C' || @.price == 8)].title`, `["A", "C"]`},
+		{`$.store.book[?(@.author.name == "Z")].title`, `["C"]`},
+		{`$.store.book[?(@['title'] != 'A')].price`, `[12.5, 22]`},
+		{`$.store.book[?(@.tags[1] == 'y')].title`, `["A"]`},
+		{`$.store.book[?((@.price < 10 || @.price > 20) && !(@.title == 'A'))].title`, `["C"]`},
+		{`$.store.book[?(@.title == 8)].title`, `[]`},
+		{`$..book[?(@.price > 20)].author.name`, `["Z"]`},
+		{`$.store[?(@.color == 'red')].price`, `[19.95]`},
+		{`$.list[?(@[0] >= 1)]`, `[[1, 2], [3]]`},
+	}
+
+	for _, test := range tests {
+		actual := runJSONQuery(t, test.query, queryTestDocument)
+		if expected := parseJSON(t, test.expected); !valuesEqual(actual, expected) {
+			t.Errorf("%v: expected %v but got %v", test.query, formatDiffValue(expected), formatDiffValue(actual))
+		}
+	}
+}
+
+func TestQueryIsSingular(t *testing.T) {
+	tests := []struct {
+		query    string
+		singular bool
+	}{
+		{`$`, true},
+		{`/a/0/b`, true},
+		{`$.a[0]['b']`, true},
+		{`/a/*`, false},
+		{`/a/**/b`, false},
+		{`$.a[*]`, false},
+		{`$..a`, false},
+		{`$.a[?(@.b)]`, false},
+	}
+
+	for _, test := range tests {
+		q, err := parseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.IsSingular() != test.singular {
+			t.Errorf("%v: expected singular to be %v", test.query, test.singular)
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	for _, expression := range []string{
+		`store`,
+		`$store`,
+		`$.`,
+		`$..`,
+		`$[`,
+		`$[1`,
+		`$[-1]`,
+		`$[x]`,
+		`$['unterminated]`,
+		`$[?(@.a ==)]`,
+		`$[?(@.a == 1]`,
+		`$[?(@.a == 1)`,
+		`$[?(@[-1])]`,
+		`$[?(@.)]`,
+		`$[?(@.a == 'x' &&)]`,
+	} {
+		if _, err := parseQuery(expression); err == nil {
+			t.Errorf("%v: expected an error", expression)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/kstenerud/go-concise-encoding/ce/events"
+
+	"github.com/cockroachdb/apd/v2"
+	compact_float "github.com/kstenerud/go-compact-float"
+	compact_time "github.com/kstenerud/go-compact-time"
+)
+
+// nullEventReceiver ignores all events. It can be embedded to get default
+// implementations of the events a receiver doesn't care about.
+type nullEventReceiver struct{}
+
+func (_this *nullEventReceiver) OnBeginDocument()                           {}
+func (_this *nullEventReceiver) OnVersion(uint64)                           {}
+func (_this *nullEventReceiver) OnPadding()                                 {}
+func (_this *nullEventReceiver) OnComment(bool, []byte)                     {}
+func (_this *nullEventReceiver) OnNull()                                    {}
+func (_this *nullEventReceiver) OnBoolean(bool)                             {}
+func (_this *nullEventReceiver) OnTrue()                                    {}
+func (_this *nullEventReceiver) OnFalse()                                   {}
+func (_this *nullEventReceiver) OnPositiveInt(uint64)                       {}
+func (_this *nullEventReceiver) OnNegativeInt(uint64)                       {}
+func (_this *nullEventReceiver) OnInt(int64)                                {}
+func (_this *nullEventReceiver) OnBigInt(*big.Int)                          {}
+func (_this *nullEventReceiver) OnFloat(float64)                            {}
+func (_this *nullEventReceiver) OnBigFloat(*big.Float)                      {}
+func (_this *nullEventReceiver) OnDecimalFloat(compact_float.DFloat)        {}
+func (_this *nullEventReceiver) OnBigDecimalFloat(*apd.Decimal)             {}
+func (_this *nullEventReceiver) OnNan(bool)                                 {}
+func (_this *nullEventReceiver) OnUID([]byte)                               {}
+func (_this *nullEventReceiver) OnTime(compact_time.Time)                   {}
+func (_this *nullEventReceiver) OnArray(events.ArrayType, uint64, []byte)   {}
+func (_this *nullEventReceiver) OnStringlikeArray(events.ArrayType, string) {}
+func (_this *nullEventReceiver) OnMedia(string, []byte)                     {}
+func (_this *nullEventReceiver) OnCustomBinary(uint64, []byte)              {}
+func (_this *nullEventReceiver) OnCustomText(uint64, string)                {}
+func (_this *nullEventReceiver) OnArrayBegin(events.ArrayType)              {}
+func (_this *nullEventReceiver) OnMediaBegin(string)                        {}
+func (_this *nullEventReceiver) OnCustomBegin(events.ArrayType, uint64)     {}
+func (_this *nullEventReceiver) OnArrayChunk(uint64, bool)                  {}
+func (_this *nullEventReceiver) OnArrayData([]byte)                         {}
+func (_this *nullEventReceiver) OnList()                                    {}
+func (_this *nullEventReceiver) OnMap()                                     {}
+func (_this *nullEventReceiver) OnNode()                                    {}
+func (_this *nullEventReceiver) OnEdge()                                    {}
+func (_this *nullEventReceiver) OnRecordType([]byte)                        {}
+func (_this *nullEventReceiver) OnRecord([]byte)                            {}
+func (_this *nullEventReceiver) OnEndContainer()                            {}
+func (_this *nullEventReceiver) OnMarker([]byte)                            {}
+func (_this *nullEventReceiver) OnReferenceLocal([]byte)                    {}
+func (_this *nullEventReceiver) OnReferenceRemote()                         {}
+func (_this *nullEventReceiver) OnConstant([]byte)                          {}
+func (_this *nullEventReceiver) OnEndDocument()                             {}
+
+// pathTracker keeps track of where in the document the events passing through
+// it are, as a JSON Pointer style path.
+type pathTracker struct {
+	events.DataEventReceiver
+	frames []pathFrame
+}
+
+type pathFrame struct {
+	isMap   bool
+	count   int
+	segment string
+}
+
+func newPathTracker(next events.DataEventReceiver) *pathTracker {
+	return &pathTracker{DataEventReceiver: next}
+}
+
+// Get the path to the value currently being received.
+func (_this *pathTracker) Path() string {
+	var b strings.Builder
+	for _, frame := range _this.frames {
+		if frame.count == 0 {
+			break
+		}
+		b.WriteByte('/')
+		b.WriteString(escapePathSegment(frame.segment))
+	}
+	return b.String()
+}
+
+func escapePathSegment(segment string) string {
+	return strings.Replace(strings.Replace(segment, "~", "~0", -1), "/", "~1", -1)
+}
+
+// Note that a value (described by text if it's a scalar) has begun.
+func (_this *pathTracker) onValue(text string) {
+	if len(_this.frames) == 0 {
+		return
+	}
+	frame := &_this.frames[len(_this.frames)-1]
+	if frame.isMap {
+		if frame.count&1 == 0 {
+			frame.segment = text
+		}
+	} else {
+		frame.segment = fmt.Sprintf("%v", frame.count)
+	}
+	frame.count++
+}
+
+func (_this *pathTracker) beginContainer(isMap bool) {
+	_this.onValue("")
+	_this.frames = append(_this.frames, pathFrame{isMap: isMap})
+}
+
+func (_this *pathTracker) OnNull() {
+	_this.onValue("null")
+	_this.DataEventReceiver.OnNull()
+}
+
+func (_this *pathTracker) OnBoolean(v bool) {
+	_this.onValue(fmt.Sprintf("%v", v))
+	_this.DataEventReceiver.OnBoolean(v)
+}
+
+func (_this *pathTracker) OnTrue() {
+	_this.onValue("true")
+	_this.DataEventReceiver.OnTrue()
+}
+
+func (_this *pathTracker) OnFalse() {
+	_this.onValue("false")
+	_this.DataEventReceiver.OnFalse()
+}
+
+func (_this *pathTracker) OnPositiveInt(v uint64) {
+	_this.onValue(fmt.Sprintf("%v", v))
+	_this.DataEventReceiver.OnPositiveInt(v)
+}
+
+func (_this *pathTracker) OnNegativeInt(v uint64) {
+	_this.onValue(fmt.Sprintf("-%v", v))
+	_this.DataEventReceiver.OnNegativeInt(v)
+}
+
+func (_this *pathTracker) OnInt(v int64) {
+	_this.onValue(fmt.Sprintf("%v", v))
+	_this.DataEventReceiver.OnInt(v)
+}
+
+func (_this *pathTracker) OnBigInt(v *big.Int) {
+	_this.onValue(v.String())
+	_this.DataEventReceiver.OnBigInt(v)
+}
+
+func (_this *pathTracker) OnFloat(v float64) {
+	_this.onValue(fmt.Sprintf("%v", v))
+	_this.DataEventReceiver.OnFloat(v)
+}
+
+func (_this *pathTracker) OnBigFloat(v *big.Float) {
+	_this.onValue(v.Text('g', -1))
+	_this.DataEventReceiver.OnBigFloat(v)
+}
+
+func (_this *pathTracker) OnDecimalFloat(v compact_float.DFloat) {
+	_this.onValue(v.String())
+	_this.DataEventReceiver.OnDecimalFloat(v)
+}
+
+func (_this *pathTracker) OnBigDecimalFloat(v *apd.Decimal) {
+	_this.onValue(v.String())
+	_this.DataEventReceiver.OnBigDecimalFloat(v)
+}
+
+func (_this *pathTracker) OnNan(signaling bool) {
+	_this.onValue("nan")
+	_this.DataEventReceiver.OnNan(signaling)
+}
+
+func (_this *pathTracker) OnUID(v []byte) {
+	_this.onValue(formatUID(v))
+	_this.DataEventReceiver.OnUID(v)
+}
+
+func (_this *pathTracker) OnTime(v compact_time.Time) {
+	_this.onValue(v.String())
+	_this.DataEventReceiver.OnTime(v)
+}
+
+func (_this *pathTracker) OnArray(arrayType events.ArrayType, elementCount uint64, data []byte) {
+	_this.onValue(string(data))
+	_this.DataEventReceiver.OnArray(arrayType, elementCount, data)
+}
+
+func (_this *pathTracker) OnStringlikeArray(arrayType events.ArrayType, data string) {
+	_this.onValue(data)
+	_this.DataEventReceiver.OnStringlikeArray(arrayType, data)
+}
+
+func (_this *pathTracker) OnMedia(mediaType string, data []byte) {
+	_this.onValue("")
+	_this.DataEventReceiver.OnMedia(mediaType, data)
+}
+
+func (_this *pathTracker) OnCustomBinary(customType uint64, data []byte) {
+	_this.onValue("")
+	_this.DataEventReceiver.OnCustomBinary(customType, data)
+}
+
+func (_this *pathTracker) OnCustomText(customType uint64, data string) {
+	_this.onValue(data)
+	_this.DataEventReceiver.OnCustomText(customType, data)
+}
+
+func (_this *pathTracker) OnArrayBegin(arrayType events.ArrayType) {
+	_this.onValue("")
+	_this.DataEventReceiver.OnArrayBegin(arrayType)
+}
+
+func (_this *pathTracker) OnMediaBegin(mediaType string) {
+	_this.onValue("")
+	_this.DataEventReceiver.OnMediaBegin(mediaType)
+}
+
+func (_this *pathTracker) OnCustomBegin(arrayType events.ArrayType, customType uint64) {
+	_this.onValue("")
+	_this.DataEventReceiver.OnCustomBegin(arrayType, customType)
+}
+
+func (_this *pathTracker) OnList() {
+	_this.beginContainer(false)
+	_this.DataEventReceiver.OnList()
+}
+
+func (_this *pathTracker) OnMap() {
+	_this.beginContainer(true)
+	_this.DataEventReceiver.OnMap()
+}
+
+func (_this *pathTracker) OnNode() {
+	_this.beginContainer(false)
+	_this.DataEventReceiver.OnNode()
+}
+
+func (_this *pathTracker) OnEdge() {
+	_this.beginContainer(false)
+	_this.DataEventReceiver.OnEdge()
+}
+
+func (_this *pathTracker) OnRecordType(id []byte) {
+	_this.frames = append(_this.frames, pathFrame{})
+	_this.DataEventReceiver.OnRecordType(id)
+}
+
+func (_this *pathTracker) OnRecord(id []byte) {
+	_this.beginContainer(false)
+	_this.DataEventReceiver.OnRecord(id)
+}
+
+func (_this *pathTracker) OnEndContainer() {
+	if len(_this.frames) > 0 {
+		_this.frames = _this.frames[:len(_this.frames)-1]
+	}
+	_this.DataEventReceiver.OnEndContainer()
+}
+
+func (_this *pathTracker) OnReferenceLocal(id []byte) {
+	_this.onValue("$" + string(id))
+	_this.DataEventReceiver.OnReferenceLocal(id)
+}
+
+func (_this *pathTracker) OnConstant(name []byte) {
+	_this.onValue("#" + string(name))
+	_this.DataEventReceiver.OnConstant(name)
+}
+
+// eventRecorder records events so that they can be replayed later.
+type eventRecorder struct {
+	recorded []func(receiver events.DataEventReceiver)
+}
+
+func (_this *eventRecorder) record(event func(receiver events.DataEventReceiver)) {
+	_this.recorded = append(_this.recorded, event)
+}
+
+// Replay all recorded events into a receiver.
+func (_this *eventRecorder) Replay(receiver events.DataEventReceiver) {
+	for _, event := range _this.recorded {
+		event(receiver)
+	}
+}
+
+func copyBytes(data []byte) []byte {
+	return append([]byte(nil), data...)
+}
+
+func (_this *eventRecorder) OnBeginDocument() {
+	_this.record(func(r events.DataEventReceiver) { r.OnBeginDocument() })
+}
+
+func (_this *eventRecorder) OnVersion(v uint64) {
+	_this.record(func(r events.DataEventReceiver) { r.OnVersion(v) })
+}
+
+func (_this *eventRecorder) OnPadding() {
+	_this.record(func(r events.DataEventReceiver) { r.OnPadding() })
+}
+
+func (_this *eventRecorder) OnComment(isMultiline bool, contents []byte) {
+	contents = copyBytes(contents)
+	_this.record(func(r events.DataEventReceiver) { r.OnComment(isMultiline, contents) })
+}
+
+func (_this *eventRecorder) OnNull() {
+	_this.record(func(r events.DataEventReceiver) { r.OnNull() })
+}
+
+func (_this *eventRecorder) OnBoolean(v bool) {
+	_this.record(func(r events.DataEventReceiver) { r.OnBoolean(v) })
+}
+
+func (_this *eventRecorder) OnTrue() {
+	_this.record(func(r events.DataEventReceiver) { r.OnTrue() })
+}
+
+func (_this *eventRecorder) OnFalse() {
+	_this.record(func(r events.DataEventReceiver) { r.OnFalse() })
+}
+
+func (_this *eventRecorder) OnPositiveInt(v uint64) {
+	_this.record(func(r events.DataEventReceiver) { r.OnPositiveInt(v) })
+}
+
+func (_this *eventRecorder) OnNegativeInt(v uint64) {
+	_this.record(func(r events.DataEventReceiver) { r.OnNegativeInt(v) })
+}
+
+func (_this *eventRecorder) OnInt(v int64) {
+	_this.record(func(r events.DataEventReceiver) { r.OnInt(v) })
+}
+
+func (_this *eventRecorder) OnBigInt(v *big.Int) {
+	_this.record(func(r events.DataEventReceiver) { r.OnBigInt(v) })
+}
+
+func (_this *eventRecorder) OnFloat(v float64) {
+	_this.record(func(r events.DataEventReceiver) { r.OnFloat(v) })
+}
+
+func (_this *eventRecorder) OnBigFloat(v *big.Float) {
+	_this.record(func(r events.DataEventReceiver) { r.OnBigFloat(v) })
+}
+
+func (_this *eventRecorder) OnDecimalFloat(v compact_float.DFloat) {
+	_this.record(func(r events.DataEventReceiver) { r.OnDecimalFloat(v) })
+}
+
+func (_this *eventRecorder) OnBigDecimalFloat(v *apd.Decimal) {
+	_this.record(func(r events.DataEventReceiver) { r.OnBigDecimalFloat(v) })
+}
+
+func (_this *eventRecorder) OnNan(signaling bool) {
+	_this.record(func(r events.DataEventReceiver) { r.OnNan(signaling) })
+}
+
+func (_this *eventRecorder) OnUID(v []byte) {
+	v = copyBytes(v)
+	_this.record(func(r events.DataEventReceiver) { r.OnUID(v) })
+}
+
+func (_this *eventRecorder) OnTime(v compact_time.Time) {
+	_this.record(func(r events.DataEventReceiver) { r.OnTime(v) })
+}
+
+func (_this *eventRecorder) OnArray(arrayType events.ArrayType, elementCount uint64, data []byte) {
+	data = copyBytes(data)
+	_this.record(func(r events.DataEventReceiver) { r.OnArray(arrayType, elementCount, data) })
+}
+
+func (_this *eventRecorder) OnStringlikeArray(arrayType events.ArrayType, data string) {
+	_this.record(func(r events.DataEventReceiver) { r.OnStringlikeArray(arrayType, data) })
+}
+
+func (_this *eventRecorder) OnMedia(mediaType string, data []byte) {
+	data = copyBytes(data)
+	_this.record(func(r events.DataEventReceiver) { r.OnMedia(mediaType, data) })
+}
+
+func (_this *eventRecorder) OnCustomBinary(customType uint64, data []byte) {
+	data = copyBytes(data)
+	_this.record(func(r events.DataEventReceiver) { r.OnCustomBinary(customType, data) })
+}
+
+func (_this *eventRecorder) OnCustomText(customType uint64, data string) {
+	_this.record(func(r events.DataEventReceiver) { r.OnCustomText(customType, data) })
+}
+
+func (_this *eventRecorder) OnArrayBegin(arrayType events.ArrayType) {
+	_this.record(func(r events.DataEventReceiver) { r.OnArrayBegin(arrayType) })
+}
+
+func (_this *eventRecorder) OnMediaBegin(mediaType string) {
+	_this.record(func(r events.DataEventReceiver) { r.OnMediaBegin(mediaType) })
+}
+
+func (_this *eventRecorder) OnCustomBegin(arrayType events.ArrayType, customType uint64) {
+	_this.record(func(r events.DataEventReceiver) { r.OnCustomBegin(arrayType, customType) })
+}
+
+func (_this *eventRecorder) OnArrayChunk(length uint64, moreChunksFollow bool) {
+	_this.record(func(r events.DataEventReceiver) { r.OnArrayChunk(length, moreChunksFollow) })
+}
+
+func (_this *eventRecorder) OnArrayData(data []byte) {
+	data = copyBytes(data)
+	_this.record(func(r events.DataEventReceiver) { r.OnArrayData(data) })
+}
+
+func (_this *eventRecorder) OnList() {
+	_this.record(func(r events.DataEventReceiver) { r.OnList() })
+}
+
+func (_this *eventRecorder) OnMap() {
+	_this.record(func(r events.DataEventReceiver) { r.OnMap() })
+}
+
+func (_this *eventRecorder) OnNode() {
+	_this.record(func(r events.DataEventReceiver) { r.OnNode() })
+}
+
+func (_this *eventRecorder) OnEdge() {
+	_this.record(func(r events.DataEventReceiver) { r.OnEdge() })
+}
+
+func (_this *eventRecorder) OnRecordType(id []byte) {
+	id = copyBytes(id)
+	_this.record(func(r events.DataEventReceiver) { r.OnRecordType(id) })
+}
+
+func (_this *eventRecorder) OnRecord(id []byte) {
+	id = copyBytes(id)
+	_this.record(func(r events.DataEventReceiver) { r.OnRecord(id) })
+}
+
+func (_this *eventRecorder) OnEndContainer() {
+	_this.record(func(r events.DataEventReceiver) { r.OnEndContainer() })
+}
+
+func (_this *eventRecorder) OnMarker(id []byte) {
+	id = copyBytes(id)
+	_this.record(func(r events.DataEventReceiver) { r.OnMarker(id) })
+}
+
+func (_this *eventRecorder) OnReferenceLocal(id []byte) {
+	id = copyBytes(id)
+	_this.record(func(r events.DataEventReceiver) { r.OnReferenceLocal(id) })
+}
+
+func (_this *eventRecorder) OnReferenceRemote() {
+	_this.record(func(r events.DataEventReceiver) { r.OnReferenceRemote() })
+}
+
+func (_this *eventRecorder) OnConstant(name []byte) {
+	name = copyBytes(name)
+	_this.record(func(r events.DataEventReceiver) { r.OnConstant(name) })
+}
+
+func (_this *eventRecorder) OnEndDocument() {
+	_this.record(func(r events.DataEventReceiver) { r.OnEndDocument() })
+}
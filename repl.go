@@ -0,0 +1,454 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kstenerud/go-concise-encoding/version"
+)
+
+var replCommands = map[string]string{
+	"cd":     "cd <path>: Change the current location (/ is the root, .. the parent)",
+	"ls":     "ls [path]: List the children of a container",
+	"cat":    "cat [path]: Print a value as CTE",
+	"type":   "type [path]: Print the type of a value",
+	"set":    "set <path> <cte value>: Set a value, adding it if it doesn't exist (e.g. set port 8080)",
+	"delete": "delete <path>: Delete a value",
+	"save":   "save [-fmt format] [file]: Save the document (defaults to the current file and format)",
+	"pwd":    "pwd: Print the current location",
+	"help":   "help: Print this list of commands",
+	"exit":   "exit: Leave (use exit! to discard unsaved changes)",
+}
+
+// replSession holds the state of an interactive session exploring a document.
+type replSession struct {
+	document  interface{}
+	location  []string
+	srcFile   string
+	srcFormat string
+	modified  bool
+	out       io.Writer
+}
+
+func newREPLSession(document interface{}, srcFile string, srcFormat string) *replSession {
+	return &replSession{
+		document:  document,
+		srcFile:   srcFile,
+		srcFormat: srcFormat,
+		out:       os.Stdout,
+	}
+}
+
+func (_this *replSession) Prompt() string {
+	return fmt.Sprintf("%v> ", formatPointer(_this.location))
+}
+
+// Run a single command line. done is true if the session should end.
+func (_this *replSession) Execute(line string) (done bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	command, rest := splitREPLWord(line)
+	args := splitREPLArgs(rest)
+
+	switch command {
+	case "exit", "quit":
+		if _this.modified {
+			return false, fmt.Errorf("there are unsaved changes (use save, or exit! to discard them)")
+		}
+		return true, nil
+	case "exit!", "quit!":
+		return true, nil
+	case "help":
+		names := make([]string, 0, len(replCommands))
+		for name := range replCommands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(_this.out, "    "+replCommands[name])
+		}
+	case "pwd":
+		fmt.Fprintln(_this.out, formatPointer(_this.location))
+	case "cd":
+		err = _this.cd(args)
+	case "ls":
+		err = _this.ls(args)
+	case "cat":
+		err = _this.cat(args)
+	case "type":
+		err = _this.printType(args)
+	case "set":
+		err = _this.set(rest)
+	case "delete", "rm":
+		err = _this.delete(args)
+	case "save":
+		err = _this.save(args)
+	default:
+		err = fmt.Errorf("%v: unknown command (try help)", command)
+	}
+	return
+}
+
+// Resolve a path relative to the current location. Paths beginning with / are
+// absolute, and .. refers to the parent.
+func (_this *replSession) resolve(path string) []string {
+	location := append([]string{}, _this.location...)
+	if strings.HasPrefix(path, "/") {
+		location = nil
+	}
+	for _, token := range strings.Split(path, "/") {
+		switch token {
+		case "", ".":
+		case "..":
+			if len(location) > 0 {
+				location = location[:len(location)-1]
+			}
+		default:
+			location = append(location, strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1))
+		}
+	}
+	return location
+}
+
+func (_this *replSession) lookup(args []string) (path []string, value interface{}, err error) {
+	pathArg := ""
+	if len(args) > 0 {
+		pathArg = args[0]
+	}
+	path = _this.resolve(pathArg)
+	value, err = getPointerValue(_this.document, path)
+	return
+}
+
+func (_this *replSession) cd(args []string) error {
+	if len(args) == 0 {
+		args = []string{"/"}
+	}
+	path, value, err := _this.lookup(args)
+	if err != nil {
+		return err
+	}
+	if !isREPLContainer(value) {
+		return fmt.Errorf("%v: not a container (it's a %v)", formatPointer(path), typeCategory(value))
+	}
+	_this.location = path
+	return nil
+}
+
+func (_this *replSession) ls(args []string) error {
+	_, value, err := _this.lookup(args)
+	if err != nil {
+		return err
+	}
+	if !isREPLContainer(value) {
+		fmt.Fprintf(_this.out, "%v\n", formatREPLPreview(value))
+		return nil
+	}
+	for _, token := range childTokens(value) {
+		child, _ := getChild(value, token)
+		fmt.Fprintf(_this.out, "%-20v %-12v %v\n", token, typeCategory(child), formatREPLPreview(child))
+	}
+	return nil
+}
+
+func (_this *replSession) cat(args []string) error {
+	_, value, err := _this.lookup(args)
+	if err != nil {
+		return err
+	}
+	if err = writeValue(value, "cte", _this.out, &encoderConfig{indentSpaces: 4}); err != nil {
+		return err
+	}
+	fmt.Fprintln(_this.out)
+	return nil
+}
+
+func (_this *replSession) printType(args []string) error {
+	_, value, err := _this.lookup(args)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(_this.out, typeCategory(value))
+	return nil
+}
+
+func (_this *replSession) set(rest string) error {
+	pathArg, valueText, ok := splitREPLArg(rest)
+	if !ok || valueText == "" {
+		return fmt.Errorf("usage: %v", replCommands["set"])
+	}
+	path := _this.resolve(pathArg)
+	value, err := parseCTEValue(valueText)
+	if err != nil {
+		return err
+	}
+
+	var document interface{}
+	if _, lookupErr := getPointerValue(_this.document, path); lookupErr == nil {
+		document, err = patchReplace(_this.document, path, value)
+	} else {
+		document, err = patchAdd(_this.document, path, value)
+	}
+	if err != nil {
+		return err
+	}
+	_this.document = document
+	_this.modified = true
+	return nil
+}
+
+func (_this *replSession) delete(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %v", replCommands["delete"])
+	}
+	path := _this.resolve(args[0])
+	if len(path) == 0 {
+		return fmt.Errorf("cannot delete the whole document")
+	}
+	parent, _ := getPointerValue(_this.document, path[:len(path)-1])
+	document, _, err := patchRemove(_this.document, path)
+	if err != nil {
+		return err
+	}
+	_this.document = document
+	_this.modified = true
+
+	if isLocationMovedByDelete(_this.location, path, parent) {
+		_this.location = path[:len(path)-1]
+	}
+	return nil
+}
+
+// A deletion moves the current location if it was inside the deleted value,
+// or after the deleted value in the same list (whose elements have shifted
+// down). In both cases, the session moves to the deleted value's parent.
+func isLocationMovedByDelete(location []string, deleted []string, parent interface{}) bool {
+	last := len(deleted) - 1
+	if len(location) <= last {
+		return false
+	}
+	for i := 0; i < last; i++ {
+		if location[i] != deleted[i] {
+			return false
+		}
+	}
+	if location[last] == deleted[last] {
+		return true
+	}
+	if _, isList := unmarked(parent).([]interface{}); !isList {
+		return false
+	}
+	deletedIndex, err := strconv.Atoi(deleted[last])
+	if err != nil {
+		return false
+	}
+	locationIndex, err := strconv.Atoi(location[last])
+	return err == nil && deletedIndex < locationIndex
+}
+
+func (_this *replSession) save(args []string) (err error) {
+	format := _this.srcFormat
+	file := _this.srcFile
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-fmt" && i+1 < len(args) {
+			i++
+			format = strings.ToLower(args[i])
+		} else {
+			file = args[i]
+		}
+	}
+	if knownSinks[format] == nil {
+		return fmt.Errorf("%v: Unknown destination format", format)
+	}
+	if file == "" || file == "-" {
+		return fmt.Errorf("no file to save to")
+	}
+
+	writer, err := os.Create(file)
+	if err != nil {
+		return
+	}
+	config := newEncoderConfig()
+	if textFormats[format] {
+		config.indentSpaces = 4
+	}
+	err = writeValue(_this.document, format, writer, &config)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+
+	_this.srcFile = file
+	_this.srcFormat = format
+	_this.modified = false
+	fmt.Fprintf(_this.out, "Saved %v as %v\n", file, format)
+	return
+}
+
+// Complete command names, or paths to children of the document.
+func (_this *replSession) Complete(line string) (word string, candidates []string) {
+	words := strings.Fields(line)
+	if len(words) == 0 || (len(words) == 1 && !strings.HasSuffix(line, " ")) {
+		if len(words) == 1 {
+			word = words[0]
+		}
+		for name := range replCommands {
+			if strings.HasPrefix(name, word) {
+				candidates = append(candidates, name)
+			}
+		}
+		sort.Strings(candidates)
+		return
+	}
+
+	if !strings.HasSuffix(line, " ") {
+		word = words[len(words)-1]
+	}
+	dirPart, prefix := "", word
+	if i := strings.LastIndex(word, "/"); i >= 0 {
+		dirPart, prefix = word[:i+1], word[i+1:]
+	}
+	container, err := getPointerValue(_this.document, _this.resolve(dirPart))
+	if err != nil || !isREPLContainer(container) {
+		return
+	}
+	for _, token := range childTokens(container) {
+		escaped := escapePathSegment(token)
+		if !strings.HasPrefix(escaped, prefix) {
+			continue
+		}
+		candidate := dirPart + escaped
+		if child, _ := getChild(container, token); isREPLContainer(child) {
+			candidate += "/"
+		}
+		candidates = append(candidates, candidate)
+	}
+	return
+}
+
+func isREPLContainer(value interface{}) bool {
+	switch unmarked(value).(type) {
+	case *orderedMap, []interface{}:
+		return true
+	}
+	return false
+}
+
+// Get the path tokens that refer to a container's children.
+func childTokens(container interface{}) (tokens []string) {
+	switch v := unmarked(container).(type) {
+	case *orderedMap:
+		for _, entry := range v.entries {
+			tokens = append(tokens, fmt.Sprintf("%v", unmarked(entry.key)))
+		}
+	case []interface{}:
+		for i := range v {
+			tokens = append(tokens, strconv.Itoa(i))
+		}
+	}
+	return
+}
+
+func formatPointer(path []string) string {
+	if len(path) == 0 {
+		return "/"
+	}
+	pointer := ""
+	for _, token := range path {
+		pointer += "/" + escapePathSegment(token)
+	}
+	return pointer
+}
+
+// Format a short preview of a value for listings.
+func formatREPLPreview(value interface{}) string {
+	switch v := unmarked(value).(type) {
+	case *orderedMap:
+		return fmt.Sprintf("(%v entries)", v.Len())
+	case []interface{}:
+		return fmt.Sprintf("(%v elements)", len(v))
+	}
+	preview := formatDiffValue(value)
+	if len(preview) > 60 {
+		preview = preview[:57] + "..."
+	}
+	return preview
+}
+
+// Parse a value written in CTE.
+func parseCTEValue(text string) (interface{}, error) {
+	document := fmt.Sprintf("c%v\n%v", version.ConciseEncodingVersion, text)
+	return buildDocument(cteSource, strings.NewReader(document))
+}
+
+// Split off the first whitespace-separated word.
+func splitREPLWord(line string) (word string, rest string) {
+	line = strings.TrimSpace(line)
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		return line[:i], strings.TrimSpace(line[i:])
+	}
+	return line, ""
+}
+
+// Split arguments on whitespace, honoring single and double quotes.
+func splitREPLArgs(line string) (args []string) {
+	for {
+		arg, rest, ok := splitREPLArg(line)
+		if !ok {
+			return
+		}
+		args = append(args, arg)
+		line = rest
+	}
+}
+
+// Split off the first argument of a line, honoring single and double quotes.
+// ok is false if the line has no more arguments.
+func splitREPLArg(line string) (arg string, rest string, ok bool) {
+	line = strings.TrimLeft(line, " \t")
+	var current strings.Builder
+	quote := rune(0)
+	for i, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ' ' || r == '\t':
+			return current.String(), strings.TrimSpace(line[i:]), true
+		default:
+			current.WriteRune(r)
+		}
+	}
+	return current.String(), "", line != ""
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+func TestREPLDeleteMovesLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		deleted  string
+		expected string
+	}{
+		// Deleting the current location or one of its ancestors
+		{"/a/1/b", "/a/1/b", "/a/1"},
+		{"/a/1/b", "/a/1", "/a"},
+		{"/a/1/b", "/a", "/"},
+		// Deleting an earlier element of a list on the path
+		{"/a/1/b", "/a/0", "/a"},
+		{"/a/2", "/a/0", "/a"},
+		// Deletions that don't affect the current location
+		{"/a/0/b", "/a/1", "/a/0/b"},
+		{"/a/1/b", "/a/1/c", "/a/1/b"},
+		{"/a/1/b", "/c", "/a/1/b"},
+		{"/c/y", "/c/x", "/c/y"},
+		{"/", "/a", "/"},
+	}
+
+	for _, test := range tests {
+		session := newREPLSession(parseJSON(t, `{"a": [{"b": 1, "c": 2}, {"b": 3, "c": 4}, 5], "c": {"x": 1, "y": 2}}`), "", "json")
+		session.out = io.Discard
+		session.location = session.resolve(test.location)
+		if err := session.delete([]string{test.deleted}); err != nil {
+			t.Errorf("delete %v: %v", test.deleted, err)
+			continue
+		}
+		if actual := formatPointer(session.location); actual != test.expected {
+			t.Errorf("at %v, delete %v: expected location %v but got %v",
+				test.location, test.deleted, test.expected, actual)
+		}
+	}
+}
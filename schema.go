@@ -0,0 +1,619 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cockroachdb/apd/v2"
+	compact_time "github.com/kstenerud/go-compact-time"
+)
+
+// A schema violation at a path in the document.
+type schemaViolation struct {
+	path       string
+	schemaPath string
+	message    string
+}
+
+// jsonSchema validates document trees against a JSON Schema (a subset of draft
+// 2020-12). Concise Encoding types are validated as their JSON equivalents:
+//
+//   - Times, UIDs, resource IDs, byte arrays, media and custom types are strings.
+//     Times satisfy the date-time, date and time formats, UIDs satisfy uuid, and
+//     resource IDs satisfy uri.
+//   - Typed arrays are arrays of their elements.
+//   - Nodes are objects with "value" and "children", and edges are objects with
+//     "source", "description" and "destination".
+//   - All numeric types are numbers, and are integers if they have no fractional
+//     part.
+//
+// Only local references ($ref starting with #) are supported.
+type jsonSchema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+const maxSchemaDepth = 500
+
+func newJSONSchema(root interface{}) *jsonSchema {
+	return &jsonSchema{
+		root:     root,
+		patterns: make(map[string]*regexp.Regexp),
+	}
+}
+
+// Validate a document, returning all violations.
+func (_this *jsonSchema) Validate(document interface{}) (violations []schemaViolation) {
+	_this.validate(document, "", _this.root, "#", 0, &violations)
+	return
+}
+
+func (_this *jsonSchema) isValid(value interface{}, schema interface{}, schemaPath string, depth int) bool {
+	var violations []schemaViolation
+	_this.validate(value, "", schema, schemaPath, depth, &violations)
+	return len(violations) == 0
+}
+
+func (_this *jsonSchema) validate(value interface{}, path string, schema interface{}, schemaPath string, depth int, violations *[]schemaViolation) {
+	report := func(keyword string, format string, args ...interface{}) {
+		*violations = append(*violations, schemaViolation{
+			path:       path,
+			schemaPath: schemaPath + "/" + keyword,
+			message:    fmt.Sprintf(format, args...),
+		})
+	}
+
+	if depth > maxSchemaDepth {
+		report("$ref", "schema recursion is too deep")
+		return
+	}
+
+	value = unmarked(value)
+	var s *orderedMap
+	switch v := unmarked(schema).(type) {
+	case bool:
+		if !v {
+			*violations = append(*violations, schemaViolation{path: path, schemaPath: schemaPath, message: "no value is allowed here"})
+		}
+		return
+	case *orderedMap:
+		s = v
+	default:
+		*violations = append(*violations, schemaViolation{path: path, schemaPath: schemaPath,
+			message: fmt.Sprintf("schema must be a map or boolean, not %v", typeCategory(schema))})
+		return
+	}
+	get := func(keyword string) (interface{}, bool) {
+		v, ok := s.Get(keyword)
+		return unmarked(v), ok
+	}
+	sub := func(keyword string) string { return schemaPath + "/" + keyword }
+
+	if ref, ok := get("$ref"); ok {
+		target, err := _this.resolveRef(ref)
+		if err != nil {
+			report("$ref", "%v", err)
+		} else {
+			_this.validate(value, path, target, fmt.Sprintf("%v", ref), depth+1, violations)
+		}
+	}
+
+	if types, ok := get("type"); ok {
+		matched := false
+		var names []string
+		for _, t := range asList(types) {
+			name := fmt.Sprintf("%v", unmarked(t))
+			names = append(names, name)
+			if schemaTypeMatches(value, name) {
+				matched = true
+			}
+		}
+		if !matched {
+			report("type", "expected %v, but got %v", strings.Join(names, " or "), typeCategory(value))
+		}
+	}
+
+	if enum, ok := get("enum"); ok {
+		found := false
+		for _, allowed := range asList(enum) {
+			if valuesEqual(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			report("enum", "%v is not one of the allowed values", formatDiffValue(value))
+		}
+	}
+	if constant, ok := get("const"); ok && !valuesEqual(value, constant) {
+		report("const", "expected %v", formatDiffValue(constant))
+	}
+
+	_this.validateNumber(value, get, report)
+	_this.validateString(value, get, report)
+
+	if list, ok := schemaArrayView(value); ok {
+		_this.validateArray(list, path, get, sub, report, depth, violations)
+	}
+	if object, ok := schemaObjectView(value); ok {
+		_this.validateObject(object, path, get, sub, report, depth, violations)
+	}
+
+	if allOf, ok := get("allOf"); ok {
+		for i, subschema := range asList(allOf) {
+			_this.validate(value, path, subschema, fmt.Sprintf("%v/%v", sub("allOf"), i), depth+1, violations)
+		}
+	}
+	if anyOf, ok := get("anyOf"); ok {
+		matched := false
+		for i, subschema := range asList(anyOf) {
+			if _this.isValid(value, subschema, fmt.Sprintf("%v/%v", sub("anyOf"), i), depth+1) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			report("anyOf", "does not match any of the allowed schemas")
+		}
+	}
+	if oneOf, ok := get("oneOf"); ok {
+		matches := 0
+		for i, subschema := range asList(oneOf) {
+			if _this.isValid(value, subschema, fmt.Sprintf("%v/%v", sub("oneOf"), i), depth+1) {
+				matches++
+			}
+		}
+		if matches != 1 {
+			report("oneOf", "matches %v of the schemas instead of exactly one", matches)
+		}
+	}
+	if not, ok := get("not"); ok && _this.isValid(value, not, sub("not"), depth+1) {
+		report("not", "must not match the schema")
+	}
+	if condition, ok := get("if"); ok {
+		if _this.isValid(value, condition, sub("if"), depth+1) {
+			if then, ok := get("then"); ok {
+				_this.validate(value, path, then, sub("then"), depth+1, violations)
+			}
+		} else if otherwise, ok := get("else"); ok {
+			_this.validate(value, path, otherwise, sub("else"), depth+1, violations)
+		}
+	}
+}
+
+func (_this *jsonSchema) validateNumber(value interface{}, get func(string) (interface{}, bool),
+	report func(string, string, ...interface{})) {
+
+	number, ok := toDecimal(value)
+	if !ok {
+		return
+	}
+	check := func(keyword string, accept func(cmp int) bool, description string) {
+		limit, ok := get(keyword)
+		if !ok {
+			return
+		}
+		limitNumber, ok := toDecimal(limit)
+		if !ok {
+			report(keyword, "%v must be a number", keyword)
+			return
+		}
+		if !accept(number.Cmp(limitNumber)) {
+			report(keyword, "%v must be %v %v", number, description, limitNumber)
+		}
+	}
+	check("minimum", func(cmp int) bool { return cmp >= 0 }, "at least")
+	check("maximum", func(cmp int) bool { return cmp <= 0 }, "at most")
+	check("exclusiveMinimum", func(cmp int) bool { return cmp > 0 }, "greater than")
+	check("exclusiveMaximum", func(cmp int) bool { return cmp < 0 }, "less than")
+
+	if multipleOf, ok := get("multipleOf"); ok {
+		divisor, ok := toDecimal(multipleOf)
+		if !ok || divisor.Sign() <= 0 {
+			report("multipleOf", "multipleOf must be a positive number")
+			return
+		}
+		remainder := new(apd.Decimal)
+		if _, err := apd.BaseContext.WithPrecision(1000).Rem(remainder, number, divisor); err != nil || !remainder.IsZero() {
+			report("multipleOf", "%v is not a multiple of %v", number, divisor)
+		}
+	}
+}
+
+func (_this *jsonSchema) validateString(value interface{}, get func(string) (interface{}, bool),
+	report func(string, string, ...interface{})) {
+
+	if format, ok := get("format"); ok {
+		if !matchesSchemaFormat(value, fmt.Sprintf("%v", format)) {
+			report("format", "%v is not a valid %v", formatDiffValue(value), format)
+		}
+	}
+
+	var str string
+	switch v := value.(type) {
+	case string:
+		str = v
+	case resourceID:
+		str = string(v)
+	case *customText:
+		str = v.data
+	default:
+		return
+	}
+
+	length := int64(utf8.RuneCountInString(str))
+	if limit, ok := getSchemaCount(get, "minLength"); ok && length < limit {
+		report("minLength", "length %v is shorter than %v", length, limit)
+	}
+	if limit, ok := getSchemaCount(get, "maxLength"); ok && length > limit {
+		report("maxLength", "length %v is longer than %v", length, limit)
+	}
+	if pattern, ok := get("pattern"); ok {
+		re, err := _this.compilePattern(fmt.Sprintf("%v", pattern))
+		if err != nil {
+			report("pattern", "%v", err)
+		} else if !re.MatchString(str) {
+			report("pattern", "%v does not match the pattern %v", formatDiffValue(str), pattern)
+		}
+	}
+}
+
+func (_this *jsonSchema) validateArray(list []interface{}, path string, get func(string) (interface{}, bool),
+	sub func(string) string, report func(string, string, ...interface{}), depth int, violations *[]schemaViolation) {
+
+	count := int64(len(list))
+	if limit, ok := getSchemaCount(get, "minItems"); ok && count < limit {
+		report("minItems", "has %v items, which is fewer than %v", count, limit)
+	}
+	if limit, ok := getSchemaCount(get, "maxItems"); ok && count > limit {
+		report("maxItems", "has %v items, which is more than %v", count, limit)
+	}
+	if unique, ok := get("uniqueItems"); ok && unique == true {
+	outer:
+		for i := range list {
+			for j := i + 1; j < len(list); j++ {
+				if valuesEqual(list[i], list[j]) {
+					report("uniqueItems", "items %v and %v are equal", i, j)
+					break outer
+				}
+			}
+		}
+	}
+
+	// Older drafts use items as a list, and additionalItems for the rest.
+	prefixKeyword, restKeyword := "prefixItems", "items"
+	prefixItems, hasPrefix := get("prefixItems")
+	if items, ok := get("items"); ok && !hasPrefix {
+		if _, isList := items.([]interface{}); isList {
+			prefixItems, hasPrefix = items, true
+			prefixKeyword, restKeyword = "items", "additionalItems"
+		}
+	}
+	prefixCount := 0
+	if hasPrefix {
+		for i, subschema := range asList(prefixItems) {
+			if i >= len(list) {
+				break
+			}
+			_this.validate(list[i], childPath(path, i), subschema, fmt.Sprintf("%v/%v", sub(prefixKeyword), i), depth+1, violations)
+			prefixCount++
+		}
+	}
+	if items, ok := get(restKeyword); ok {
+		for i := prefixCount; i < len(list); i++ {
+			_this.validate(list[i], childPath(path, i), items, sub(restKeyword), depth+1, violations)
+		}
+	}
+
+	if contains, ok := get("contains"); ok {
+		matches := int64(0)
+		for _, elem := range list {
+			if _this.isValid(elem, contains, sub("contains"), depth+1) {
+				matches++
+			}
+		}
+		minContains, ok := getSchemaCount(get, "minContains")
+		if !ok {
+			minContains = 1
+		}
+		if matches < minContains {
+			report("contains", "has %v matching items, which is fewer than %v", matches, minContains)
+		}
+		if maxContains, ok := getSchemaCount(get, "maxContains"); ok && matches > maxContains {
+			report("maxContains", "has %v matching items, which is more than %v", matches, maxContains)
+		}
+	}
+}
+
+func (_this *jsonSchema) validateObject(object *orderedMap, path string, get func(string) (interface{}, bool),
+	sub func(string) string, report func(string, string, ...interface{}), depth int, violations *[]schemaViolation) {
+
+	count := int64(object.Len())
+	if limit, ok := getSchemaCount(get, "minProperties"); ok && count < limit {
+		report("minProperties", "has %v properties, which is fewer than %v", count, limit)
+	}
+	if limit, ok := getSchemaCount(get, "maxProperties"); ok && count > limit {
+		report("maxProperties", "has %v properties, which is more than %v", count, limit)
+	}
+
+	if required, ok := get("required"); ok {
+		for _, name := range asList(required) {
+			if findMapToken(object, fmt.Sprintf("%v", unmarked(name))) < 0 {
+				report("required", "missing required property %v", formatDiffValue(unmarked(name)))
+			}
+		}
+	}
+	if dependentRequired, ok := get("dependentRequired"); ok {
+		if dependencies, ok := dependentRequired.(*orderedMap); ok {
+			for _, entry := range dependencies.entries {
+				if findMapToken(object, fmt.Sprintf("%v", entry.key)) < 0 {
+					continue
+				}
+				for _, name := range asList(unmarked(entry.value)) {
+					if findMapToken(object, fmt.Sprintf("%v", unmarked(name))) < 0 {
+						report("dependentRequired", "property %v requires property %v", entry.key, unmarked(name))
+					}
+				}
+			}
+		}
+	}
+
+	properties, _ := get("properties")
+	propertiesMap, _ := properties.(*orderedMap)
+	patternProperties, _ := get("patternProperties")
+	patternMap, _ := patternProperties.(*orderedMap)
+	additional, hasAdditional := get("additionalProperties")
+	propertyNames, hasPropertyNames := get("propertyNames")
+
+	for _, entry := range object.entries {
+		name := fmt.Sprintf("%v", unmarked(entry.key))
+		entryPath := childPath(path, name)
+		evaluated := false
+
+		if propertiesMap != nil {
+			if i := findMapToken(propertiesMap, name); i >= 0 {
+				_this.validate(entry.value, entryPath, propertiesMap.entries[i].value,
+					childPath(sub("properties"), name), depth+1, violations)
+				evaluated = true
+			}
+		}
+		if patternMap != nil {
+			for _, patternEntry := range patternMap.entries {
+				pattern := fmt.Sprintf("%v", patternEntry.key)
+				re, err := _this.compilePattern(pattern)
+				if err != nil {
+					report("patternProperties", "%v", err)
+					continue
+				}
+				if re.MatchString(name) {
+					_this.validate(entry.value, entryPath, patternEntry.value,
+						childPath(sub("patternProperties"), pattern), depth+1, violations)
+					evaluated = true
+				}
+			}
+		}
+		if !evaluated && hasAdditional {
+			_this.validate(entry.value, entryPath, additional, sub("additionalProperties"), depth+1, violations)
+		}
+		if hasPropertyNames && !_this.isValid(name, propertyNames, sub("propertyNames"), depth+1) {
+			report("propertyNames", "property name %v is not allowed", formatDiffValue(name))
+		}
+	}
+}
+
+func (_this *jsonSchema) compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := _this.patterns[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %v: %v", pattern, err)
+	}
+	_this.patterns[pattern] = re
+	return re, nil
+}
+
+func (_this *jsonSchema) resolveRef(ref interface{}) (interface{}, error) {
+	refString, ok := ref.(string)
+	if !ok || !strings.HasPrefix(refString, "#") {
+		return nil, fmt.Errorf("%v: only local references are supported", ref)
+	}
+	pointer, err := url.PathUnescape(refString[1:])
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", refString, err)
+	}
+	path, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	target, err := getPointerValue(_this.root, path)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", refString, err)
+	}
+	return target, nil
+}
+
+func asList(value interface{}) []interface{} {
+	if list, ok := value.([]interface{}); ok {
+		return list
+	}
+	return []interface{}{value}
+}
+
+func getSchemaCount(get func(string) (interface{}, bool), keyword string) (int64, bool) {
+	v, ok := get(keyword)
+	if !ok {
+		return 0, false
+	}
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case uint64:
+		return int64(n), true
+	default:
+		if d, ok := toDecimal(v); ok {
+			if i, err := d.Int64(); err == nil {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+}
+
+func schemaArrayView(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, true
+	case *typedArray:
+		return v.Elements(), true
+	default:
+		return nil, false
+	}
+}
+
+func schemaObjectView(value interface{}) (*orderedMap, bool) {
+	switch v := value.(type) {
+	case *orderedMap:
+		return v, true
+	case *nodeValue:
+		return newOrderedMap().Set("value", v.value).Set("children", v.children), true
+	case *edgeValue:
+		return newOrderedMap().
+			Set("source", v.source).
+			Set("description", v.description).
+			Set("destination", v.destination), true
+	default:
+		return nil, false
+	}
+}
+
+func schemaTypeMatches(value interface{}, typeName string) bool {
+	switch typeName {
+	case "null":
+		return value == nil
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		return typeCategory(value) == "number"
+	case "integer":
+		return isIntegral(value)
+	case "string":
+		switch value.(type) {
+		case string, resourceID, uidValue, compact_time.Time, []byte, *mediaValue, *customBinary, *customText:
+			return true
+		}
+		return false
+	case "array":
+		_, ok := schemaArrayView(value)
+		return ok
+	case "object":
+		_, ok := schemaObjectView(value)
+		return ok
+	default:
+		return false
+	}
+}
+
+func isIntegral(value interface{}) bool {
+	switch value.(type) {
+	case int64, uint64, *big.Int:
+		return true
+	}
+	if typeCategory(value) != "number" {
+		return false
+	}
+	d, ok := toDecimal(value)
+	if !ok || d.Form != apd.Finite {
+		return false
+	}
+	reduced := new(apd.Decimal)
+	reduced.Reduce(d)
+	return reduced.Exponent >= 0
+}
+
+var (
+	uuidPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+$`)
+)
+
+// The formats that matchesSchemaFormat() checks.
+var knownSchemaFormats = map[string]bool{
+	"date-time": true, "date": true, "time": true, "uuid": true, "email": true,
+	"ipv4": true, "ipv6": true, "uri": true, "iri": true,
+	"uri-reference": true, "iri-reference": true, "regex": true,
+}
+
+// Check a value against a format. Unknown formats are always accepted. Times,
+// UIDs and resource IDs only match the known formats that describe them.
+func matchesSchemaFormat(value interface{}, format string) bool {
+	if !knownSchemaFormats[format] {
+		return true
+	}
+	switch value.(type) {
+	case compact_time.Time:
+		return format == "date-time" || format == "date" || format == "time"
+	case uidValue:
+		return format == "uuid"
+	case resourceID:
+		return format == "uri" || format == "iri" || format == "uri-reference" || format == "iri-reference"
+	}
+	str, ok := value.(string)
+	if !ok {
+		return true
+	}
+
+	var err error
+	switch format {
+	case "date-time":
+		_, err = time.Parse(time.RFC3339Nano, str)
+	case "date":
+		_, err = time.Parse("2006-01-02", str)
+	case "time":
+		_, err = time.Parse("15:04:05.999999999Z07:00", str)
+	case "uuid":
+		return uuidPattern.MatchString(str)
+	case "email":
+		return emailPattern.MatchString(str)
+	case "ipv4":
+		ip := net.ParseIP(str)
+		return ip != nil && ip.To4() != nil && !strings.Contains(str, ":")
+	case "ipv6":
+		return net.ParseIP(str) != nil && strings.Contains(str, ":")
+	case "uri", "iri":
+		var u *url.URL
+		u, err = url.Parse(str)
+		return err == nil && u.IsAbs()
+	case "uri-reference", "iri-reference":
+		_, err = url.Parse(str)
+	case "regex":
+		_, err = regexp.Compile(str)
+	}
+	return err == nil
+}
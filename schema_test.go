@@ -0,0 +1,223 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kstenerud/go-concise-encoding/ce/events"
+
+	compact_time "github.com/kstenerud/go-compact-time"
+)
+
+func TestJSONSchema(t *testing.T) {
+	tests := []struct {
+		schema string
+		// A JSON document, or a document tree value for Concise Encoding types.
+		document interface{}
+		// Where the first violation is, or "" if the document is valid.
+		path       string
+		schemaPath string
+	}{
+		// Boolean schemas
+		{`true`, `{"a":1}`, ``, ``},
+		{`false`, `1`, ``, `#`},
+
+		// type
+		{`{"type":"string"}`, `"a"`, ``, ``},
+		{`{"type":"string"}`, `1`, ``, `#/type`},
+		{`{"type":["string","null"]}`, `null`, ``, ``},
+		{`{"type":"integer"}`, `1.0`, ``, ``},
+		{`{"type":"integer"}`, `1.5`, ``, `#/type`},
+		{`{"type":"number"}`, `1`, ``, ``},
+		{`{"type":"boolean"}`, `false`, ``, ``},
+		{`{"type":"array"}`, `{}`, ``, `#/type`},
+		{`{"type":"object"}`, `[]`, ``, `#/type`},
+
+		// enum and const
+		{`{"enum":[1,"a",null]}`, `1.0`, ``, ``},
+		{`{"enum":[1,"a",null]}`, `"b"`, ``, `#/enum`},
+		{`{"const":{"a":[1]}}`, `{"a":[1]}`, ``, ``},
+		{`{"const":{"a":[1]}}`, `{"a":[2]}`, ``, `#/const`},
+
+		// Numbers
+		{`{"minimum":1}`, `1`, ``, ``},
+		{`{"minimum":1}`, `0.5`, ``, `#/minimum`},
+		{`{"maximum":1}`, `1`, ``, ``},
+		{`{"maximum":1}`, `2`, ``, `#/maximum`},
+		{`{"exclusiveMinimum":1}`, `1.1`, ``, ``},
+		{`{"exclusiveMinimum":1}`, `1`, ``, `#/exclusiveMinimum`},
+		{`{"exclusiveMaximum":1}`, `1`, ``, `#/exclusiveMaximum`},
+		{`{"multipleOf":0.1}`, `0.3`, ``, ``},
+		{`{"multipleOf":0.1}`, `0.35`, ``, `#/multipleOf`},
+		{`{"multipleOf":10}`, `8085`, ``, `#/multipleOf`},
+		{`{"minimum":1}`, `"0"`, ``, ``},
+
+		// Strings
+		{`{"minLength":2}`, `"ab"`, ``, ``},
+		{`{"minLength":2}`, `"é"`, ``, `#/minLength`},
+		{`{"maxLength":1}`, `"é"`, ``, ``},
+		{`{"maxLength":1}`, `"ab"`, ``, `#/maxLength`},
+		{`{"pattern":"^a+$"}`, `"aaa"`, ``, ``},
+		{`{"pattern":"^a+$"}`, `"ab"`, ``, `#/pattern`},
+		{`{"pattern":"("}`, `"a"`, ``, `#/pattern`},
+		{`{"maxLength":1}`, `10`, ``, ``},
+
+		// format
+		{`{"format":"date-time"}`, `"2020-01-02T03:04:05.6Z"`, ``, ``},
+		{`{"format":"date-time"}`, `"yesterday"`, ``, `#/format`},
+		{`{"format":"date"}`, `"2020-01-02"`, ``, ``},
+		{`{"format":"date"}`, `"2020-13-02"`, ``, `#/format`},
+		{`{"format":"time"}`, `"03:04:05Z"`, ``, ``},
+		{`{"format":"uuid"}`, `"123e4567-e89b-12d3-a456-426614174000"`, ``, ``},
+		{`{"format":"uuid"}`, `"123e4567"`, ``, `#/format`},
+		{`{"format":"email"}`, `"a@example.com"`, ``, ``},
+		{`{"format":"email"}`, `"a.example.com"`, ``, `#/format`},
+		{`{"format":"ipv4"}`, `"192.168.0.1"`, ``, ``},
+		{`{"format":"ipv4"}`, `"::1"`, ``, `#/format`},
+		{`{"format":"ipv6"}`, `"::1"`, ``, ``},
+		{`{"format":"ipv6"}`, `"192.168.0.1"`, ``, `#/format`},
+		{`{"format":"uri"}`, `"https://example.com/a"`, ``, ``},
+		{`{"format":"uri"}`, `"/a"`, ``, `#/format`},
+		{`{"format":"uri-reference"}`, `"/a"`, ``, ``},
+		{`{"format":"regex"}`, `"^a+$"`, ``, ``},
+		{`{"format":"regex"}`, `"("`, ``, `#/format`},
+		{`{"format":"color"}`, `"anything"`, ``, ``},
+		{`{"format":"email"}`, `5`, ``, ``},
+
+		// Arrays
+		{`{"minItems":2}`, `[1,2]`, ``, ``},
+		{`{"minItems":2}`, `[1]`, ``, `#/minItems`},
+		{`{"maxItems":1}`, `[1,2]`, ``, `#/maxItems`},
+		{`{"uniqueItems":true}`, `[1,"1"]`, ``, ``},
+		{`{"uniqueItems":true}`, `[1,1.0]`, ``, `#/uniqueItems`},
+		{`{"items":{"type":"integer"}}`, `[1,2,"3"]`, `/2`, `#/items/type`},
+		{`{"prefixItems":[{"type":"string"}],"items":false}`, `["a"]`, ``, ``},
+		{`{"prefixItems":[{"type":"string"}],"items":false}`, `[1]`, `/0`, `#/prefixItems/0/type`},
+		{`{"prefixItems":[{"type":"string"}],"items":false}`, `["a",1]`, `/1`, `#/items`},
+		{`{"items":[{"type":"string"}],"additionalItems":{"type":"integer"}}`, `["a",1,2]`, ``, ``},
+		{`{"items":[{"type":"string"}],"additionalItems":{"type":"integer"}}`, `["a",1,"b"]`, `/2`, `#/additionalItems/type`},
+		{`{"contains":{"type":"string"}}`, `[1,"a"]`, ``, ``},
+		{`{"contains":{"type":"string"}}`, `[1,2]`, ``, `#/contains`},
+		{`{"contains":{"type":"string"},"minContains":2}`, `["a",1]`, ``, `#/contains`},
+		{`{"contains":{"type":"string"},"maxContains":1}`, `["a","b"]`, ``, `#/maxContains`},
+
+		// Objects
+		{`{"minProperties":1}`, `{}`, ``, `#/minProperties`},
+		{`{"maxProperties":1}`, `{"a":1,"b":2}`, ``, `#/maxProperties`},
+		{`{"required":["a","b"]}`, `{"a":1,"b":2}`, ``, ``},
+		{`{"required":["a","b"]}`, `{"a":1}`, ``, `#/required`},
+		{`{"dependentRequired":{"a":["b"]}}`, `{"c":1}`, ``, ``},
+		{`{"dependentRequired":{"a":["b"]}}`, `{"a":1}`, ``, `#/dependentRequired`},
+		{`{"properties":{"a":{"type":"string"}}}`, `{"a":"x","b":1}`, ``, ``},
+		{`{"properties":{"a":{"type":"string"}}}`, `{"a":1}`, `/a`, `#/properties/a/type`},
+		{`{"properties":{"a/b":{"type":"string"}}}`, `{"a/b":1}`, `/a~1b`, `#/properties/a~1b/type`},
+		{`{"patternProperties":{"^x-":{"type":"integer"}}}`, `{"x-a":1,"y":"z"}`, ``, ``},
+		{`{"patternProperties":{"^x-":{"type":"integer"}}}`, `{"x-a":"1"}`, `/x-a`, `#/patternProperties/^x-/type`},
+		{`{"properties":{"a":true},"patternProperties":{"^x-":true},"additionalProperties":false}`, `{"a":1,"x-b":2}`, ``, ``},
+		{`{"properties":{"a":true},"additionalProperties":false}`, `{"a":1,"b":2}`, `/b`, `#/additionalProperties`},
+		{`{"propertyNames":{"maxLength":3}}`, `{"abc":1}`, ``, ``},
+		{`{"propertyNames":{"maxLength":3}}`, `{"abcd":1}`, ``, `#/propertyNames`},
+
+		// Combinations
+		{`{"allOf":[{"type":"integer"},{"minimum":5}]}`, `5`, ``, ``},
+		{`{"allOf":[{"type":"integer"},{"minimum":5}]}`, `4`, ``, `#/allOf/1/minimum`},
+		{`{"anyOf":[{"type":"string"},{"minimum":5}]}`, `"a"`, ``, ``},
+		{`{"anyOf":[{"type":"string"},{"minimum":5}]}`, `4`, ``, `#/anyOf`},
+		{`{"oneOf":[{"type":"integer"},{"minimum":5}]}`, `4`, ``, ``},
+		{`{"oneOf":[{"type":"integer"},{"minimum":5}]}`, `5`, ``, `#/oneOf`},
+		{`{"oneOf":[{"type":"integer"},{"type":"string"}]}`, `true`, ``, `#/oneOf`},
+		{`{"not":{"type":"string"}}`, `1`, ``, ``},
+		{`{"not":{"type":"string"}}`, `"a"`, ``, `#/not`},
+		{`{"if":{"type":"integer"},"then":{"minimum":5},"else":{"type":"string"}}`, `5`, ``, ``},
+		{`{"if":{"type":"integer"},"then":{"minimum":5},"else":{"type":"string"}}`, `4`, ``, `#/then/minimum`},
+		{`{"if":{"type":"integer"},"then":{"minimum":5},"else":{"type":"string"}}`, `"a"`, ``, ``},
+		{`{"if":{"type":"integer"},"then":{"minimum":5},"else":{"type":"string"}}`, `true`, ``, `#/else/type`},
+
+		// $ref
+		{`{"$defs":{"pos":{"minimum":0}},"properties":{"a":{"$ref":"#/$defs/pos"}}}`, `{"a":1}`, ``, ``},
+		{`{"$defs":{"pos":{"minimum":0}},"properties":{"a":{"$ref":"#/$defs/pos"}}}`, `{"a":-1}`, `/a`, `#/$defs/pos/minimum`},
+		{`{"$defs":{"a/b c":{"type":"string"}},"$ref":"#/$defs/a~1b%20c"}`, `1`, ``, `#/$defs/a~1b%20c/type`},
+		{`{"$defs":{"list":{"type":"array","items":{"$ref":"#/$defs/list"}}},"$ref":"#/$defs/list"}`, `[[[]]]`, ``, ``},
+		{`{"$defs":{"list":{"type":"array","items":{"$ref":"#/$defs/list"}}},"$ref":"#/$defs/list"}`, `[[1]]`, `/0/0`, `#/$defs/list/type`},
+		{`{"$ref":"https://example.com/schema.json"}`, `1`, ``, `#/$ref`},
+		{`{"$ref":"#/$defs/missing"}`, `1`, ``, `#/$ref`},
+
+		// Concise Encoding types
+		{`{"type":"string","format":"date-time"}`, compact_time.Time{}, ``, ``},
+		{`{"type":"string","format":"email"}`, compact_time.Time{}, ``, `#/format`},
+		{`{"type":"string","format":"uuid"}`, uidValue{}, ``, ``},
+		{`{"format":"uri"}`, uidValue{}, ``, `#/format`},
+		{`{"format":"color"}`, uidValue{}, ``, ``},
+		{`{"type":"string","format":"uri"}`, resourceID("https://example.com"), ``, ``},
+		{`{"format":"date"}`, resourceID("https://example.com"), ``, `#/format`},
+		{`{"type":"string","maxLength":3}`, []byte{1, 2, 3, 4}, ``, ``},
+		{`{"type":"array","items":{"maximum":2}}`, &typedArray{arrayType: events.ArrayTypeUint8, elementCount: 3, data: []byte{1, 2, 3}}, `/2`, `#/items/maximum`},
+		{`{"type":"object","required":["value","children"],"properties":{"children":{"maxItems":1}}}`,
+			&nodeValue{value: "a", children: []interface{}{"b"}}, ``, ``},
+		{`{"type":"object","properties":{"children":{"maxItems":1}}}`,
+			&nodeValue{value: "a", children: []interface{}{"b", "c"}}, `/children`, `#/properties/children/maxItems`},
+		{`{"type":"object","required":["source","description","destination"],"properties":{"destination":{"type":"integer"}}}`,
+			&edgeValue{source: "a", description: "b", destination: int64(1)}, ``, ``},
+		{`{"properties":{"source":{"type":"integer"}}}`,
+			&edgeValue{source: "a", description: "b", destination: int64(1)}, `/source`, `#/properties/source/type`},
+	}
+
+	for _, test := range tests {
+		document := test.document
+		if text, ok := document.(string); ok {
+			document = parseJSON(t, text)
+		}
+		violations := newJSONSchema(parseJSON(t, test.schema)).Validate(document)
+		switch {
+		case test.schemaPath == "" && len(violations) > 0:
+			t.Errorf("%v with %v: expected no violations but got %v at %v (%v)", test.schema, test.document,
+				violations[0].message, violations[0].path, violations[0].schemaPath)
+		case test.schemaPath == "":
+		case len(violations) == 0:
+			t.Errorf("%v with %v: expected a violation at %v", test.schema, test.document, test.schemaPath)
+		case violations[0].path != test.path || violations[0].schemaPath != test.schemaPath:
+			t.Errorf("%v with %v: expected a violation at %v (%v) but got %v at %v (%v)", test.schema, test.document,
+				test.path, test.schemaPath, violations[0].message, violations[0].path, violations[0].schemaPath)
+		}
+	}
+}
+
+func TestJSONSchemaReportsAllViolations(t *testing.T) {
+	schema := parseJSON(t, `{"items":{"type":"integer","minimum":0}}`)
+	violations := newJSONSchema(schema).Validate(parseJSON(t, `[1,-1,"a",-2]`))
+	var paths []string
+	for _, violation := range violations {
+		paths = append(paths, violation.path+" "+violation.schemaPath)
+	}
+	expected := "/1 #/items/minimum, /2 #/items/type, /3 #/items/minimum"
+	if actual := strings.Join(paths, ", "); actual != expected {
+		t.Errorf("expected violations [%v] but got [%v]", expected, actual)
+	}
+}
+
+func TestJSONSchemaRecursionLimit(t *testing.T) {
+	violations := newJSONSchema(parseJSON(t, `{"$ref":"#"}`)).Validate(parseJSON(t, `1`))
+	if len(violations) != 1 || violations[0].message != "schema recursion is too deep" {
+		t.Errorf("expected a recursion violation but got %v", violations)
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kstenerud/go-describe"
+)
+
+var formatContentTypes = map[string]string{
+	"cbe":  "application/octet-stream",
+	"cte":  "text/plain; charset=utf-8",
+	"json": "application/json",
+	"xml":  "application/xml",
+	"qr":   "image/png",
+	"qrt":  "text/plain; charset=utf-8",
+}
+
+var qrStyleContentTypes = map[string]string{
+	"svg":  "image/svg+xml",
+	"eps":  "application/postscript",
+	"ansi": "text/plain; charset=utf-8",
+}
+
+// An httpError is returned by request handlers to respond with a status other
+// than 400 Bad Request.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (_this *httpError) Error() string { return _this.err.Error() }
+
+// Limits on the resources that a single request can use.
+type serverLimits struct {
+	maxBodySize  int64
+	maxImageSize uint
+	maxBorder    uint
+	maxIndent    uint
+	maxQRParts   uint
+}
+
+// conversionServer exposes the converters over HTTP. Request bodies and
+// encoder options are restricted by limits, and at most maxConcurrent requests
+// are processed at a time (others wait their turn once their body is read).
+type conversionServer struct {
+	limits         serverLimits
+	slots          chan struct{}
+	limitedSources map[string]eventSource
+}
+
+func newConversionServer(limits serverLimits, maxConcurrent int) *conversionServer {
+	return &conversionServer{
+		limits: limits,
+		slots:  make(chan struct{}, maxConcurrent),
+		limitedSources: map[string]eventSource{
+			"qr": limitedQRSource(limits.maxImageSize),
+		},
+	}
+}
+
+// Get the source for a format, with the server's limits applied.
+func (_this *conversionServer) source(format string) eventSource {
+	if source, ok := _this.limitedSources[format]; ok {
+		return source
+	}
+	return knownSources[format]
+}
+
+// Report whether err is due to a request exceeding the server's limits, which
+// is the client's fault rather than a problem with the document.
+func isLimitError(err error) bool {
+	return errors.Is(err, errTooManyQRParts) || errors.Is(err, errImageTooBig)
+}
+
+func (_this *conversionServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/convert", _this.endpoint(_this.convert))
+	mux.Handle("/validate", _this.endpoint(_this.validate))
+	mux.Handle("/detect", _this.endpoint(_this.detect))
+	mux.Handle("/print", _this.endpoint(_this.print))
+	return mux
+}
+
+type endpointHandler func(request *http.Request, body []byte, response *serverResponse) error
+
+type serverResponse struct {
+	status      int
+	contentType string
+	body        bytes.Buffer
+}
+
+// Wrap a handler with the method check and the size and concurrency limits.
+// The response is buffered so that a failure can still be reported properly.
+func (_this *conversionServer) endpoint(handler endpointHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Read the body before taking a slot, so that slow clients can't hold
+		// slots while trickling their bodies in.
+		maxBodySize := _this.limits.maxBodySize
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize+1))
+		if int64(len(body)) > maxBodySize {
+			http.Error(w, fmt.Sprintf("request body is larger than %v bytes", maxBodySize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case _this.slots <- struct{}{}:
+			defer func() { <-_this.slots }()
+		case <-r.Context().Done():
+			return
+		}
+
+		response := &serverResponse{status: http.StatusOK, contentType: "text/plain; charset=utf-8"}
+		if err = handler(r, body, response); err != nil {
+			status := http.StatusBadRequest
+			if httpErr, ok := err.(*httpError); ok {
+				status = httpErr.status
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set("Content-Type", response.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(response.body.Len()))
+		w.WriteHeader(response.status)
+		w.Write(response.body.Bytes())
+	})
+}
+
+// Get the source format from the "from" parameter, detecting it if absent.
+func requestSourceFormat(request *http.Request, reader *bufio.Reader) (srcFormat string, err error) {
+	if srcFormat = strings.ToLower(request.URL.Query().Get("from")); srcFormat == "" {
+		srcFormat, err = detectSrcFormat(reader)
+	}
+	return
+}
+
+// Get the encoder options from the request's parameters, which have the same
+// names and defaults as the convert command's options. Options that would use
+// excessive resources are rejected.
+func (_this *conversionServer) requestEncoderConfig(request *http.Request) (config encoderConfig, err error) {
+	query := request.URL.Query()
+	config = newEncoderConfig()
+	config.qrMaxParts = _this.limits.maxQRParts
+	uintParam := func(name string, dst *uint, max uint) {
+		if value := query.Get(name); value != "" && err == nil {
+			var v uint64
+			if v, err = strconv.ParseUint(value, 10, 32); err != nil {
+				err = fmt.Errorf("%v: invalid value for %v", value, name)
+				return
+			}
+			if v > uint64(max) {
+				err = fmt.Errorf("%v: %v must be at most %v", value, name, max)
+				return
+			}
+			*dst = uint(v)
+		}
+	}
+	boolParam := func(name string) bool {
+		v, _ := strconv.ParseBool(query.Get(name))
+		return v
+	}
+
+	var indent uint
+	uintParam("i", &indent, _this.limits.maxIndent)
+	config.indentSpaces = int(indent)
+	uintParam("is", &config.imageSize, _this.limits.maxImageSize)
+	uintParam("e", &config.errorCorrection, math.MaxUint32)
+	uintParam("b", &config.borderSize, _this.limits.maxBorder)
+	uintParam("qs", &config.qrPartSize, math.MaxUint32)
+	if err != nil {
+		return
+	}
+	config.canonical = boolParam("canonical")
+	config.invertText = boolParam("I")
+	if config.errorCorrection > 3 {
+		err = fmt.Errorf("error correction max is 3")
+		return
+	}
+	config.qrStyle = strings.ToLower(query.Get("qf"))
+	if config.qrStyle != "" && !isKnownQRStyle(config.qrStyle) {
+		err = fmt.Errorf("%v: Unknown QR style", config.qrStyle)
+	}
+	return
+}
+
+// Write a report in the format given by the "o" parameter (JSON by default).
+func (_this *conversionServer) writeReport(request *http.Request, report interface{}, response *serverResponse) error {
+	format := strings.ToLower(request.URL.Query().Get("o"))
+	if format == "" {
+		format = "json"
+	}
+	if knownSinks[format] == nil {
+		return fmt.Errorf("%v: Unknown output format", format)
+	}
+	config, err := _this.requestEncoderConfig(request)
+	if err != nil {
+		return err
+	}
+	response.contentType = formatContentTypes[format]
+	if err = writeValue(report, format, &response.body, &config); err != nil {
+		return err
+	}
+	if textFormats[format] {
+		response.body.WriteByte('\n')
+	}
+	return nil
+}
+
+// POST /convert?from=<format>&to=<format>
+func (_this *conversionServer) convert(request *http.Request, body []byte, response *serverResponse) error {
+	dstFormat := strings.ToLower(request.URL.Query().Get("to"))
+	if dstFormat == "" {
+		return fmt.Errorf("the \"to\" parameter is required")
+	}
+	config, err := _this.requestEncoderConfig(request)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(bytes.NewReader(body))
+	srcFormat, err := requestSourceFormat(request, reader)
+	if err != nil {
+		return err
+	}
+	source := _this.source(srcFormat)
+	if source == nil {
+		return fmt.Errorf("%v: Unknown source format", srcFormat)
+	}
+	sink := knownSinks[dstFormat]
+	if sink == nil {
+		return fmt.Errorf("%v: Unknown destination format", dstFormat)
+	}
+	if err = pipe(source, reader, sink, &response.body, &config); err != nil {
+		if isLimitError(err) {
+			return err
+		}
+		return &httpError{status: http.StatusUnprocessableEntity, err: err}
+	}
+
+	response.contentType = formatContentTypes[dstFormat]
+	if contentType, ok := qrStyleContentTypes[config.qrStyle]; ok {
+		response.contentType = contentType
+	}
+	return nil
+}
+
+// POST /validate?from=<format>&o=<report format>
+// The status is 422 Unprocessable Entity if the document is invalid.
+func (_this *conversionServer) validate(request *http.Request, body []byte, response *serverResponse) error {
+	validator := &cmdValidate{
+		srcFormat: strings.ToLower(request.URL.Query().Get("from")),
+		sourceFor: _this.source,
+	}
+	if validator.srcFormat != "" && knownSources[validator.srcFormat] == nil {
+		return fmt.Errorf("%v: Unknown source format", validator.srcFormat)
+	}
+	report := validator.validateReader("-", bytes.NewReader(body))
+	report.Delete("file")
+	if valid, _ := report.Get("valid"); valid != true {
+		response.status = http.StatusUnprocessableEntity
+	}
+	return _this.writeReport(request, report, response)
+}
+
+// POST /detect?o=<report format>
+func (_this *conversionServer) detect(request *http.Request, body []byte, response *serverResponse) error {
+	report := detectReader("-", bytes.NewReader(body))
+	report.Delete("file")
+	if errorMessage, ok := report.Get("error"); ok {
+		return &httpError{status: http.StatusUnprocessableEntity, err: fmt.Errorf("%v", errorMessage)}
+	}
+	return _this.writeReport(request, report, response)
+}
+
+// POST /print?from=<format>&i=<indent>
+func (_this *conversionServer) print(request *http.Request, body []byte, response *serverResponse) error {
+	reader := bufio.NewReader(bytes.NewReader(body))
+	srcFormat, err := requestSourceFormat(request, reader)
+	if err != nil {
+		return err
+	}
+	decode, err := getDecoder(srcFormat)
+	if err != nil {
+		return err
+	}
+	if source, ok := _this.limitedSources[srcFormat]; ok {
+		decode = sourceDecoder(source)
+	}
+	config, err := _this.requestEncoderConfig(request)
+	if err != nil {
+		return err
+	}
+	value, err := decode(reader)
+	if err != nil {
+		if isLimitError(err) {
+			return err
+		}
+		return &httpError{status: http.StatusUnprocessableEntity, err: err}
+	}
+	if value == nil {
+		response.body.WriteString("<nil>\n")
+	} else {
+		response.body.WriteString(describe.Describe(value, config.indentSpaces) + "\n")
+	}
+	return nil
+}
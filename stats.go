@@ -0,0 +1,387 @@
+// Copyright 2020 Karl Stenerud
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package main
+
+import (
+	"io"
+	"math/big"
+	"sort"
+
+	"github.com/kstenerud/go-concise-encoding/ce/events"
+
+	"github.com/cockroachdb/apd/v2"
+	compact_float "github.com/kstenerud/go-compact-float"
+	compact_time "github.com/kstenerud/go-compact-time"
+)
+
+// How many of the largest containers to report
+const statsLargestContainerCount = 10
+
+// The order that categories are reported in
+var statsCategories = []string{
+	"header", "null", "boolean", "integer", "float", "string", "resource-id", "uid", "time",
+	"bytes", "typed-array", "media", "custom", "map", "list", "record-type", "record",
+	"node", "edge", "marker", "reference", "constant", "comment", "padding",
+}
+
+type statsContainer struct {
+	path     string
+	category string
+	count    int
+}
+
+// byteCountingReader passes through at most one byte per read, so that a
+// decoder reading from it never gets ahead of the events it has produced.
+// If capture is set, the bytes read are kept until taken by TakeCaptured().
+type byteCountingReader struct {
+	reader   io.Reader
+	offset   int64
+	capture  bool
+	captured []byte
+}
+
+func (_this *byteCountingReader) Read(p []byte) (n int, err error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	n, err = _this.reader.Read(p)
+	_this.offset += int64(n)
+	if _this.capture {
+		_this.captured = append(_this.captured, p[:n]...)
+	}
+	return
+}
+
+// Get the bytes read since the last call, and the offset they started at.
+func (_this *byteCountingReader) TakeCaptured() (captured []byte, offset int64) {
+	captured = _this.captured
+	offset = _this.offset - int64(len(captured))
+	_this.captured = nil
+	return
+}
+
+// statsCollector gathers statistics about the composition of a document.
+type statsCollector struct {
+	nullEventReceiver
+	tracker     *pathTracker
+	byteCounter *byteCountingReader
+	lastOffset  int64
+
+	counts          map[string]int
+	bytes           map[string]int64
+	maxDepth        int
+	frames          []*statsContainer
+	largest         []*statsContainer
+	stringLengths   map[int]int
+	arrayCategory   string
+	arrayLength     uint64
+	arrayBytesLeft  uint64
+	arrayType       events.ArrayType
+	arrayMoreChunks bool
+	inRemoteRef     bool
+}
+
+// Create a stats collector. If byteCounter is not nil, the bytes that each
+// type consumes are also measured.
+func newStatsCollector(byteCounter *byteCountingReader) *statsCollector {
+	collector := &statsCollector{
+		byteCounter:   byteCounter,
+		counts:        make(map[string]int),
+		bytes:         make(map[string]int64),
+		stringLengths: make(map[int]int),
+	}
+	collector.tracker = newPathTracker(collector)
+	return collector
+}
+
+// Get the receiver that events should be sent to.
+func (_this *statsCollector) Receiver() events.DataEventReceiver {
+	return _this.tracker
+}
+
+// Attribute the bytes consumed since the last event to a category.
+func (_this *statsCollector) attribute(category string) {
+	if _this.byteCounter == nil {
+		return
+	}
+	_this.bytes[category] += _this.byteCounter.offset - _this.lastOffset
+	_this.lastOffset = _this.byteCounter.offset
+}
+
+func (_this *statsCollector) onValue(category string) {
+	if _this.inRemoteRef {
+		_this.inRemoteRef = false
+		_this.attribute("reference")
+		return
+	}
+	_this.attribute(category)
+	_this.counts[category]++
+	if len(_this.frames) > 0 {
+		_this.frames[len(_this.frames)-1].count++
+	}
+}
+
+func (_this *statsCollector) onString(category string, length int) {
+	_this.onValue(category)
+	if category == "string" {
+		_this.stringLengths[stringLengthBucket(length)]++
+	}
+}
+
+// Strings are grouped by length into buckets of powers of two: 0, 1, 2-3, 4-7...
+func stringLengthBucket(length int) int {
+	bucket := 0
+	for length > 0 {
+		bucket++
+		length >>= 1
+	}
+	return bucket
+}
+
+func stringLengthBucketRange(bucket int) (min, max int) {
+	if bucket == 0 {
+		return 0, 0
+	}
+	return 1 << (bucket - 1), 1<<bucket - 1
+}
+
+func arrayCategory(arrayType events.ArrayType) string {
+	switch arrayType {
+	case events.ArrayTypeString:
+		return "string"
+	case events.ArrayTypeResourceID:
+		return "resource-id"
+	case events.ArrayTypeUint8:
+		return "bytes"
+	case events.ArrayTypeCustomText:
+		return "custom"
+	default:
+		return "typed-array"
+	}
+}
+
+func (_this *statsCollector) beginContainer(category string) {
+	_this.onValue(category)
+	_this.frames = append(_this.frames, &statsContainer{path: _this.tracker.Path(), category: category})
+	if len(_this.frames) > _this.maxDepth {
+		_this.maxDepth = len(_this.frames)
+	}
+}
+
+func (_this *statsCollector) OnBeginDocument() { _this.attribute("header") }
+func (_this *statsCollector) OnVersion(uint64) { _this.attribute("header") }
+func (_this *statsCollector) OnEndDocument()   { _this.attribute("header") }
+
+func (_this *statsCollector) OnPadding() {
+	_this.attribute("padding")
+	_this.counts["padding"]++
+}
+
+func (_this *statsCollector) OnComment(bool, []byte) {
+	_this.attribute("comment")
+	_this.counts["comment"]++
+}
+
+func (_this *statsCollector) OnNull()                             { _this.onValue("null") }
+func (_this *statsCollector) OnBoolean(bool)                      { _this.onValue("boolean") }
+func (_this *statsCollector) OnTrue()                             { _this.onValue("boolean") }
+func (_this *statsCollector) OnFalse()                            { _this.onValue("boolean") }
+func (_this *statsCollector) OnPositiveInt(uint64)                { _this.onValue("integer") }
+func (_this *statsCollector) OnNegativeInt(uint64)                { _this.onValue("integer") }
+func (_this *statsCollector) OnInt(int64)                         { _this.onValue("integer") }
+func (_this *statsCollector) OnBigInt(*big.Int)                   { _this.onValue("integer") }
+func (_this *statsCollector) OnFloat(float64)                     { _this.onValue("float") }
+func (_this *statsCollector) OnBigFloat(*big.Float)               { _this.onValue("float") }
+func (_this *statsCollector) OnDecimalFloat(compact_float.DFloat) { _this.onValue("float") }
+func (_this *statsCollector) OnBigDecimalFloat(*apd.Decimal)      { _this.onValue("float") }
+func (_this *statsCollector) OnNan(bool)                          { _this.onValue("float") }
+func (_this *statsCollector) OnUID([]byte)                        { _this.onValue("uid") }
+func (_this *statsCollector) OnTime(compact_time.Time)            { _this.onValue("time") }
+func (_this *statsCollector) OnMedia(string, []byte)              { _this.onValue("media") }
+func (_this *statsCollector) OnCustomBinary(uint64, []byte)       { _this.onValue("custom") }
+func (_this *statsCollector) OnCustomText(uint64, string)         { _this.onValue("custom") }
+func (_this *statsCollector) OnReferenceLocal([]byte)             { _this.onValue("reference") }
+func (_this *statsCollector) OnConstant([]byte)                   { _this.onValue("constant") }
+func (_this *statsCollector) OnList()                             { _this.beginContainer("list") }
+func (_this *statsCollector) OnMap()                              { _this.beginContainer("map") }
+func (_this *statsCollector) OnNode()                             { _this.beginContainer("node") }
+func (_this *statsCollector) OnEdge()                             { _this.beginContainer("edge") }
+func (_this *statsCollector) OnRecord([]byte)                     { _this.beginContainer("record") }
+func (_this *statsCollector) OnStringlikeArray(t events.ArrayType, s string) {
+	_this.onString(arrayCategory(t), len(s))
+}
+
+func (_this *statsCollector) OnArray(arrayType events.ArrayType, elementCount uint64, data []byte) {
+	_this.onString(arrayCategory(arrayType), len(data))
+}
+
+func (_this *statsCollector) OnRecordType([]byte) {
+	_this.attribute("record-type")
+	_this.counts["record-type"]++
+	_this.frames = append(_this.frames, &statsContainer{category: "record-type"})
+}
+
+func (_this *statsCollector) OnMarker([]byte) {
+	_this.attribute("marker")
+	_this.counts["marker"]++
+}
+
+func (_this *statsCollector) OnReferenceRemote() {
+	_this.onValue("reference")
+	_this.inRemoteRef = true
+}
+
+func (_this *statsCollector) beginArray(category string, arrayType events.ArrayType) {
+	_this.attribute(category)
+	_this.arrayCategory = category
+	_this.arrayType = arrayType
+	_this.arrayLength = 0
+}
+
+func (_this *statsCollector) OnArrayBegin(arrayType events.ArrayType) {
+	_this.beginArray(arrayCategory(arrayType), arrayType)
+}
+
+func (_this *statsCollector) OnMediaBegin(string) {
+	_this.beginArray("media", events.ArrayTypeUint8)
+}
+
+func (_this *statsCollector) OnCustomBegin(arrayType events.ArrayType, _ uint64) {
+	_this.beginArray("custom", arrayType)
+}
+
+func (_this *statsCollector) OnArrayChunk(length uint64, moreChunksFollow bool) {
+	_this.attribute(_this.arrayCategory)
+	_this.arrayBytesLeft = arrayByteCount(_this.arrayType, length)
+	_this.arrayMoreChunks = moreChunksFollow
+	if _this.arrayBytesLeft == 0 && !moreChunksFollow {
+		_this.endArray()
+	}
+}
+
+func (_this *statsCollector) OnArrayData(data []byte) {
+	_this.arrayLength += uint64(len(data))
+	_this.arrayBytesLeft -= uint64(len(data))
+	if _this.arrayBytesLeft == 0 && !_this.arrayMoreChunks {
+		_this.endArray()
+	}
+	_this.attribute(_this.arrayCategory)
+}
+
+func (_this *statsCollector) endArray() {
+	if _this.inRemoteRef {
+		_this.inRemoteRef = false
+		return
+	}
+	_this.counts[_this.arrayCategory]++
+	if len(_this.frames) > 0 {
+		_this.frames[len(_this.frames)-1].count++
+	}
+	if _this.arrayCategory == "string" {
+		_this.stringLengths[stringLengthBucket(int(_this.arrayLength))]++
+	}
+}
+
+func (_this *statsCollector) OnEndContainer() {
+	frame := _this.frames[len(_this.frames)-1]
+	_this.frames = _this.frames[:len(_this.frames)-1]
+	_this.attribute(frame.category)
+	if frame.category == "record-type" {
+		return
+	}
+	if frame.category == "map" {
+		frame.count /= 2
+	}
+	_this.addLargest(frame)
+}
+
+func (_this *statsCollector) addLargest(container *statsContainer) {
+	if len(_this.largest) == statsLargestContainerCount &&
+		container.count <= _this.largest[len(_this.largest)-1].count {
+		return
+	}
+	_this.largest = append(_this.largest, container)
+	sort.SliceStable(_this.largest, func(i, j int) bool {
+		return _this.largest[i].count > _this.largest[j].count
+	})
+	if len(_this.largest) > statsLargestContainerCount {
+		_this.largest = _this.largest[:statsLargestContainerCount]
+	}
+}
+
+// Get a report of the statistics gathered.
+func (_this *statsCollector) Report() *orderedMap {
+	report := newOrderedMap()
+	report.Set("maxDepth", _this.maxDepth)
+
+	counts := newOrderedMap()
+	total := 0
+	for _, category := range statsCategories {
+		if count := _this.counts[category]; count > 0 {
+			counts.Set(category, count)
+			if category != "comment" && category != "padding" && category != "marker" && category != "record-type" {
+				total += count
+			}
+		}
+	}
+	report.Set("values", total)
+	report.Set("counts", counts)
+
+	largest := []interface{}{}
+	for _, container := range _this.largest {
+		path := container.path
+		if path == "" {
+			path = "/"
+		}
+		largest = append(largest, newOrderedMap().
+			Set("path", path).
+			Set("type", container.category).
+			Set("entries", container.count))
+	}
+	report.Set("largestContainers", largest)
+
+	var buckets []int
+	for bucket := range _this.stringLengths {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+	histogram := []interface{}{}
+	for _, bucket := range buckets {
+		min, max := stringLengthBucketRange(bucket)
+		histogram = append(histogram, newOrderedMap().
+			Set("min", min).
+			Set("max", max).
+			Set("count", _this.stringLengths[bucket]))
+	}
+	report.Set("stringLengths", histogram)
+
+	if _this.byteCounter != nil {
+		byteCounts := newOrderedMap()
+		for _, category := range statsCategories {
+			if count := _this.bytes[category]; count > 0 {
+				byteCounts.Set(category, count)
+			}
+		}
+		report.Set("totalBytes", _this.byteCounter.offset)
+		report.Set("bytes", byteCounts)
+	}
+	return report
+}